@@ -0,0 +1,171 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/store"
+)
+
+const (
+	// autosaveDelay is how long the list must go without changes before it
+	// is saved, so a burst of edits is saved once.
+	autosaveDelay = 2 * time.Second
+
+	// autosaveMaxWait is the longest a change waits to be saved, however
+	// steadily the list keeps changing.
+	autosaveMaxWait = 30 * time.Second
+)
+
+// saveEvent asks the event loop to save the list if it has unsaved changes.
+type saveEvent struct {
+	tcell.EventTime
+}
+
+// stopEvent asks the event loop to save the list and exit, because the
+// process received a signal to stop.
+type stopEvent struct {
+	tcell.EventTime
+	signal os.Signal
+}
+
+// includesVector reports whether vv includes every write in other.
+func includesVector(vv, other replicatedtodo.VersionVector) bool {
+	return vv.IncludesAll(slices.Collect(maps.Values(other)))
+}
+
+// markSaved records that the list as it is now is saved.
+func (m *listModel) markSaved() {
+	m.saved = m.items.VersionVector()
+}
+
+// Dirty reports whether the list has changes that have not been saved.
+func (m *listModel) Dirty() bool {
+	return !includesVector(m.saved, m.items.VersionVector())
+}
+
+// saveDelay returns how long to wait after a change before saving the
+// list. Appending to a journal is cheap, so every change is saved to one
+// as it is made.
+func (m *listModel) saveDelay() time.Duration {
+	if _, ok := m.store.(*store.Journal); ok {
+		return 0
+	}
+	return autosaveDelay
+}
+
+// autosaver schedules a save a delay after the last change to a list by
+// posting a saveEvent to the screen, or maxWait after the first change
+// since the last save if the list keeps changing until then.
+type autosaver struct {
+	timer   *time.Timer
+	delay   time.Duration
+	maxWait time.Duration
+
+	mu sync.Mutex
+	// deadline is when the changes waiting to be saved must be saved by,
+	// or zero if none are waiting.
+	deadline time.Time
+}
+
+func newAutosaver(s tcell.Screen, delay time.Duration) *autosaver {
+	a := &autosaver{delay: delay, maxWait: autosaveMaxWait}
+	a.timer = time.AfterFunc(delay, func() {
+		a.mu.Lock()
+		a.deadline = time.Time{}
+		a.mu.Unlock()
+		ev := &saveEvent{}
+		ev.SetEventNow()
+		_ = s.PostEvent(ev)
+	})
+	a.timer.Stop()
+	return a
+}
+
+// changed restarts the countdown to the next save, unless that would take
+// it past the deadline set by the first change since the last save.
+func (a *autosaver) changed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.deadline.IsZero() {
+		a.deadline = now.Add(a.maxWait)
+	}
+	a.timer.Reset(min(a.delay, a.deadline.Sub(now)))
+}
+
+func (a *autosaver) stop() {
+	a.timer.Stop()
+}
+
+// notifyStop posts a stopEvent to the screen when the process is asked to
+// stop with SIGTERM, or its terminal goes away with SIGHUP. The returned
+// function stops watching for them.
+func notifyStop(s tcell.Screen) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			ev := &stopEvent{signal: sig}
+			ev.SetEventNow()
+			_ = s.PostEvent(ev)
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// drawWithSaveStatus draws model, then the save status of listModel on the
+// bottom row, which it keeps for itself: model draws on a screen that ends
+// above it, so what model draws at its bottom cannot collide with it.
+func drawWithSaveStatus(s tcell.Screen, model model, listModel *listModel) {
+	model.Draw(aboveStatus{s})
+	drawSaveStatus(s, listModel)
+}
+
+// aboveStatus is a screen less its bottom row, which holds the save status.
+type aboveStatus struct {
+	tcell.Screen
+}
+
+// Size returns the size of the screen above the status row.
+func (s aboveStatus) Size() (width, height int) {
+	width, height = s.Screen.Size()
+	return width, max(0, height-1)
+}
+
+// SetContent sets a cell above the status row, ignoring cells below it.
+func (s aboveStatus) SetContent(x, y int, primary rune, combining []rune, style tcell.Style) {
+	if _, height := s.Size(); y < height {
+		s.Screen.SetContent(x, y, primary, combining, style)
+	}
+}
+
+// drawSaveStatus shows at the right of the bottom row whether the list has
+// unsaved changes, or why it could not be saved.
+func drawSaveStatus(s tcell.Screen, m *listModel) {
+	style := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset)
+	var status string
+	switch {
+	case m.saveErr != nil:
+		status = "save failed: " + m.saveErr.Error()
+		style = style.Foreground(tcell.ColorRed)
+	case m.Dirty():
+		status = "unsaved"
+	default:
+		status = "saved"
+	}
+	screenExtent := ScreenExtent(s)
+	col := max(0, screenExtent.width-len([]rune(status)))
+	drawText(s, bounds{position{col: col, row: screenExtent.height - 1}, extent{width: screenExtent.width - col, height: 1}},
+		style, status)
+}
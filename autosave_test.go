@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/store"
+)
+
+func newTestScreen(t *testing.T) tcell.SimulationScreen {
+	t.Helper()
+	s := tcell.NewSimulationScreen("")
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init() error: %s", err)
+	}
+	t.Cleanup(s.Fini)
+	return s
+}
+
+// pollEvent returns the next event posted to s, or nil if none arrives
+// within timeout.
+func pollEvent(s tcell.Screen, timeout time.Duration) tcell.Event {
+	events := make(chan tcell.Event, 1)
+	go func() {
+		events <- s.PollEvent()
+	}()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestDirty(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m := openTestModel(t, store.NewMemory(), replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	m.markSaved()
+	if m.Dirty() {
+		t.Errorf("Dirty() after markSaved() = true, want false")
+	}
+	m.newTodo("todo")
+	if !m.Dirty() {
+		t.Errorf("Dirty() after adding an item = false, want true")
+	}
+	m.markSaved()
+	if m.Dirty() {
+		t.Errorf("Dirty() after markSaved() = true, want false")
+	}
+	m.items.SetTitle(m.items.Items()[0].ID, "edited")
+	if !m.Dirty() {
+		t.Errorf("Dirty() after editing an item = false, want true")
+	}
+}
+
+func TestAutosaverPostsSaveEvent(t *testing.T) {
+	s := newTestScreen(t)
+	a := newAutosaver(s, 10*time.Millisecond)
+	defer a.stop()
+	a.changed()
+	if ev, ok := pollEvent(s, time.Second).(*saveEvent); !ok {
+		t.Errorf("event after a change = %T, want *saveEvent", ev)
+	}
+}
+
+func TestAutosaverMaxWait(t *testing.T) {
+	s := newTestScreen(t)
+	a := newAutosaver(s, time.Hour)
+	a.maxWait = 50 * time.Millisecond
+	defer a.stop()
+
+	// Changes keep coming faster than the delay, so only the maximum wait
+	// lets a save through.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				a.changed()
+			}
+		}
+	}()
+	if ev, ok := pollEvent(s, time.Second).(*saveEvent); !ok {
+		t.Errorf("event while changes keep coming = %T, want *saveEvent", ev)
+	}
+}
+
+func TestRunSavesOnStop(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	st := store.NewMemory()
+	m := openTestModel(t, st, replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	m.markSaved()
+	m.newTodo("todo")
+
+	s := newTestScreen(t)
+	ev := &stopEvent{signal: syscall.SIGTERM}
+	ev.SetEventNow()
+	if err := s.PostEvent(ev); err != nil {
+		t.Fatalf("PostEvent() error: %s", err)
+	}
+	run(s, m, m)
+
+	saved, err := st.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if got := len(saved.Items()); got != 1 {
+		t.Errorf("saved items after stopping = %d, want 1", got)
+	}
+	if m.Dirty() {
+		t.Errorf("Dirty() after stopping = true, want false")
+	}
+}
+
+func TestSaveStatusHasItsOwnRow(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m := openTestModel(t, store.NewMemory(), replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	m.newTodo("todo")
+	m.cursor = &m.items.Items()[0].ID
+	s := newTestScreen(t)
+	s.SetSize(40, 10)
+
+	for name, view := range map[string]model{
+		"list":   m,
+		"detail": typeKeys(m, tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)),
+	} {
+		s.Clear()
+		drawWithSaveStatus(s, view, m)
+		rows := strings.Split(screenText(s), "\n")
+		if last := strings.TrimSpace(rows[len(rows)-1]); last != "unsaved" {
+			t.Errorf("%s view bottom row = %q, want only the save status", name, last)
+		}
+		if above := rows[len(rows)-2]; strings.TrimSpace(above) == "" {
+			t.Errorf("%s view drew nothing above the save status", name)
+		}
+	}
+}
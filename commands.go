@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/gitstore"
+	"github.com/matta/sift/internal/peersync"
+	"github.com/matta/sift/internal/safefile"
+	"github.com/matta/sift/internal/store"
+)
+
+// runCommand runs the command named by args[0] with the remaining
+// arguments.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "sync":
+		return runSync(args[1:])
+	case "merge-driver":
+		return runMergeDriver(args[1:])
+	case "restore":
+		return runRestore(args[1:])
+	case "set":
+		return runSet(args[1:])
+	case "rebalance":
+		return runRebalance(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// loadListModel loads the user's list as a replica of this installation
+// that no other running process writes as.
+func loadListModel() (listModel, error) {
+	identity, err := LoadReplicaIdentity()
+	if err != nil {
+		slog.Error("Error loading replica identity", slog.Any("error", err))
+		identity = replicaIdentity{ID: uuid.New()}
+	}
+	identity, lock, err := claimReplica(identity)
+	if err != nil {
+		return listModel{}, err
+	}
+	model, err := LoadModel(identity)
+	if err != nil {
+		_ = lock.Unlock()
+		return model, err
+	}
+	model.replicaLock = lock
+	return model, nil
+}
+
+// UserSyncSecret returns the secret machines must share to sync with sift
+// sync serve and pull. It is set with the SIFT_SYNC_SECRET environment
+// variable.
+func UserSyncSecret() ([]byte, error) {
+	secret := os.Getenv("SIFT_SYNC_SECRET")
+	if secret == "" {
+		return nil, errors.New("SIFT_SYNC_SECRET is not set; set it to the same secret on both machines")
+	}
+	return []byte(secret), nil
+}
+
+func printReport(report peersync.Report) {
+	for _, c := range report.Received {
+		fmt.Printf("received: %s\n", c)
+	}
+	for _, c := range report.Sent {
+		fmt.Printf("sent: %s\n", c)
+	}
+	if len(report.Received) == 0 && len(report.Sent) == 0 {
+		fmt.Println("already in sync")
+	}
+}
+
+func runSync(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: sift sync serve [--listen addr] | sift sync pull host:port | sift sync git [remote]")
+	}
+	switch args[0] {
+	case "serve":
+		return runSyncServe(args[1:])
+	case "pull":
+		return runSyncPull(args[1:])
+	case "git":
+		return runSyncGit(args[1:])
+	default:
+		return fmt.Errorf("unknown sync command %q", args[0])
+	}
+}
+
+func runSyncServe(args []string) error {
+	flags := flag.NewFlagSet("sift sync serve", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:7777", "address to listen on; use :7777 to accept other machines on a trusted network")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	secret, err := UserSyncSecret()
+	if err != nil {
+		return err
+	}
+
+	model, err := loadListModel()
+	if err != nil {
+		return err
+	}
+	defer model.Close()
+	server := peersync.NewServer(&model.items, secret, func(report peersync.Report) error {
+		for _, c := range report.Received {
+			fmt.Printf("received: %s\n", c)
+		}
+		return model.Save()
+	})
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	fmt.Printf("serving on %s\n", l.Addr())
+	return server.Serve(l)
+}
+
+func runSyncPull(args []string) error {
+	flags := flag.NewFlagSet("sift sync pull", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: sift sync pull host:port")
+	}
+	secret, err := UserSyncSecret()
+	if err != nil {
+		return err
+	}
+
+	model, err := loadListModel()
+	if err != nil {
+		return err
+	}
+	defer model.Close()
+	report, err := peersync.Pull(context.Background(), flags.Arg(0), secret, &model.items)
+	if err != nil {
+		return err
+	}
+	printReport(report)
+	return model.Save()
+}
+
+func runSyncGit(args []string) error {
+	flags := flag.NewFlagSet("sift sync git", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	remote := "origin"
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		remote = flags.Arg(0)
+	default:
+		return errors.New("usage: sift sync git [remote]")
+	}
+	// Commit any changes not yet saved, such as seeding from the data file.
+	model, err := loadListModel()
+	if err != nil {
+		return err
+	}
+	defer model.Close()
+	git, ok := model.store.(*store.Git)
+	if !ok {
+		return errors.New("the list is not kept in git; set SIFT_GIT_DIR or use --store git:dir")
+	}
+	if err := model.Save(); err != nil {
+		return err
+	}
+	before := model.items
+	if err := git.Repo().Sync(remote); err != nil {
+		return err
+	}
+	after, err := git.Repo().Load()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("synced with %s: %d items before, %d after\n",
+		remote, len(before.Items()), len(after.Items()))
+	return nil
+}
+
+// runMergeDriver implements the git merge driver registered by gitstore.
+// Git passes the ancestor, current and other versions of the data file.
+func runMergeDriver(args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: sift merge-driver ancestor current other")
+	}
+	return gitstore.MergeFiles(args[1], args[2])
+}
+
+// runSet changes a setting of the list. Settings are kept in the list, so
+// they apply on every machine it is synced to.
+func runSet(args []string) error {
+	const usage = "usage: sift set text-merge on|off"
+	if len(args) != 2 || args[0] != "text-merge" {
+		return errors.New(usage)
+	}
+	var on bool
+	switch args[1] {
+	case "on":
+		on = true
+	case "off":
+	default:
+		return errors.New(usage)
+	}
+	model, err := loadListModel()
+	if err != nil {
+		return err
+	}
+	defer model.Close()
+	model.items.SetTextMerge(on)
+	return model.Save()
+}
+
+// runRebalance gives every item a short order, shortening orders that have
+// grown long from many items added at the same spot.
+func runRebalance(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: sift rebalance")
+	}
+	model, err := loadListModel()
+	if err != nil {
+		return err
+	}
+	defer model.Close()
+	if err := model.items.Rebalance(); err != nil {
+		return err
+	}
+	return model.Save()
+}
+
+// runRestore lists the backups of the file the list is kept in, or with a
+// backup's number replaces the file with it.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("sift restore", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("usage: sift restore [number]")
+	}
+	st, err := openStore(UserStore(), replicaIdentity{})
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	var file *store.File
+	switch st := st.(type) {
+	case *store.File:
+		file = st
+	case *store.Git:
+		return errors.New("the list is kept in git; use git to restore an earlier version")
+	default:
+		return errors.New("the list is kept in a store that has no backups")
+	}
+
+	backups, err := file.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("no backups of %s\n", file.Path())
+		return nil
+	}
+	if flags.NArg() == 0 {
+		for i, backup := range backups {
+			fmt.Printf("%3d  %s  %s\n", i+1, backup.Time.Local().Format(time.DateTime), describeBackup(file, backup))
+		}
+		fmt.Println("run sift restore <number> to restore a backup")
+		return nil
+	}
+
+	n, err := strconv.Atoi(flags.Arg(0))
+	if err != nil || n < 1 || n > len(backups) {
+		return fmt.Errorf("no backup numbered %q; run sift restore to list them", flags.Arg(0))
+	}
+	backup := backups[n-1]
+	if _, err := file.Read(backup.Path); err != nil {
+		return fmt.Errorf("backup %d cannot be read: %w", n, err)
+	}
+
+	// A running sift would merge its list back into the restored file, so
+	// restoring waits for saves in progress and refuses while one runs.
+	lock, err := safefile.LockFile(UserLockFile())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			slog.Error("Error unlocking", slog.Any("error", err))
+		}
+	}()
+	running, err := otherProcessRunning()
+	if err != nil {
+		return err
+	}
+	if running {
+		return errors.New("another sift is running; quit it before restoring a backup")
+	}
+	if err := file.Restore(backup); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s; the replaced version is now backup 1\n",
+		file.Path(), backup.Time.Local().Format(time.DateTime))
+	return nil
+}
+
+// describeBackup summarizes what a backup holds.
+func describeBackup(file *store.File, backup safefile.Backup) string {
+	items, err := file.Read(backup.Path)
+	if err != nil {
+		return fmt.Sprintf("unreadable: %s", err)
+	}
+	return fmt.Sprintf("%d items", len(items.Items()))
+}
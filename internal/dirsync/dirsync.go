@@ -0,0 +1,70 @@
+// Package dirsync stores an item list in a directory shared between
+// machines by a file sync tool such as Syncthing or Dropbox.
+//
+// Each replica writes only its own file, named after its replica ID, so the
+// sync tool never sees two machines change the same file. Loading merges
+// every replica file in the directory, including any conflicted copies a
+// sync tool may have made, into a single view.
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/store/listfile"
+)
+
+// ReplicaFile returns the file in dir written by replica.
+func ReplicaFile(dir string, replica uuid.UUID) string {
+	return filepath.Join(dir, replica.String()+".yaml")
+}
+
+// Load returns the merge of every replica file in dir. The replica's own
+// file must be readable if it exists; other files that cannot be read, for
+// instance because a sync tool is still writing them, are skipped. If dir
+// holds no replica files Load returns an error wrapping fs.ErrNotExist.
+func Load(dir string, replica uuid.UUID) (replicatedtodo.ItemList, error) {
+	own := ReplicaFile(dir, replica)
+	items, err := listfile.Read(own, listfile.YAML)
+	found := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return items, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return items, err
+	}
+	for _, path := range paths {
+		if path == own {
+			continue
+		}
+		sibling, err := listfile.Read(path, listfile.YAML)
+		if err != nil {
+			slog.Warn("Skipping unreadable replica file", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+		items.MergeStored(&sibling)
+		found = true
+	}
+
+	if !found {
+		return items, fmt.Errorf("no replica files in %s: %w", dir, fs.ErrNotExist)
+	}
+	return items, nil
+}
+
+// Save writes items to the replica's own file in dir. The file is replaced
+// atomically so a sync tool never picks up a partially written file.
+func Save(dir string, replica uuid.UUID, items *replicatedtodo.ItemList) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create sync directory: %w", err)
+	}
+	return listfile.Write(ReplicaFile(dir, replica), items, listfile.YAML)
+}
@@ -0,0 +1,115 @@
+package dirsync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+func titles(items []replicatedtodo.Item) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Title)
+	}
+	return result
+}
+
+func TestLoadMergesReplicaFiles(t *testing.T) {
+	dir := t.TempDir()
+	laptopID, desktopID := uuid.New(), uuid.New()
+
+	var laptop replicatedtodo.ItemList
+	laptop.SetReplica(laptopID, "laptop")
+	a, err := laptop.NewTodo("a", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = Save(dir, laptopID, &laptop); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+
+	desktop, err := Load(dir, desktopID)
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	desktop.SetReplica(desktopID, "desktop")
+	if _, err = desktop.NewTodo("b", a.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = Save(dir, desktopID, &desktop); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+
+	// The laptop edits its own copy concurrently.
+	if _, err = laptop.NewTodo("c", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = Save(dir, laptopID, &laptop); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+
+	merged, err := Load(dir, laptopID)
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"c", "a", "b"}, titles(merged.Items())); diff != "" {
+		t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d files, want one per replica", len(entries))
+	}
+}
+
+func TestLoadMergesConflictedCopies(t *testing.T) {
+	dir := t.TempDir()
+	replica := uuid.New()
+
+	var list replicatedtodo.ItemList
+	list.SetReplica(replica, "laptop")
+	if _, err := list.NewTodo("saved", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err := Save(dir, replica, &list); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	own := ReplicaFile(dir, replica)
+	conflict := filepath.Join(dir, replica.String()+".sync-conflict-20240101-000000.yaml")
+	if err := os.Rename(own, conflict); err != nil {
+		t.Fatalf("Rename() error: %s", err)
+	}
+	if _, err := list.NewTodo("later", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err := Save(dir, replica, &list); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "partial.yaml"), []byte("Items: [\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error: %s", err)
+	}
+
+	merged, err := Load(dir, replica)
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if got := len(merged.Items()); got != 2 {
+		t.Errorf("Load() returned %d items, want 2", got)
+	}
+}
+
+func TestLoadEmptyDir(t *testing.T) {
+	_, err := Load(t.TempDir(), uuid.New())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load() error = %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Package filewatch notices when files change. It asks the system to
+// report changes to the directory holding the files, which also reports
+// files replaced by renaming a new file over them, as sift and file sync
+// tools do. Where the system cannot report changes, or the directory holding
+// the files is itself a pattern, it polls the files instead.
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher watches the files matching a pattern.
+type Watcher struct {
+	pattern  string
+	interval time.Duration
+	changed  func()
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Watch calls changed from a new goroutine whenever the files matching
+// pattern, as understood by filepath.Glob, change: one is created,
+// removed, replaced or written to. If the system cannot report changes to
+// the directory holding them, they are checked every interval instead. A
+// pattern without wildcards watches a single file.
+func Watch(pattern string, interval time.Duration, changed func()) (*Watcher, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		pattern:  pattern,
+		interval: interval,
+		changed:  changed,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	// Start watching before taking the first snapshot, so that no change
+	// after it goes unreported.
+	notifier := w.notifier()
+	go w.run(w.snapshot(), notifier)
+	return w, nil
+}
+
+// Close stops watching, waiting for a call to changed in progress to
+// return.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// notifier returns a watcher reporting changes to the directory holding
+// the files, or nil if they must be polled.
+func (w *Watcher) notifier() *fsnotify.Watcher {
+	dir := filepath.Dir(w.pattern)
+	if strings.ContainsAny(dir, "*?[") {
+		return nil
+	}
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil
+	}
+	if err := notifier.Add(dir); err != nil {
+		notifier.Close()
+		return nil
+	}
+	return notifier
+}
+
+func (w *Watcher) run(last map[string]os.FileInfo, notifier *fsnotify.Watcher) {
+	defer close(w.done)
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	poll := func() {
+		ticker = time.NewTicker(w.interval)
+		tick = ticker.C
+		events, errors = nil, nil
+	}
+	if notifier != nil {
+		events, errors = notifier.Events, notifier.Errors
+	} else {
+		poll()
+	}
+	defer func() {
+		if notifier != nil {
+			notifier.Close()
+		}
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-tick:
+		case _, ok := <-events:
+			if !ok {
+				poll()
+			}
+		case _, ok := <-errors:
+			// Changes may have gone unreported, as when too many
+			// happen at once, so check the files now either way.
+			if !ok {
+				poll()
+			}
+		}
+		current := w.snapshot()
+		if !same(last, current) {
+			w.changed()
+		}
+		last = current
+	}
+}
+
+// snapshot returns the files matching the pattern now.
+func (w *Watcher) snapshot() map[string]os.FileInfo {
+	// The pattern was checked by Watch, so Glob cannot fail.
+	paths, _ := filepath.Glob(w.pattern)
+	files := make(map[string]os.FileInfo, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Removed since Glob found it.
+			continue
+		}
+		files[path] = info
+	}
+	return files
+}
+
+// same reports whether two snapshots hold the same files unchanged.
+func same(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, x := range a {
+		y, ok := b[path]
+		if !ok || !os.SameFile(x, y) || x.Size() != y.Size() || !x.ModTime().Equal(y.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matta/sift/internal/safefile"
+)
+
+const interval = 10 * time.Millisecond
+
+// watch watches pattern until the test ends, returning a channel that
+// receives a value for every change.
+func watch(t *testing.T, pattern string) <-chan struct{} {
+	t.Helper()
+	changes := make(chan struct{}, 100)
+	w, err := Watch(pattern, interval, func() {
+		changes <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("Watch() error: %s", err)
+	}
+	t.Cleanup(w.Close)
+	return changes
+}
+
+func expectChange(t *testing.T, changes <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change reported after %s", what)
+	}
+	// Let the watcher settle so one change is not reported as two.
+	time.Sleep(5 * interval)
+	drain(changes)
+}
+
+func expectNoChange(t *testing.T, changes <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-changes:
+		t.Fatal("change reported when nothing changed")
+	case <-time.After(10 * interval):
+	}
+}
+
+func drain(changes <-chan struct{}) {
+	for {
+		select {
+		case <-changes:
+		default:
+			return
+		}
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.yaml")
+	changes := watch(t, path)
+	expectNoChange(t, changes)
+
+	if err := os.WriteFile(path, []byte("created"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "creating the file")
+
+	if err := safefile.WriteFile(path, []byte("renamed over"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "renaming a file over it")
+
+	if err := os.WriteFile(path, []byte("written in place"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "writing to it")
+	expectNoChange(t, changes)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "removing it")
+}
+
+func TestWatchPattern(t *testing.T) {
+	dir := t.TempDir()
+	changes := watch(t, filepath.Join(dir, "*.yaml"))
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectNoChange(t, changes)
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "adding a matching file")
+}
+
+func TestWatchWithoutPolling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.yaml")
+	changes := make(chan struct{}, 100)
+	w, err := Watch(path, time.Hour, func() {
+		changes <- struct{}{}
+	})
+	if err != nil {
+		t.Fatalf("Watch() error: %s", err)
+	}
+	t.Cleanup(w.Close)
+
+	if err := safefile.WriteFile(path, []byte("saved"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported before the poll interval")
+	}
+}
+
+func TestWatchPolledPattern(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "replica"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	// A pattern naming the directory cannot be watched by the system, so
+	// it is polled.
+	changes := watch(t, filepath.Join(dir, "*", "list.yaml"))
+	if err := os.WriteFile(filepath.Join(dir, "replica", "list.yaml"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "adding a matching file")
+}
+
+func TestWatchBadPattern(t *testing.T) {
+	if _, err := Watch("[", interval, func() {}); err == nil {
+		t.Error("Watch() with a bad pattern succeeded")
+	}
+}
@@ -0,0 +1,174 @@
+// Package gitstore keeps an item list in a git repository.
+//
+// Every save is a commit. Syncing fetches from and pushes to a remote, and
+// concurrent edits are combined by a custom merge driver that merges the
+// two versions of the data file as replicas, so merges never produce
+// textual conflicts.
+package gitstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/store/listfile"
+)
+
+const (
+	// DataFile is the name of the data file within the repository.
+	DataFile = "sift.yaml"
+
+	// driverName is the name the merge driver is registered under in
+	// .gitattributes and the repository configuration.
+	driverName = "sift"
+)
+
+// Repo is a git working tree holding a data file.
+type Repo struct {
+	dir string
+}
+
+// DriverCommand returns the merge driver command line that runs the
+// "merge-driver" command of the sift executable at path.
+func DriverCommand(path string) string {
+	return shellQuote(path) + " merge-driver %O %A %B"
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Open opens the repository with its working tree at dir, creating it if
+// necessary, and registers driver, a command line from DriverCommand, as the
+// merge driver for the data file.
+func Open(dir string, driver string) (*Repo, error) {
+	r := &Repo{dir: dir}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create repository: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if _, err = r.git("init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := r.git("config", "merge."+driverName+".name", "sift replica merge"); err != nil {
+		return nil, err
+	}
+	if _, err := r.git("config", "merge."+driverName+".driver", driver); err != nil {
+		return nil, err
+	}
+	// Commits need an identity; fall back to a generic one rather than
+	// failing every save on machines without one.
+	if out, _ := r.git("config", "user.email"); out == "" {
+		if _, err := r.git("config", "user.name", "sift"); err != nil {
+			return nil, err
+		}
+		if _, err := r.git("config", "user.email", "sift@localhost"); err != nil {
+			return nil, err
+		}
+	}
+
+	attributes := []byte(DataFile + " merge=" + driverName + "\n")
+	if err := os.WriteFile(filepath.Join(dir, ".gitattributes"), attributes, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write .gitattributes: %w", err)
+	}
+	return r, nil
+}
+
+// Path returns the path of the data file.
+func (r *Repo) Path() string {
+	return filepath.Join(r.dir, DataFile)
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s: %w: %s",
+			strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Load reads the data file from the working tree.
+func (r *Repo) Load() (replicatedtodo.ItemList, error) {
+	return listfile.Read(r.Path(), listfile.YAML)
+}
+
+// Save writes the data file and commits it, if it changed, with the given
+// message.
+func (r *Repo) Save(items *replicatedtodo.ItemList, message string) error {
+	if err := listfile.Write(r.Path(), items, listfile.YAML); err != nil {
+		return err
+	}
+	if _, err := r.git("add", DataFile, ".gitattributes"); err != nil {
+		return err
+	}
+	if _, err := r.git("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	_, err := r.git("commit", "--quiet", "--message", message)
+	return err
+}
+
+// Sync merges the current branch of remote into the current branch and
+// pushes the result back.
+func (r *Repo) Sync(remote string) error {
+	branch, err := r.git("symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	heads, err := r.git("ls-remote", "--heads", remote, branch)
+	if err != nil {
+		return err
+	}
+	if heads != "" {
+		if _, err = r.git("fetch", "--quiet", remote, branch); err != nil {
+			return err
+		}
+		if _, err = r.git("merge", "--quiet", "--no-edit", "--allow-unrelated-histories",
+			"--message", "Merge "+remote+" "+branch, "FETCH_HEAD"); err != nil {
+			return errors.Join(err, r.abortMerge())
+		}
+	}
+	_, err = r.git("push", "--quiet", remote, "HEAD:refs/heads/"+branch)
+	return err
+}
+
+// abortMerge abandons a merge left in progress by a failed "git merge".
+// Otherwise the next Save would commit it with only our side of the data
+// file, and the remote's changes would count as merged from then on.
+func (r *Repo) abortMerge() error {
+	if _, err := r.git("rev-parse", "--quiet", "--verify", "MERGE_HEAD"); err != nil {
+		return nil
+	}
+	_, err := r.git("merge", "--abort")
+	return err
+}
+
+// MergeFiles is the merge driver: it merges the data file at other into the
+// data file at current, leaving the result in current. The common ancestor
+// git also provides is not needed, since merging replicas is a function of
+// the two versions alone.
+func MergeFiles(current, other string) error {
+	ours, err := listfile.Read(current, listfile.YAML)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	theirs, err := listfile.Read(other, listfile.YAML)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	ours.Merge(&theirs)
+	return listfile.Write(current, &ours, listfile.YAML)
+}
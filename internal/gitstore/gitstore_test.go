@@ -0,0 +1,230 @@
+package gitstore
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// TestMain lets the test binary stand in for sift as the merge driver.
+func TestMain(m *testing.M) {
+	if os.Getenv("GITSTORE_TEST_MERGE_DRIVER") != "" {
+		if err := MergeFiles(os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if os.Getenv("GITSTORE_TEST_FAILING_MERGE_DRIVER") != "" {
+		fmt.Fprintln(os.Stderr, "merge driver failed")
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func testDriver(t *testing.T) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Executable() error: %s", err)
+	}
+	return "GITSTORE_TEST_MERGE_DRIVER=1 " + shellQuote(exe) + " %O %A %B"
+}
+
+func failingDriver(t *testing.T) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Executable() error: %s", err)
+	}
+	return "GITSTORE_TEST_FAILING_MERGE_DRIVER=1 " + shellQuote(exe) + " %O %A %B"
+}
+
+func newBareRemote(t *testing.T) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", dir).CombinedOutput(); err != nil {
+		t.Fatalf("git init --bare: %s: %s", err, out)
+	}
+	return dir
+}
+
+func openReplica(t *testing.T, name string) (*Repo, *replicatedtodo.ItemList) {
+	t.Helper()
+	repo, err := Open(filepath.Join(t.TempDir(), name), testDriver(t))
+	if err != nil {
+		t.Fatalf("Open() error: %s", err)
+	}
+	// The replica ID is derived from the name, so the order key suffixes,
+	// which order items inserted at the same place concurrently, are the
+	// same on every run.
+	list := &replicatedtodo.ItemList{}
+	list.SetReplica(uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)), name)
+	return repo, list
+}
+
+func titles(items []replicatedtodo.Item) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Title)
+	}
+	return result
+}
+
+func TestSaveCommits(t *testing.T) {
+	repo, list := openReplica(t, "laptop")
+	if _, err := list.NewTodo("a", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	for range 2 {
+		if err := repo.Save(list, "save"); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+	}
+	count, err := repo.git("rev-list", "--count", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != "1" {
+		t.Errorf("saving an unchanged list made %s commits, want 1", count)
+	}
+
+	loaded, err := repo.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if diff := cmp.Diff(list.Items(), loaded.Items()); diff != "" {
+		t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSyncMergesConcurrentEdits(t *testing.T) {
+	remote := newBareRemote(t)
+	laptopRepo, laptop := openReplica(t, "laptop")
+	desktopRepo, desktop := openReplica(t, "desktop")
+
+	shared, err := laptop.NewTodo("shared", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = laptopRepo.Save(laptop, "laptop 1"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err = laptopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+
+	// The desktop starts from an unrelated history.
+	if _, err = desktop.NewTodo("desktop", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = desktopRepo.Save(desktop, "desktop 1"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err = desktopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+	*desktop, err = desktopRepo.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	// The first two items were added independently, so which comes first
+	// depends on the replicas' order key suffixes. The item the desktop
+	// adds after "shared" takes its place once it is deleted.
+	var want []string
+	for _, title := range titles(desktop.Items()) {
+		if title == "shared" {
+			title = "after shared"
+		}
+		want = append(want, title)
+	}
+
+	// Both edit the same item concurrently.
+	laptop.Delete(shared.ID)
+	if err = laptopRepo.Save(laptop, "laptop 2"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if _, err = desktop.NewTodo("after shared", shared.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = desktopRepo.Save(desktop, "desktop 2"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err = desktopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+	if err = laptopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+
+	laptopItems, err := laptopRepo.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if diff := cmp.Diff(want, titles(laptopItems.Items())); diff != "" {
+		t.Errorf("laptop items mismatch (-want, +got):\n%s", diff)
+	}
+	if err = desktopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+	desktopItems, err := desktopRepo.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	if diff := cmp.Diff(laptopItems.Items(), desktopItems.Items()); diff != "" {
+		t.Errorf("replicas differ after sync (-laptop, +desktop):\n%s", diff)
+	}
+}
+
+func TestSyncAbortsFailedMerge(t *testing.T) {
+	remote := newBareRemote(t)
+	laptopRepo, laptop := openReplica(t, "laptop")
+	desktopRepo, desktop := openReplica(t, "desktop")
+
+	if _, err := laptop.NewTodo("laptop", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err := laptopRepo.Save(laptop, "laptop 1"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err := laptopRepo.Sync(remote); err != nil {
+		t.Fatalf("Sync() error: %s", err)
+	}
+
+	// The desktop's merge driver rejects the laptop's data file, as it
+	// does one written by a newer version of sift.
+	desktopRepo, err := Open(desktopRepo.dir, failingDriver(t))
+	if err != nil {
+		t.Fatalf("Open() error: %s", err)
+	}
+	if _, err = desktop.NewTodo("desktop", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = desktopRepo.Save(desktop, "desktop 1"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if err = desktopRepo.Sync(remote); err == nil {
+		t.Fatalf("Sync() error = nil, want the merge driver's failure")
+	}
+	if _, err = desktopRepo.git("rev-parse", "--quiet", "--verify", "MERGE_HEAD"); err == nil {
+		t.Errorf("Sync() left a merge in progress")
+	}
+
+	// Saving again must not complete the merge with only the desktop's
+	// data, which would make the laptop's changes count as merged.
+	if _, err = desktop.NewTodo("desktop 2", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if err = desktopRepo.Save(desktop, "desktop 2"); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if _, err = desktopRepo.git("merge-base", "--is-ancestor", "FETCH_HEAD", "HEAD"); err == nil {
+		t.Errorf("Save() after a failed Sync() committed the laptop's history as merged")
+	}
+}
@@ -0,0 +1,291 @@
+// Package journal stores an item list as a snapshot and a journal of the
+// changes saved since the snapshot was taken.
+//
+// Saving appends one record to the journal and flushes it to disk, which
+// takes time in proportion to the change rather than to the list. When the
+// journal grows larger than the snapshot it is compacted: the whole list is
+// written as a new snapshot and the journal starts again empty.
+//
+// Each record is a delta, the part of the list its version vector at the
+// previous save did not cover, so replaying a record twice does no harm. A
+// crash part way through compacting leaves records that are replayed over
+// a snapshot that already holds them, and a crash part way through
+// appending leaves a torn final record, which loading detects by its
+// length or checksum and discards.
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/safefile"
+)
+
+const (
+	// SnapshotFile and JournalFile are the names of the files in a journal
+	// directory.
+	SnapshotFile = "snapshot.json"
+	JournalFile  = "journal"
+
+	// headerSize is the size of a record's header: the length of its
+	// payload and the payload's CRC-32C checksum, both big endian.
+	headerSize = 8
+
+	// defaultMinCompactSize is the default for Journal.minCompactSize.
+	defaultMinCompactSize = 64 << 10
+)
+
+// ErrCorrupt is returned when a record that is followed by others fails
+// its checksum. Unlike a torn final record this cannot be left by a crash
+// while appending, so it is not discarded.
+var ErrCorrupt = errors.New("journal is corrupt")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Journal appends the changes to a list to the journal in a directory.
+//
+// Processes sharing a directory must take turns to Open, Save and Compact,
+// as sift does by holding its lock file.
+type Journal struct {
+	dir string
+	// file is the journal, open for appending.
+	file *os.File
+	// end is how much of the journal has been checked to hold whole
+	// records.
+	end int64
+	// minCompactSize is how large the journal may grow before it is
+	// compacted, however small the snapshot.
+	minCompactSize int64
+	// compactAt is the journal size at which Save compacts it.
+	compactAt int64
+}
+
+// Dir returns the directory the journal is kept in.
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// Open opens the journal in dir, creating the directory if necessary, and
+// returns the list its snapshot and records add up to. A torn final record
+// is removed from the journal.
+func Open(dir string) (*Journal, replicatedtodo.ItemList, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, replicatedtodo.ItemList{}, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	j := &Journal{dir: dir, minCompactSize: defaultMinCompactSize}
+	if err := j.open(); err != nil {
+		return nil, replicatedtodo.ItemList{}, err
+	}
+	list, err := Load(dir)
+	if err != nil {
+		_ = j.file.Close()
+		return nil, list, err
+	}
+	if err := j.catchUp(); err != nil {
+		_ = j.file.Close()
+		return nil, list, err
+	}
+	return j, list, nil
+}
+
+// open opens the journal file as it is now, to be checked from the start.
+func (j *Journal) open() error {
+	f, err := os.OpenFile(filepath.Join(j.dir, JournalFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if j.file != nil {
+		_ = j.file.Close()
+	}
+	j.file = f
+	j.end = 0
+	j.compactAt = j.minCompactSize
+	if info, err := os.Stat(filepath.Join(j.dir, SnapshotFile)); err == nil {
+		j.compactAt = max(j.compactAt, info.Size())
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// Load returns the list the snapshot and records in dir add up to,
+// ignoring a torn final record. A directory without a journal holds an
+// empty list.
+func Load(dir string) (replicatedtodo.ItemList, error) {
+	var list replicatedtodo.ItemList
+	data, err := os.ReadFile(filepath.Join(dir, SnapshotFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &list); err != nil {
+			return list, fmt.Errorf("failed to unmarshal journal snapshot: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return list, fmt.Errorf("failed to read journal snapshot: %w", err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, JournalFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return list, fmt.Errorf("failed to read journal: %w", err)
+	}
+	payloads, _, err := scan(data)
+	if err != nil {
+		return list, err
+	}
+	for i, payload := range payloads {
+		var delta replicatedtodo.ItemList
+		if err := json.Unmarshal(payload, &delta); err != nil {
+			return list, fmt.Errorf("failed to unmarshal journal record %d: %w", i, err)
+		}
+		list.ReplayDelta(&delta)
+	}
+	return list, nil
+}
+
+// scan splits data into records, returning their payloads and the length
+// of data they take up. A final record that data ends part way through, or
+// that fails its checksum, is torn and left out, as are trailing zeros,
+// which some file systems leave after a crash. A record failing its
+// checksum with more data after it is an error wrapping ErrCorrupt.
+func scan(data []byte) (payloads [][]byte, n int, err error) {
+	for n < len(data) {
+		rest := data[n:]
+		if len(rest) < headerSize {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(rest))
+		sum := binary.BigEndian.Uint32(rest[4:])
+		if length > int64(len(rest)-headerSize) {
+			break
+		}
+		size := headerSize + int(length)
+		payload := rest[headerSize:size]
+		if length == 0 || crc32.Checksum(payload, crcTable) != sum {
+			if size == len(rest) || isZero(rest) {
+				break
+			}
+			return payloads, n, fmt.Errorf("record at offset %d fails its checksum: %w", n, ErrCorrupt)
+		}
+		payloads = append(payloads, payload)
+		n += size
+	}
+	return payloads, n, nil
+}
+
+func isZero(data []byte) bool {
+	return bytes.Count(data, []byte{0}) == len(data)
+}
+
+// catchUp checks the records appended since the journal was last checked,
+// reopening it if another process has compacted it, and truncates a torn
+// final record so the next record is appended after whole ones.
+func (j *Journal) catchUp() error {
+	opened, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to check journal: %w", err)
+	}
+	current, err := os.Stat(filepath.Join(j.dir, JournalFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to check journal: %w", err)
+	}
+	if current == nil || !os.SameFile(opened, current) {
+		if err := j.open(); err != nil {
+			return err
+		}
+		if opened, err = j.file.Stat(); err != nil {
+			return fmt.Errorf("failed to check journal: %w", err)
+		}
+	}
+	if opened.Size() == j.end {
+		return nil
+	}
+
+	data := make([]byte, opened.Size()-j.end)
+	if _, err := j.file.ReadAt(data, j.end); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	_, n, err := scan(data)
+	if err != nil {
+		return err
+	}
+	j.end += int64(n)
+	if n < len(data) {
+		if err := j.file.Truncate(j.end); err != nil {
+			return fmt.Errorf("failed to discard torn journal record: %w", err)
+		}
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to discard torn journal record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Save appends to the journal the changes in list not covered by since,
+// the version vector of the list as it was last saved, and flushes them to
+// disk. If the journal has grown larger than the snapshot, it is then
+// compacted, so list must hold everything already saved.
+func (j *Journal) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	if err := j.catchUp(); err != nil {
+		return err
+	}
+	if !since.IncludesAll(slices.Collect(maps.Values(list.VersionVector()))) {
+		delta := list.DeltaSince(since)
+		payload, err := json.Marshal(&delta)
+		if err != nil {
+			return fmt.Errorf("failed to marshal journal record: %w", err)
+		}
+		if err := j.append(payload); err != nil {
+			return err
+		}
+	}
+	if j.end >= j.compactAt {
+		return j.Compact(list)
+	}
+	return nil
+}
+
+// append writes a record holding payload to the end of the journal.
+func (j *Journal) append(payload []byte) error {
+	record := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	record = append(record, payload...)
+	if _, err := j.file.Write(record); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	j.end += int64(len(record))
+	return nil
+}
+
+// Compact writes list as the snapshot and replaces the journal with an
+// empty one. list must hold everything saved to the journal.
+func (j *Journal) Compact(list *replicatedtodo.ItemList) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal snapshot: %w", err)
+	}
+	if err := safefile.WriteFile(filepath.Join(j.dir, SnapshotFile), data, 0o600); err != nil {
+		return err
+	}
+	// The records are in the snapshot now, so a crash before the journal
+	// is replaced only means they are replayed again.
+	if err := safefile.WriteFile(filepath.Join(j.dir, JournalFile), nil, 0o600); err != nil {
+		return err
+	}
+	return j.open()
+}
@@ -0,0 +1,270 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+func open(t *testing.T, dir string) (*Journal, replicatedtodo.ItemList) {
+	t.Helper()
+	j, list, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error: %s", err)
+	}
+	t.Cleanup(func() { _ = j.Close() })
+	return j, list
+}
+
+// edit adds an item to list and saves the change to j.
+func edit(t *testing.T, j *Journal, list *replicatedtodo.ItemList, title string) {
+	t.Helper()
+	saved := list.VersionVector()
+	if _, err := list.NewTodo(title, uuid.UUID{}); err != nil {
+		t.Fatalf("NewTodo() error: %s", err)
+	}
+	if err := j.Save(list, saved); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+}
+
+func load(t *testing.T, dir string) replicatedtodo.ItemList {
+	t.Helper()
+	list, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	return list
+}
+
+func journalSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, JournalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func appendToJournal(t *testing.T, dir string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, JournalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	j, list := open(t, dir)
+	if len(list.Items()) != 0 {
+		t.Fatalf("new journal holds %v", list.Items())
+	}
+	for _, title := range []string{"one", "two", "three"} {
+		edit(t, j, &list, title)
+	}
+	id := list.Items()[0].ID
+	saved := list.VersionVector()
+	list.SetTitle(id, "edited")
+	list.ToggleDone(id)
+	if err := j.Save(&list, saved); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+
+	got := load(t, dir)
+	if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+		t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(dir, SnapshotFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("snapshot written before the journal needed compacting: %v", err)
+	}
+
+	// Saving without changes appends nothing.
+	size := journalSize(t, dir)
+	if err := j.Save(&list, list.VersionVector()); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if got := journalSize(t, dir); got != size {
+		t.Errorf("journal grew from %d to %d bytes on a save without changes", size, got)
+	}
+}
+
+func TestTornFinalRecord(t *testing.T) {
+	for name, torn := range map[string]func(record []byte) []byte{
+		"cut short in the header": func(record []byte) []byte {
+			return record[:headerSize/2]
+		},
+		"cut short in the payload": func(record []byte) []byte {
+			return record[:len(record)-3]
+		},
+		"payload not written": func(record []byte) []byte {
+			garbled := append([]byte(nil), record...)
+			clear(garbled[headerSize:])
+			return garbled
+		},
+		"zeros": func(record []byte) []byte {
+			return make([]byte, len(record))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			j, list := open(t, dir)
+			edit(t, j, &list, "kept")
+			want := list.Items()
+			end := journalSize(t, dir)
+
+			// The record for a second save, as a crash part way through
+			// appending it might leave it.
+			edit(t, j, &list, "lost")
+			data, err := os.ReadFile(filepath.Join(dir, JournalFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := data[end:]
+			if err := os.Truncate(filepath.Join(dir, JournalFile), end); err != nil {
+				t.Fatal(err)
+			}
+			appendToJournal(t, dir, torn(record))
+
+			got := load(t, dir)
+			if diff := cmp.Diff(want, got.Items()); diff != "" {
+				t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+			}
+
+			// Opening discards the torn record, so later records follow
+			// whole ones.
+			_ = j.Close()
+			j, list = open(t, dir)
+			if got := journalSize(t, dir); got != end {
+				t.Errorf("journal size after Open() = %d, want %d", got, end)
+			}
+			edit(t, j, &list, "after")
+			got = load(t, dir)
+			if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+				t.Errorf("Load() after saving again mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	j, list := open(t, dir)
+	edit(t, j, &list, "one")
+	edit(t, j, &list, "two")
+
+	path := filepath.Join(dir, JournalFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[headerSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(dir); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Load() error = %v, want ErrCorrupt", err)
+	}
+	if _, _, err := Open(dir); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open() error = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	j, list := open(t, dir)
+	j.minCompactSize, j.compactAt = 2000, 2000
+	for i := range 20 {
+		edit(t, j, &list, string(rune('a'+i)))
+		// Once compacted, the journal may grow as large as the snapshot.
+		if got := journalSize(t, dir); got >= j.compactAt {
+			t.Fatalf("journal is %d bytes after save %d, want it compacted at %d", got, i, j.compactAt)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, SnapshotFile)); err != nil {
+		t.Fatalf("no snapshot after compacting: %s", err)
+	}
+	got := load(t, dir)
+	if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+		t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestCompactByAnotherProcess saves through two journals on the same
+// directory, as two sift processes taking turns would.
+func TestCompactByAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	first, list := open(t, dir)
+	edit(t, first, &list, "first")
+
+	second, other := open(t, dir)
+	edit(t, second, &other, "second")
+	if err := second.Compact(&other); err != nil {
+		t.Fatalf("Compact() error: %s", err)
+	}
+
+	// The first journal sees the second's changes, as sift merges them in
+	// before saving, and its next record goes to the new journal.
+	saved := load(t, dir)
+	list.Merge(&saved)
+	edit(t, first, &list, "first again")
+
+	got := load(t, dir)
+	if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+		t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+	}
+	if len(got.Items()) != 3 {
+		t.Errorf("Load() holds %d items, want 3", len(got.Items()))
+	}
+}
+
+func TestScan(t *testing.T) {
+	var j Journal
+	dir := t.TempDir()
+	if err := appendRecords(&j, dir, "a", "bc"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, JournalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads, n, err := scan(data)
+	if err != nil {
+		t.Fatalf("scan() error: %s", err)
+	}
+	var got []string
+	for _, p := range payloads {
+		got = append(got, string(p))
+	}
+	if diff := cmp.Diff([]string{"a", "bc"}, got); diff != "" {
+		t.Errorf("scan() payloads mismatch (-want, +got):\n%s", diff)
+	}
+	if n != len(data) {
+		t.Errorf("scan() length = %d, want %d", n, len(data))
+	}
+}
+
+// appendRecords appends records holding payloads to the journal in dir
+// through j.
+func appendRecords(j *Journal, dir string, payloads ...string) error {
+	j.dir = dir
+	if err := j.open(); err != nil {
+		return err
+	}
+	defer j.Close()
+	for _, p := range payloads {
+		if err := j.append([]byte(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
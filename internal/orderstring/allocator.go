@@ -0,0 +1,193 @@
+package orderstring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"hash/fnv"
+)
+
+// MinSuffixLength and MaxSuffixLength bound the number of bytes an
+// Allocator appends to each key.
+//
+// Replicas whose suffixes collide can generate equal keys when they insert
+// at the same place at the same time, leaving the items ordered by ID. A
+// suffix is as short as it can be while differing from those of the other
+// replicas the allocator is told of, and no shorter than MinSuffixLength,
+// for replicas it has not heard of yet: for the Base62 alphabet there are
+// 16·61², about 60,000, suffixes of that length. MaxSuffixLength is long
+// enough for any number of replicas, and is used by allocators that know
+// of no other replicas because they have no identity.
+const (
+	MinSuffixLength = 3
+	MaxSuffixLength = 10
+)
+
+// The first symbol of a suffix is drawn from the low end of the alphabet.
+// Appending after a key continues from the symbol following its common
+// prefix, which is the first symbol of a suffix, so a high symbol there
+// would leave little room and make keys grow quickly when items are
+// repeatedly added to the end of a list. It runs from 1 to 7 for the
+// Lowercase alphabet, and over the same share of larger alphabets. The
+// other symbols do not affect growth and use the whole alphabet but its
+// lowest symbol, which may not end a key.
+func (a *Alphabet) firstSuffixDigits() int {
+	return max(1, a.Radix()*7/26)
+}
+
+// Allocator generates order strings on behalf of one replica.
+//
+// MidString alone is deterministic, so two replicas inserting between the
+// same neighbors at the same time generate the same key, and the tie can
+// only be broken by something unrelated to the order such as an item ID.
+// Worse, neither replica can later insert between the two tied items.
+// An Allocator appends a suffix unique to its replica to every key, so
+// concurrently generated keys are distinct and concurrent inserts at the
+// same position are ordered by replica, the same way on every replica.
+type Allocator struct {
+	alphabet *Alphabet
+	suffix   OrderString
+}
+
+// NewAllocator returns an allocator for the Lowercase alphabet. See
+// Alphabet.NewAllocator.
+func NewAllocator(seed []byte, others ...[]byte) Allocator {
+	return Lowercase.NewAllocator(seed, others...)
+}
+
+// RandomAllocator returns an allocator for the Lowercase alphabet. See
+// Alphabet.RandomAllocator.
+func RandomAllocator() Allocator {
+	return Lowercase.RandomAllocator()
+}
+
+// NewAllocator returns an allocator whose suffix is derived from seed,
+// which should identify the replica, for example its ID. others are the
+// seeds of the other replicas known to share the keys, and the suffix is
+// the shortest, of at least MinSuffixLength bytes, that differs from
+// theirs.
+//
+// The suffix grows as replicas join, so one replica's keys may end with
+// suffixes of different lengths. Replicas inserting between the same
+// neighbors at the same time extend the same key, so their keys differ as
+// long as the suffixes they use then differ, which they do if either
+// replica knows of the other: suffixes of different lengths always differ.
+func (a *Alphabet) NewAllocator(seed []byte, others ...[]byte) Allocator {
+	suffix := a.suffixFrom(seed)
+	length := MinSuffixLength
+	for _, other := range others {
+		if bytes.Equal(other, seed) {
+			continue
+		}
+		theirs := a.suffixFrom(other)
+		for length < MaxSuffixLength && bytes.Equal(suffix[:length], theirs[:length]) {
+			length++
+		}
+	}
+	return Allocator{alphabet: a, suffix: suffix[:length]}
+}
+
+// RandomAllocator returns an allocator with a random suffix of
+// MaxSuffixLength bytes, for replicas without a stable identity.
+func (a *Alphabet) RandomAllocator() Allocator {
+	var seed [8]byte
+	_, _ = rand.Read(seed[:])
+	return Allocator{alphabet: a, suffix: a.suffixFrom(seed[:])}
+}
+
+// suffixFrom returns the longest suffix for seed. Shorter ones are its
+// prefixes.
+func (a *Alphabet) suffixFrom(seed []byte) OrderString {
+	h := fnv.New64a()
+	_, _ = h.Write(seed)
+	n := h.Sum64()
+	digits := make([]int, MaxSuffixLength)
+	for i := range digits {
+		radix := uint64(a.Radix() - 1)
+		if i == 0 {
+			radix = uint64(a.firstSuffixDigits())
+		}
+		digits[i] = 1 + int(n%radix)
+		n /= radix
+	}
+	return a.fromDigits(digits)
+}
+
+// Suffix returns the suffix the allocator appends to keys.
+func (a Allocator) Suffix() OrderString {
+	return a.suffix
+}
+
+// MidString returns a key between lower and upper ending with the
+// allocator's suffix. Between two bounds it is Alphabet.MidString's key.
+// With only one bound, it is the shortest key one step past that bound:
+// halving the open space instead would grow keys by a byte every few
+// items added to the same end of a list, where stepping does so only
+// about every Radix items.
+func (a Allocator) MidString(lower, upper OrderString) (OrderString, error) {
+	var mid OrderString
+	var err error
+	switch {
+	case len(lower) > 0 && len(upper) == 0:
+		mid, err = a.alphabet.after(lower)
+	case len(lower) == 0 && len(upper) > 0:
+		mid, err = a.alphabet.before(upper)
+	default:
+		mid, err = a.alphabet.MidString(lower, upper)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// No key returned above is a prefix of upper, so extending it keeps
+	// it below upper.
+	return append(mid, a.suffix...), nil
+}
+
+// after returns the shortest key greater than lower: lower up to its first
+// digit that can be incremented, incremented.
+func (a *Alphabet) after(lower OrderString) (OrderString, error) {
+	if err := a.checkBounds(lower, nil); err != nil {
+		return nil, err
+	}
+	digits := a.toDigits(lower)
+	for i, d := range digits {
+		if d < a.Radix()-1 {
+			return a.fromDigits(append(digits[:i:i], d+1)), nil
+		}
+	}
+	return a.fromDigits(append(digits, 1)), nil
+}
+
+// before returns a short key less than upper: upper up to its first digit
+// that can be decremented without ending the key with the lowest symbol,
+// decremented, or followed by the highest symbol where it is the second
+// lowest.
+func (a *Alphabet) before(upper OrderString) (OrderString, error) {
+	if err := a.checkBounds(nil, upper); err != nil {
+		return nil, err
+	}
+	digits := a.toDigits(upper)
+	for i, d := range digits {
+		switch {
+		case d > 1:
+			return a.fromDigits(append(digits[:i:i], d-1)), nil
+		case d == 1:
+			return a.fromDigits(append(digits[:i:i], 0, a.Radix()-1)), nil
+		}
+	}
+	// Valid keys do not end with the lowest symbol, so some digit is
+	// above it.
+	panic("unreachable")
+}
+
+// Between returns n keys between lower and upper, as Alphabet.Between
+// does, each ending with the allocator's suffix.
+func (a Allocator) Between(lower, upper OrderString, n int) ([]OrderString, error) {
+	keys, err := a.alphabet.Between(lower, upper, n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i] = append(keys[i], a.suffix...)
+	}
+	return keys, nil
+}
@@ -0,0 +1,193 @@
+package orderstring_test
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestAllocatorMidString(t *testing.T) {
+	laptop := orderstring.NewAllocator([]byte("laptop"))
+	desktop := orderstring.NewAllocator([]byte("desktop"))
+	if bytes.Equal(laptop.Suffix(), desktop.Suffix()) {
+		t.Fatalf("allocators for different replicas share the suffix %q", laptop.Suffix())
+	}
+
+	cases := []struct{ left, right string }{
+		{"", ""}, {"b", ""}, {"", "b"}, {"", "ab"}, {"az", "b"}, {"bc", "c"}, {"n", "nb"},
+	}
+	for _, c := range cases {
+		a, err := laptop.MidString([]byte(c.left), []byte(c.right))
+		if err != nil {
+			t.Fatalf("MidString(%q, %q) error: %s", c.left, c.right, err)
+		}
+		b, err := desktop.MidString([]byte(c.left), []byte(c.right))
+		if err != nil {
+			t.Fatalf("MidString(%q, %q) error: %s", c.left, c.right, err)
+		}
+		for _, key := range []orderstring.OrderString{a, b} {
+			if !key.Valid() || bytes.Compare([]byte(c.left), key) >= 0 ||
+				(c.right != "" && bytes.Compare(key, []byte(c.right)) >= 0) {
+				t.Errorf("MidString(%q, %q) = %q, not a valid key between them", c.left, c.right, key)
+			}
+		}
+		if bytes.Equal(a, b) {
+			t.Errorf("MidString(%q, %q) = %q for both replicas", c.left, c.right, a)
+		}
+	}
+}
+
+func TestAllocatorSuffixesDistinct(t *testing.T) {
+	const n = 1000
+	var seeds [][]byte
+	for i := range n {
+		seeds = append(seeds, []byte(fmt.Sprintf("replica %d", i)))
+	}
+	seen := make(map[string]int)
+	longest := 0
+	for i, seed := range seeds {
+		suffix := string(orderstring.NewAllocator(seed, seeds...).Suffix())
+		if j, ok := seen[suffix]; ok {
+			t.Fatalf("replicas %d and %d share the suffix %q", j, i, suffix)
+		}
+		seen[suffix] = i
+		longest = max(longest, len(suffix))
+	}
+	// A thousand replicas are told apart well before the maximum length.
+	if longest >= orderstring.MaxSuffixLength {
+		t.Errorf("longest suffix among %d replicas is %d bytes, want less than %d", n, longest, orderstring.MaxSuffixLength)
+	}
+
+	alone := orderstring.NewAllocator(seeds[0]).Suffix()
+	if len(alone) != orderstring.MinSuffixLength {
+		t.Errorf("suffix of a replica that knows of no others = %q, want %d bytes", alone, orderstring.MinSuffixLength)
+	}
+	if random := orderstring.RandomAllocator().Suffix(); len(random) != orderstring.MaxSuffixLength {
+		t.Errorf("suffix of a random allocator = %q, want %d bytes", random, orderstring.MaxSuffixLength)
+	}
+}
+
+// replica is one simulated replica's view of a list of keys.
+type replica struct {
+	allocator orderstring.Allocator
+	keys      []orderstring.OrderString
+	// intent records, for each key this replica generated, the neighbors
+	// it was generated between.
+	intent map[string][2]orderstring.OrderString
+}
+
+func (r *replica) insert(t *testing.T, at int) {
+	t.Helper()
+	at %= len(r.keys) + 1
+	var lower, upper orderstring.OrderString
+	if at > 0 {
+		lower = r.keys[at-1]
+	}
+	if at < len(r.keys) {
+		upper = r.keys[at]
+	}
+	key, err := r.allocator.MidString(lower, upper)
+	if err != nil {
+		t.Fatalf("MidString(%q, %q) error: %s", lower, upper, err)
+	}
+	r.keys = slices.Insert(r.keys, at, key)
+	r.intent[string(key)] = [2]orderstring.OrderString{lower, upper}
+}
+
+func (r *replica) merge(other *replica) {
+	r.keys = append(r.keys, other.keys...)
+	slices.SortFunc(r.keys, func(a, b orderstring.OrderString) int { return bytes.Compare(a, b) })
+	r.keys = slices.CompactFunc(r.keys, func(a, b orderstring.OrderString) bool { return bytes.Equal(a, b) })
+}
+
+// FuzzAllocatorConcurrentInserts simulates replicas that insert keys into
+// their own copies of a list and occasionally merge with each other. Every
+// key must be unique across replicas, and must end up between the
+// neighbors it was inserted between.
+func FuzzAllocatorConcurrentInserts(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	f.Add([]byte("many replicas inserting at the same spot"))
+	f.Fuzz(func(t *testing.T, script []byte) {
+		const replicas = 4
+		var seeds [][]byte
+		for i := range replicas {
+			seeds = append(seeds, []byte(fmt.Sprintf("replica %d", i)))
+		}
+		var rs []*replica
+		for i := range replicas {
+			rs = append(rs, &replica{
+				allocator: orderstring.NewAllocator(seeds[i], seeds...),
+				intent:    map[string][2]orderstring.OrderString{},
+			})
+		}
+
+		for len(script) >= 2 {
+			r := rs[int(script[0])%replicas]
+			if script[0]&0x80 != 0 {
+				r.merge(rs[int(script[1])%replicas])
+			} else {
+				r.insert(t, int(script[1]))
+			}
+			script = script[2:]
+		}
+
+		all := &replica{}
+		intent := map[string][2]orderstring.OrderString{}
+		total := 0
+		for _, r := range rs {
+			all.merge(r)
+			for key, neighbors := range r.intent {
+				if _, ok := intent[key]; ok {
+					t.Fatalf("key %q generated twice", key)
+				}
+				intent[key] = neighbors
+				total++
+			}
+		}
+		if len(all.keys) != total {
+			t.Fatalf("merged list has %d keys, want %d", len(all.keys), total)
+		}
+		for key, neighbors := range intent {
+			lower, upper := neighbors[0], neighbors[1]
+			if bytes.Compare(lower, []byte(key)) >= 0 || (len(upper) > 0 && bytes.Compare([]byte(key), upper) >= 0) {
+				t.Fatalf("key %q not between its intended neighbors %q and %q", key, lower, upper)
+			}
+		}
+	})
+}
+
+// TestAllocatorOpenEnds adds keys at either end of a list, and checks that
+// stepping past the end grows keys by a byte only every few dozen keys.
+func TestAllocatorOpenEnds(t *testing.T) {
+	const n = 2000
+	a := orderstring.Base62.NewAllocator([]byte("laptop"))
+	first, err := a.MidString(nil, nil)
+	if err != nil {
+		t.Fatalf("MidString() error: %s", err)
+	}
+	for name, step := range map[string]func(key orderstring.OrderString) (orderstring.OrderString, error){
+		"append":  func(key orderstring.OrderString) (orderstring.OrderString, error) { return a.MidString(key, nil) },
+		"prepend": func(key orderstring.OrderString) (orderstring.OrderString, error) { return a.MidString(nil, key) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			key := first
+			for range n {
+				next, err := step(key)
+				if err != nil {
+					t.Fatalf("error stepping from %q: %s", key, err)
+				}
+				if c := bytes.Compare(next, key); !orderstring.Base62.Valid(next) || (name == "append") != (c > 0) || c == 0 {
+					t.Fatalf("key %q stepped from %q is invalid or on the wrong side", next, key)
+				}
+				key = next
+			}
+			if want := len(first) + n/30; len(key) > want {
+				t.Errorf("key after %d steps is %d bytes, want at most %d", n, len(key), want)
+			}
+		})
+	}
+}
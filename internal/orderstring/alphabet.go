@@ -0,0 +1,217 @@
+package orderstring
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Alphabet is the ordered set of bytes order strings are written with.
+// Each byte is a digit, and the bytes' order is the digits' order, so
+// comparing keys as byte strings compares them as base Radix fractions.
+// Larger alphabets give shorter keys.
+type Alphabet struct {
+	symbols string
+	// digits maps each byte to its digit, or -1 if it is not a symbol.
+	digits [256]int
+}
+
+var (
+	// Lowercase is the alphabet 'a' to 'z'. The package level functions
+	// use it.
+	Lowercase = mustAlphabet("abcdefghijklmnopqrstuvwxyz")
+
+	// Base62 is the alphabet of ASCII digits and letters, which are safe
+	// unquoted in YAML, in URLs and in filenames on case sensitive file
+	// systems.
+	Base62 = mustAlphabet("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+
+	// Base64URL is the alphabet of URL and filename safe base64, from
+	// RFC 4648, in byte order.
+	Base64URL = mustAlphabet("-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz")
+
+	// Printable is the alphabet of the 95 printable ASCII bytes, from
+	// space to tilde. Keys may need quoting in YAML.
+	Printable = mustAlphabet(printableASCII())
+)
+
+// NewAlphabet returns the alphabet of the bytes in symbols, which must hold
+// at least two bytes in strictly increasing order.
+func NewAlphabet(symbols string) (*Alphabet, error) {
+	if len(symbols) < 2 {
+		return nil, fmt.Errorf("alphabet %q has fewer than two symbols: %w", symbols, ErrInvalidArgument)
+	}
+	a := &Alphabet{symbols: symbols}
+	for i := range a.digits {
+		a.digits[i] = -1
+	}
+	for i := range len(symbols) {
+		if i > 0 && symbols[i-1] >= symbols[i] {
+			return nil, fmt.Errorf("alphabet %q is not in increasing byte order: %w", symbols, ErrInvalidArgument)
+		}
+		a.digits[symbols[i]] = i
+	}
+	return a, nil
+}
+
+func mustAlphabet(symbols string) *Alphabet {
+	a, err := NewAlphabet(symbols)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func printableASCII() string {
+	var b []byte
+	for c := byte(' '); c <= '~'; c++ {
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// String returns the alphabet's symbols.
+func (a *Alphabet) String() string {
+	return a.symbols
+}
+
+// Radix returns the number of symbols in the alphabet.
+func (a *Alphabet) Radix() int {
+	return len(a.symbols)
+}
+
+// Parse returns text as a key written with the alphabet, or an error
+// wrapping ErrInvalidArgument if it is not one. It reads keys of any
+// alphabet written by OrderString.MarshalText, where
+// OrderString.UnmarshalText reads only those of Lowercase.
+func (a *Alphabet) Parse(text []byte) (OrderString, error) {
+	if !a.Valid(text) {
+		return nil, fmt.Errorf("order string %q: %w", text, ErrInvalidArgument)
+	}
+	return bytes.Clone(text), nil
+}
+
+// Valid reports whether s is a key written with the alphabet.
+func (a *Alphabet) Valid(s OrderString) bool {
+	// Reject strings with bytes lying outside the alphabet.
+	for _, b := range s {
+		if a.digits[b] < 0 {
+			return false
+		}
+	}
+
+	// Reject strings that end with the alphabet's lowest symbol,
+	// since these could not have been generated by this code
+	// and may have no solution.
+	if len(s) > 0 && s[len(s)-1] == a.symbols[0] {
+		return false
+	}
+	return true
+}
+
+func (a *Alphabet) toDigits(s OrderString) []int {
+	digits := make([]int, len(s))
+	for i, b := range s {
+		digits[i] = a.digits[b]
+	}
+	return digits
+}
+
+func (a *Alphabet) fromDigits(digits []int) OrderString {
+	s := make(OrderString, len(digits))
+	for i, d := range digits {
+		s[i] = a.symbols[d]
+	}
+	return s
+}
+
+func (a *Alphabet) checkBounds(lower, upper OrderString) error {
+	if !a.Valid(lower) || !a.Valid(upper) {
+		return fmt.Errorf(
+			"invalid byte value(s): %w", ErrInvalidArgument)
+	}
+
+	// Verify that left < right (lexicographically)
+	if len(upper) > 0 && bytes.Compare(lower, upper) >= 0 {
+		return fmt.Errorf(
+			"left is not less than right: %w", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// MidString returns a short key written with the alphabet that sorts
+// strictly between lower and upper, where an empty upper is unbounded.
+func (a *Alphabet) MidString(lower, upper OrderString) (OrderString, error) {
+	// Algorithm taken from https://stackoverflow.com/a/38927158
+	if err := a.checkBounds(lower, upper); err != nil {
+		return nil, err
+	}
+
+	// The algorithm works on digits, where -1 and Radix stand for the
+	// symbols just before and after the alphabet.
+	left := a.toDigits(lower)
+	right := a.toDigits(upper)
+	pred, succ := -1, a.Radix()
+
+	p := 0
+	n := 0
+
+	var res []int
+
+	for p == n {
+		p = pred
+		if len(res) < len(left) {
+			p = left[len(res)]
+		}
+
+		n = succ
+		if len(res) < len(right) {
+			n = right[len(res)]
+		}
+
+		if p == n {
+			res = append(res, p)
+		}
+	}
+
+	if p == pred {
+		// Left is a prefix of Right
+		//
+		// While Right's next character is the first character of the alpabet....
+		for n == 0 {
+			// Append the first character to match.
+			res = append(res, 0)
+			// Get Right's next charater.
+			if len(res) < len(right) {
+				n = right[len(res)]
+			} else {
+				n = succ
+			}
+		}
+		// If Right's next character is the second character of the alphabet...
+		if n == 1 {
+			// Append the first character of the alphabet and set r to
+			// one past the last character of the alphabet.
+			res = append(res, 0)
+			n = succ
+		}
+	} else if p+1 == n {
+		// Found consecutive digits.
+		res = append(res, p)
+		n = succ
+		for {
+			if len(res) >= len(left) {
+				p = pred
+			} else {
+				p = left[len(res)]
+			}
+			if p != succ-1 {
+				break
+			}
+			res = append(res, succ-1)
+		}
+	}
+
+	res = append(res, n-(n-p)/2)
+
+	return a.fromDigits(res), nil
+}
@@ -0,0 +1,161 @@
+package orderstring_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestNewAlphabetErrors(t *testing.T) {
+	for _, symbols := range []string{"", "a", "ba", "abb"} {
+		if _, err := orderstring.NewAlphabet(symbols); !errors.Is(err, orderstring.ErrInvalidArgument) {
+			t.Errorf("NewAlphabet(%q) error = %v, want ErrInvalidArgument", symbols, err)
+		}
+	}
+}
+
+func TestAlphabetValid(t *testing.T) {
+	cases := []struct {
+		alphabet *orderstring.Alphabet
+		key      string
+		want     bool
+	}{
+		{orderstring.Lowercase, "kilroy", true},
+		{orderstring.Lowercase, "Kilroy", false},
+		{orderstring.Base62, "Kilroy9", true},
+		{orderstring.Base62, "kilroy0", false},
+		{orderstring.Base62, "kil-roy", false},
+		{orderstring.Base64URL, "kil-roy_", true},
+		{orderstring.Base64URL, "kilroy-", false},
+		{orderstring.Printable, "{kil roy}", true},
+		{orderstring.Printable, "kilroy ", false},
+		{orderstring.Printable, "kilroy\n", false},
+	}
+	for _, c := range cases {
+		if got := c.alphabet.Valid([]byte(c.key)); got != c.want {
+			t.Errorf("%q.Valid(%q) = %v, want %v", c.alphabet, c.key, got, c.want)
+		}
+	}
+}
+
+// TestParse round trips a key of each alphabet through its text form.
+func TestParse(t *testing.T) {
+	alphabets := map[string]*orderstring.Alphabet{
+		"lowercase": orderstring.Lowercase,
+		"base62":    orderstring.Base62,
+		"base64url": orderstring.Base64URL,
+		"printable": orderstring.Printable,
+	}
+	for name, alphabet := range alphabets {
+		t.Run(name, func(t *testing.T) {
+			keys, err := alphabet.NewAllocator([]byte(name)).Between(nil, nil, 100)
+			if err != nil {
+				t.Fatalf("Between() error: %s", err)
+			}
+			for _, key := range keys {
+				text, err := key.MarshalText()
+				if err != nil {
+					t.Fatalf("MarshalText() error: %s", err)
+				}
+				got, err := alphabet.Parse(text)
+				if err != nil {
+					t.Fatalf("Parse(%q) error: %s", text, err)
+				}
+				if !bytes.Equal(got, key) {
+					t.Errorf("Parse(%q) = %q, want %q", text, got, key)
+				}
+			}
+		})
+	}
+
+	for _, text := range []string{"N", "1/2", "na"} {
+		if key, err := orderstring.Lowercase.Parse([]byte(text)); err == nil {
+			t.Errorf("Lowercase.Parse(%q) = %q, want an error", text, key)
+		}
+	}
+}
+
+// TestAlphabets builds lists by inserting at random positions, and checks
+// that every alphabet keeps keys valid and ordered.
+func TestAlphabets(t *testing.T) {
+	alphabets := map[string]*orderstring.Alphabet{
+		"lowercase": orderstring.Lowercase,
+		"base62":    orderstring.Base62,
+		"base64url": orderstring.Base64URL,
+		"printable": orderstring.Printable,
+		"binary":    must(orderstring.NewAlphabet("01")),
+	}
+	for name, alphabet := range alphabets {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, 2))
+			allocator := alphabet.NewAllocator([]byte(name))
+			var keys []orderstring.OrderString
+			for range 300 {
+				at := r.IntN(len(keys) + 1)
+				var lower, upper orderstring.OrderString
+				if at > 0 {
+					lower = keys[at-1]
+				}
+				if at < len(keys) {
+					upper = keys[at]
+				}
+				var inserted []orderstring.OrderString
+				var err error
+				switch r.IntN(3) {
+				case 0:
+					var key orderstring.OrderString
+					key, err = alphabet.MidString(lower, upper)
+					inserted = []orderstring.OrderString{key}
+				case 1:
+					inserted, err = alphabet.Between(lower, upper, 1+r.IntN(5))
+				case 2:
+					var key orderstring.OrderString
+					key, err = allocator.MidString(lower, upper)
+					inserted = []orderstring.OrderString{key}
+				}
+				if err != nil {
+					t.Fatalf("error inserting between %q and %q: %s", lower, upper, err)
+				}
+				for _, key := range inserted {
+					if !alphabet.Valid(key) {
+						t.Fatalf("generated invalid key %q", key)
+					}
+				}
+				tail := append(inserted, keys[at:]...)
+				keys = append(keys[:at:at], tail...)
+			}
+			for i := 1; i < len(keys); i++ {
+				if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+					t.Fatalf("keys %q and %q are out of order", keys[i-1], keys[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLargerAlphabetsGiveShorterKeys(t *testing.T) {
+	const n = 3000
+	longest := func(keys []orderstring.OrderString) int {
+		result := 0
+		for _, key := range keys {
+			result = max(result, len(key))
+		}
+		return result
+	}
+	if got := longest(orderstring.Lowercase.Rebalance(n)); got != 3 {
+		t.Errorf("longest Lowercase key for %d items = %d, want 3", n, got)
+	}
+	if got := longest(orderstring.Base62.Rebalance(n)); got != 2 {
+		t.Errorf("longest Base62 key for %d items = %d, want 2", n, got)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,133 @@
+package orderstring
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Between returns n keys between lower and upper written with the
+// Lowercase alphabet. See Alphabet.Between.
+func Between(lower, upper OrderString, n int) ([]OrderString, error) {
+	return Lowercase.Between(lower, upper, n)
+}
+
+// Rebalance returns n keys for a whole list written with the Lowercase
+// alphabet. See Alphabet.Rebalance.
+func Rebalance(n int) []OrderString {
+	return Lowercase.Rebalance(n)
+}
+
+// Between returns n keys strictly between lower and upper, in increasing
+// order, where an empty lower or upper is unbounded.
+//
+// Calling MidString repeatedly to insert several keys in a row halves the
+// remaining space each time, so keys grow by a byte every few inserts.
+// Between instead picks the shortest length at which n keys fit, and
+// spreads the keys evenly over the space at that length.
+func (a *Alphabet) Between(lower, upper OrderString, n int) ([]OrderString, error) {
+	if err := a.checkBounds(lower, upper); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("negative count %d: %w", n, ErrInvalidArgument)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	// Keys of a given length are read as base Radix numbers. A key k fits
+	// if lo < k < hi, where lo and hi are lower and upper truncated to
+	// that length, and its last digit is not the lowest symbol. Keys of
+	// the same length are never prefixes of each other or of upper, so
+	// keys can be extended, as an Allocator does, without reordering them.
+	want := big.NewInt(int64(n))
+	r := big.NewInt(int64(a.Radix()))
+	one := big.NewInt(1)
+	for length := 1; ; length++ {
+		lo := a.keyValue(lower, length)
+		var hi *big.Int
+		if len(upper) == 0 {
+			hi = new(big.Int).Exp(r, big.NewInt(int64(length)), nil)
+		} else {
+			hi = a.keyValue(upper, length)
+		}
+		last := new(big.Int).Sub(hi, one)
+		if last.Cmp(lo) <= 0 {
+			continue
+		}
+		count := new(big.Int).Sub(a.usable(last), a.usable(lo))
+		if count.Cmp(want) < 0 {
+			continue
+		}
+
+		first := new(big.Int).Add(lo, one)
+		if new(big.Int).Mod(first, r).Sign() == 0 {
+			first.Add(first, one)
+		}
+		offset := new(big.Int).Mod(first, r)
+		offset.Sub(offset, one)
+
+		// Place key i at the middle of the i-th of n equal slices of the
+		// count usable keys. The j-th usable key after first skips one
+		// value for every Radix-1 usable keys, counted from first's
+		// position among them.
+		keys := make([]OrderString, n)
+		twiceN := big.NewInt(int64(2 * n))
+		perRun := big.NewInt(int64(a.Radix() - 1))
+		for i := range keys {
+			j := big.NewInt(int64(2*i + 1))
+			j.Mul(j, count)
+			j.Quo(j, twiceN)
+			skipped := new(big.Int).Add(j, offset)
+			skipped.Quo(skipped, perRun)
+			k := new(big.Int).Add(first, j)
+			k.Add(k, skipped)
+			keys[i] = a.keyString(k, length)
+		}
+		return keys, nil
+	}
+}
+
+// usable returns the number of values in [1, v] whose last digit is not
+// zero.
+func (a *Alphabet) usable(v *big.Int) *big.Int {
+	multiples := new(big.Int).Quo(v, big.NewInt(int64(a.Radix())))
+	return multiples.Sub(v, multiples)
+}
+
+// Rebalance returns n evenly spread keys of minimal length, to re-key a
+// whole list of n items whose keys have grown long.
+func (a *Alphabet) Rebalance(n int) []OrderString {
+	keys, err := a.Between(nil, nil, max(n, 0))
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// keyValue returns the first length bytes of key as a base Radix number,
+// padding it with the lowest symbol if it is shorter.
+func (a *Alphabet) keyValue(key OrderString, length int) *big.Int {
+	v := new(big.Int)
+	r := big.NewInt(int64(a.Radix()))
+	for i := range length {
+		v.Mul(v, r)
+		if i < len(key) {
+			v.Add(v, big.NewInt(int64(a.digits[key[i]])))
+		}
+	}
+	return v
+}
+
+// keyString is the inverse of keyValue.
+func (a *Alphabet) keyString(v *big.Int, length int) OrderString {
+	key := make(OrderString, length)
+	v = new(big.Int).Set(v)
+	r := big.NewInt(int64(a.Radix()))
+	digit := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		v.QuoRem(v, r, digit)
+		key[i] = a.symbols[digit.Int64()]
+	}
+	return key
+}
@@ -0,0 +1,128 @@
+package orderstring_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestBetween(t *testing.T) {
+	cases := []struct {
+		left, right string
+		n           int
+		want        []string
+	}{
+		{"", "", 0, nil},
+		{"", "", 1, []string{"n"}},
+		{"", "", 3, []string{"f", "n", "v"}},
+		{"b", "d", 1, []string{"c"}},
+		{"b", "c", 1, []string{"bn"}},
+		{"", "b", 2, []string{"ah", "at"}},
+		{"z", "", 1, []string{"zn"}},
+		{"n", "nb", 1, []string{"nan"}},
+	}
+	for _, c := range cases {
+		got, err := orderstring.Between([]byte(c.left), []byte(c.right), c.n)
+		if err != nil {
+			t.Fatalf("Between(%q, %q, %d) error: %s", c.left, c.right, c.n, err)
+		}
+		var strs []string
+		for _, key := range got {
+			strs = append(strs, key.String())
+		}
+		if diff := cmp.Diff(c.want, strs); diff != "" {
+			t.Errorf("Between(%q, %q, %d) mismatch (-want, +got):\n%s", c.left, c.right, c.n, diff)
+		}
+	}
+}
+
+func TestBetweenErrors(t *testing.T) {
+	cases := []struct {
+		left, right string
+		n           int
+	}{
+		{"b", "b", 1},
+		{"n", "m", 1},
+		{"a", "", 1},
+		{"", "", -1},
+	}
+	for _, c := range cases {
+		_, err := orderstring.Between([]byte(c.left), []byte(c.right), c.n)
+		if !errors.Is(err, orderstring.ErrInvalidArgument) {
+			t.Errorf("Between(%q, %q, %d) error = %v, want ErrInvalidArgument", c.left, c.right, c.n, err)
+		}
+	}
+}
+
+func TestBetweenOrderedAndShort(t *testing.T) {
+	bounds := []struct{ left, right string }{
+		{"", ""}, {"", "b"}, {"b", "c"}, {"az", "b"}, {"kilroy", ""}, {"n", "nab"}, {"zzzz", ""},
+	}
+	for _, b := range bounds {
+		for _, n := range []int{1, 2, 25, 26, 100, 1000} {
+			t.Run(fmt.Sprintf("%q-%q-%d", b.left, b.right, n), func(t *testing.T) {
+				keys, err := orderstring.Between([]byte(b.left), []byte(b.right), n)
+				if err != nil {
+					t.Fatalf("Between() error: %s", err)
+				}
+				if len(keys) != n {
+					t.Fatalf("Between() returned %d keys, want %d", len(keys), n)
+				}
+				previous := orderstring.OrderString(b.left)
+				for _, key := range keys {
+					if !key.Valid() || bytes.Compare(previous, key) >= 0 {
+						t.Fatalf("key %q is invalid or not after %q", key, previous)
+					}
+					previous = key
+				}
+				if b.right != "" && bytes.Compare(previous, []byte(b.right)) >= 0 {
+					t.Fatalf("key %q is not before %q", previous, b.right)
+				}
+				// Each key needs at most about log26(n) bytes beyond the
+				// bounds' common length.
+				limit := max(len(b.left), len(b.right)) + 3
+				for _, key := range keys {
+					if len(key) > limit {
+						t.Errorf("key %q is longer than %d bytes", key, limit)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	keys := orderstring.Rebalance(600)
+	for i, key := range keys {
+		if len(key) > 2 {
+			t.Fatalf("Rebalance(600) key %q is longer than 2 bytes", key)
+		}
+		if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			t.Fatalf("Rebalance(600) keys %q and %q are out of order", keys[i-1], key)
+		}
+	}
+}
+
+func TestAllocatorBetween(t *testing.T) {
+	a := orderstring.NewAllocator([]byte("laptop"))
+	upper := orderstring.OrderString("c")
+	keys, err := a.Between([]byte("b"), upper, 30)
+	if err != nil {
+		t.Fatalf("Between() error: %s", err)
+	}
+	for i, key := range keys {
+		if !bytes.HasSuffix(key, a.Suffix()) {
+			t.Errorf("key %q does not end with the suffix %q", key, a.Suffix())
+		}
+		if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			t.Errorf("keys %q and %q are out of order after adding the suffix", keys[i-1], key)
+		}
+	}
+	if last := keys[len(keys)-1]; bytes.Compare(last, upper) >= 0 {
+		t.Errorf("key %q is not before %q", last, upper)
+	}
+}
@@ -64,6 +64,27 @@ func TestMidStringErrors(t *testing.T) {
 	}
 }
 
+func TestTextRoundTrip(t *testing.T) {
+	text, err := orderstring.OrderString("kilroy").MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() error: %s", err)
+	}
+	if string(text) != "kilroy" {
+		t.Errorf("MarshalText() = %q, want %q", text, "kilroy")
+	}
+
+	var s orderstring.OrderString
+	if err := s.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText() error: %s", err)
+	}
+	if string(s) != "kilroy" {
+		t.Errorf("UnmarshalText() = %q, want %q", s, "kilroy")
+	}
+	if err := s.UnmarshalText([]byte("1/2")); err == nil {
+		t.Errorf("UnmarshalText(%q) succeeded, want an error", "1/2")
+	}
+}
+
 func FuzzMidString(f *testing.F) {
 	type FuzzTestCase struct {
 		Left, Right []byte
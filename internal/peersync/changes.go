@@ -0,0 +1,96 @@
+package peersync
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+type ChangeKind string
+
+const (
+	Added        ChangeKind = "added"
+	Retitled     ChangeKind = "retitled"
+	StateChanged ChangeKind = "state changed"
+	Deleted      ChangeKind = "deleted"
+	Restored     ChangeKind = "restored"
+)
+
+// Change describes one visible effect a sync had on an item.
+type Change struct {
+	Kind  ChangeKind
+	ID    uuid.UUID
+	Title string
+	// Was is the title or state before the change, if Kind is Retitled or
+	// StateChanged.
+	Was string `json:",omitempty"`
+	// Now is the state after the change, if Kind is StateChanged.
+	Now string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Retitled:
+		return fmt.Sprintf("%s %q (was %q)", c.Kind, c.Title, c.Was)
+	case StateChanged:
+		return fmt.Sprintf("%s %q: %s -> %s", c.Kind, c.Title, c.Was, c.Now)
+	default:
+		return fmt.Sprintf("%s %q", c.Kind, c.Title)
+	}
+}
+
+type snapshotItem struct {
+	replicatedtodo.Item
+	deleted bool
+}
+
+// snapshot captures every item of a list, deleted or not, so that changes
+// to the list can be described later. An item is deleted if it was deleted
+// itself, as the list's tombstones record, so the children of a deleted
+// item are neither deleted nor restored with it.
+type snapshot map[uuid.UUID]snapshotItem
+
+func takeSnapshot(list *replicatedtodo.ItemList) snapshot {
+	deleted := make(map[uuid.UUID]bool)
+	for _, item := range list.DeletedItems() {
+		deleted[item.ID] = true
+	}
+	s := make(snapshot)
+	for _, item := range list.AllItems() {
+		s[item.ID] = snapshotItem{Item: item, deleted: deleted[item.ID]}
+	}
+	return s
+}
+
+// changesSince describes how list differs from an earlier snapshot of it,
+// in list order.
+func (s snapshot) changesSince(list *replicatedtodo.ItemList) []Change {
+	var changes []Change
+	after := takeSnapshot(list)
+	for _, item := range list.AllItems() {
+		now := after[item.ID]
+		was, ok := s[item.ID]
+		if !ok {
+			if !now.deleted {
+				changes = append(changes, Change{Kind: Added, ID: item.ID, Title: item.Title})
+			}
+			continue
+		}
+		if was.Title != now.Title {
+			changes = append(changes, Change{Kind: Retitled, ID: item.ID, Title: now.Title, Was: was.Title})
+		}
+		if was.State != now.State {
+			changes = append(changes, Change{
+				Kind: StateChanged, ID: item.ID, Title: now.Title, Was: string(was.State), Now: string(now.State),
+			})
+		}
+		switch {
+		case !was.deleted && now.deleted:
+			changes = append(changes, Change{Kind: Deleted, ID: item.ID, Title: now.Title})
+		case was.deleted && !now.deleted:
+			changes = append(changes, Change{Kind: Restored, ID: item.ID, Title: now.Title})
+		}
+	}
+	return changes
+}
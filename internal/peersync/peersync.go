@@ -0,0 +1,273 @@
+// Package peersync synchronizes two replicas of an item list directly over a
+// network connection.
+//
+// A sync is a short exchange started by the client, in which each side
+// first proves it knows a secret shared by both without sending it:
+//
+//  1. The client sends a random nonce.
+//  2. The server replies with a nonce of its own and its proof, an HMAC of
+//     both nonces keyed by the secret.
+//  3. The client checks the proof and sends its own, along with its version
+//     vector.
+//  4. The server checks the proof and replies with its version vector and
+//     the delta the client has not seen.
+//  5. The client merges that delta and sends back the delta the server has
+//     not seen.
+//  6. The server merges it and replies with the result.
+//
+// Afterward both replicas hold the same items. The secret keeps out peers
+// that do not know it, but the connection is not encrypted, so on a
+// network that is not trusted it should be tunneled, such as over SSH.
+package peersync
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Timeout bounds how long a single sync may take.
+const Timeout = 30 * time.Second
+
+// nonceSize is the size of the nonces the proofs are computed over.
+const nonceSize = 32
+
+// ErrUnauthorized is returned when the peer does not prove it knows the
+// shared secret.
+var ErrUnauthorized = errors.New("peer does not know the shared secret")
+
+type hello struct {
+	Nonce []byte
+}
+
+type challenge struct {
+	Nonce []byte
+	Proof []byte
+}
+
+type request struct {
+	Proof   []byte
+	Version replicatedtodo.VersionVector
+}
+
+type deltaMessage struct {
+	Error   string                       `json:",omitempty"`
+	Version replicatedtodo.VersionVector `json:",omitempty"`
+	Delta   *replicatedtodo.ItemList
+}
+
+type result struct {
+	Error   string   `json:",omitempty"`
+	Changes []Change `json:",omitempty"`
+}
+
+// Report describes the effect of a sync.
+type Report struct {
+	// Received lists the changes the sync made to the local list.
+	Received []Change
+	// Sent lists the changes the sync made to the peer's list.
+	Sent []Change
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// proof returns the proof that role, "client" or "server", knows secret in
+// the sync where the client and server sent the given nonces. Including the
+// role stops one side's proof from being replayed as the other's.
+func proof(secret []byte, role string, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(role))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// Server answers sync requests against a single item list.
+type Server struct {
+	mu     sync.Mutex
+	list   *replicatedtodo.ItemList
+	secret []byte
+	onSync func(Report) error
+}
+
+// NewServer returns a server syncing list with clients that know secret.
+// After each sync that changes list, onSync is called with the list
+// locked, typically to save it. If onSync returns an error the error is
+// reported to the client.
+func NewServer(list *replicatedtodo.ItemList, secret []byte, onSync func(Report) error) *Server {
+	return &Server{list: list, secret: secret, onSync: onSync}
+}
+
+// Serve accepts connections on l and syncs with each in turn until l is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			_ = s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn answers a single sync request on conn and closes it.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	var h hello
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("failed to read hello: %w", err)
+	}
+	if len(h.Nonce) != nonceSize {
+		return fmt.Errorf("failed to read hello: nonce is %d bytes, want %d", len(h.Nonce), nonceSize)
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(&challenge{Nonce: nonce, Proof: proof(s.secret, "server", h.Nonce, nonce)}); err != nil {
+		return fmt.Errorf("failed to send challenge: %w", err)
+	}
+	var req request
+	if err := dec.Decode(&req); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	if !hmac.Equal(req.Proof, proof(s.secret, "client", h.Nonce, nonce)) {
+		_ = enc.Encode(&deltaMessage{Error: ErrUnauthorized.Error()})
+		return ErrUnauthorized
+	}
+
+	s.mu.Lock()
+	delta := s.list.DeltaSince(req.Version)
+	reply := deltaMessage{Version: s.list.VersionVector(), Delta: &delta}
+	s.mu.Unlock()
+	if err := enc.Encode(&reply); err != nil {
+		return fmt.Errorf("failed to send delta: %w", err)
+	}
+
+	var theirs deltaMessage
+	if err := dec.Decode(&theirs); err != nil {
+		return fmt.Errorf("failed to read delta: %w", err)
+	}
+	res := s.apply(theirs.Delta)
+	if err := enc.Encode(&res); err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) apply(delta *replicatedtodo.ItemList) result {
+	if delta == nil {
+		return result{Error: "missing delta"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := takeSnapshot(s.list)
+	s.list.ApplyDelta(delta)
+	changes := before.changesSince(s.list)
+	if s.onSync != nil {
+		if err := s.onSync(Report{Received: changes}); err != nil {
+			return result{Error: err.Error(), Changes: changes}
+		}
+	}
+	return result{Changes: changes}
+}
+
+// Pull syncs list with the server listening at addr, which must know
+// secret.
+func Pull(ctx context.Context, addr string, secret []byte, list *replicatedtodo.ItemList) (Report, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return Report{}, err
+	}
+	defer conn.Close()
+	return Sync(conn, secret, list)
+}
+
+// Sync runs the client side of a sync over conn with a server that knows
+// secret, merging the server's changes into list.
+func Sync(conn net.Conn, secret []byte, list *replicatedtodo.ItemList) (Report, error) {
+	var report Report
+	if err := conn.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		return report, err
+	}
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	nonce, err := newNonce()
+	if err != nil {
+		return report, err
+	}
+	if err := enc.Encode(&hello{Nonce: nonce}); err != nil {
+		return report, fmt.Errorf("failed to send hello: %w", err)
+	}
+	var c challenge
+	if err := dec.Decode(&c); err != nil {
+		return report, fmt.Errorf("failed to read challenge: %w", err)
+	}
+	if !hmac.Equal(c.Proof, proof(secret, "server", nonce, c.Nonce)) {
+		return report, fmt.Errorf("failed to check server's proof: %w", ErrUnauthorized)
+	}
+	req := request{Proof: proof(secret, "client", nonce, c.Nonce), Version: list.VersionVector()}
+	if err := enc.Encode(&req); err != nil {
+		return report, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var theirs deltaMessage
+	if err := dec.Decode(&theirs); err != nil {
+		return report, fmt.Errorf("failed to read delta: %w", err)
+	}
+	if theirs.Error == ErrUnauthorized.Error() {
+		return report, fmt.Errorf("server refused sync: %w", ErrUnauthorized)
+	}
+	if theirs.Error != "" {
+		return report, fmt.Errorf("server refused sync: %s", theirs.Error)
+	}
+	if theirs.Delta == nil {
+		return report, errors.New("server sent no delta")
+	}
+	before := takeSnapshot(list)
+	list.ApplyDelta(theirs.Delta)
+	report.Received = before.changesSince(list)
+
+	delta := list.DeltaSince(theirs.Version)
+	if err := enc.Encode(&deltaMessage{Delta: &delta}); err != nil {
+		return report, fmt.Errorf("failed to send delta: %w", err)
+	}
+
+	var res result
+	if err := dec.Decode(&res); err != nil {
+		return report, fmt.Errorf("failed to read result: %w", err)
+	}
+	report.Sent = res.Changes
+	if res.Error != "" {
+		return report, fmt.Errorf("server failed to apply changes: %s", res.Error)
+	}
+	return report, nil
+}
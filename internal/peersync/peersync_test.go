@@ -0,0 +1,216 @@
+package peersync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+func newReplica(t *testing.T, name string) *replicatedtodo.ItemList {
+	t.Helper()
+	list := &replicatedtodo.ItemList{}
+	list.SetReplica(uuid.New(), name)
+	return list
+}
+
+// secret is the secret the tests' servers and clients share.
+var secret = []byte("secret")
+
+// serve starts a server for list on a loopback listener and returns its
+// address.
+func serve(t *testing.T, list *replicatedtodo.ItemList, onSync func(Report) error) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		_ = NewServer(list, secret, onSync).Serve(l)
+	}()
+	return l.Addr().String()
+}
+
+func kinds(changes []Change) []ChangeKind {
+	var result []ChangeKind
+	for _, c := range changes {
+		result = append(result, c.Kind)
+	}
+	slices.Sort(result)
+	return result
+}
+
+func TestPullConverges(t *testing.T) {
+	server := newReplica(t, "desktop")
+	client := newReplica(t, "laptop")
+
+	shared, err := server.NewTodo("shared", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	client.Merge(server)
+
+	// Both sides edit the same item, and each adds one of its own.
+	if _, err = server.NewTodo("from desktop", shared.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err = client.NewTodo("from laptop", shared.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	server.Delete(shared.ID)
+	client.Restore(shared.ID)
+
+	var serverReports []Report
+	addr := serve(t, server, func(r Report) error {
+		serverReports = append(serverReports, r)
+		return nil
+	})
+
+	report, err := Pull(context.Background(), addr, secret, client)
+	if err != nil {
+		t.Fatalf("Pull() error: %s", err)
+	}
+
+	if diff := cmp.Diff(server.Items(), client.Items()); diff != "" {
+		t.Errorf("replicas differ after Pull() (-server, +client):\n%s", diff)
+	}
+	if diff := cmp.Diff(server.DeletedItems(), client.DeletedItems()); diff != "" {
+		t.Errorf("replica trash differs after Pull() (-server, +client):\n%s", diff)
+	}
+	// The client restored the item after the server deleted it.
+	if slices.IndexFunc(client.Items(), func(i replicatedtodo.Item) bool { return i.ID == shared.ID }) < 0 {
+		t.Errorf("the later restore lost to the earlier delete")
+	}
+
+	if diff := cmp.Diff([]ChangeKind{Added}, kinds(report.Received)); diff != "" {
+		t.Errorf("Received changes mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]ChangeKind{Added, Restored}, kinds(report.Sent)); diff != "" {
+		t.Errorf("Sent changes mismatch (-want, +got):\n%s", diff)
+	}
+	if len(serverReports) != 1 || len(serverReports[0].Received) != 2 {
+		t.Errorf("server reports = %v, want one with two changes", serverReports)
+	}
+
+	// A second sync has nothing to do.
+	report, err = Pull(context.Background(), addr, secret, client)
+	if err != nil {
+		t.Fatalf("Pull() error: %s", err)
+	}
+	if len(report.Received) != 0 || len(report.Sent) != 0 {
+		t.Errorf("second Pull() report = %v, want no changes", report)
+	}
+}
+
+func TestPullReportsServerError(t *testing.T) {
+	server := newReplica(t, "desktop")
+	client := newReplica(t, "laptop")
+	if _, err := client.NewTodo("todo", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	addr := serve(t, server, func(Report) error {
+		return net.ErrClosed
+	})
+
+	if _, err := Pull(context.Background(), addr, secret, client); err == nil {
+		t.Errorf("Pull() succeeded, want the server's error")
+	}
+}
+
+func TestPullRefusesWrongSecret(t *testing.T) {
+	server := newReplica(t, "desktop")
+	client := newReplica(t, "laptop")
+	if _, err := server.NewTodo("server", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err := client.NewTodo("client", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	addr := serve(t, server, nil)
+
+	if _, err := Pull(context.Background(), addr, []byte("wrong"), client); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Pull() error = %v, want ErrUnauthorized", err)
+	}
+	if n := len(client.Items()); n != 1 {
+		t.Errorf("client holds %d items after a refused sync, want 1", n)
+	}
+	if n := len(server.Items()); n != 1 {
+		t.Errorf("server holds %d items after a refused sync, want 1", n)
+	}
+}
+
+// TestServerRefusesBadProof plays a client that skips checking the
+// server's proof and guesses its own.
+func TestServerRefusesBadProof(t *testing.T) {
+	server := newReplica(t, "desktop")
+	if _, err := server.NewTodo("server", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(server, secret, nil).ServeConn(serverConn)
+	}()
+
+	enc := json.NewEncoder(clientConn)
+	dec := json.NewDecoder(clientConn)
+	nonce, err := newNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(&hello{Nonce: nonce}); err != nil {
+		t.Fatal(err)
+	}
+	var c challenge
+	if err := dec.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(&request{Proof: proof([]byte("wrong"), "client", nonce, c.Nonce)}); err != nil {
+		t.Fatal(err)
+	}
+	var reply deltaMessage
+	if err := dec.Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Delta != nil || reply.Error == "" {
+		t.Errorf("server replied %+v to a bad proof, want an error and no delta", reply)
+	}
+	if err := <-done; !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("ServeConn() error = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestPullRestoresSubtree(t *testing.T) {
+	server := newReplica(t, "desktop")
+	client := newReplica(t, "laptop")
+
+	parent, err := server.NewTodo("parent", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err = server.NewTodoUnder("child", parent.ID, uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	server.Delete(parent.ID)
+	client.Merge(server)
+	server.Restore(parent.ID)
+
+	addr := serve(t, server, func(Report) error { return nil })
+	report, err := Pull(context.Background(), addr, secret, client)
+	if err != nil {
+		t.Fatalf("Pull() error: %s", err)
+	}
+	// The child was in the client's list all along, hidden in the trash
+	// with its parent, so only the parent's restore is received.
+	if diff := cmp.Diff([]ChangeKind{Restored}, kinds(report.Received)); diff != "" {
+		t.Errorf("Received changes mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,99 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock is a source of physical time. It exists so tests can substitute a
+// deterministic clock for the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Timestamp is a reading of a HybridClock. Timestamps are totally ordered:
+// first by wall time, then by logical counter, then by the replica that
+// produced them, so no two writes ever carry the same timestamp.
+type Timestamp struct {
+	Wall    time.Time
+	Counter uint32
+	Replica uuid.UUID
+}
+
+// Compare returns -1, 0 or +1 depending on whether t is before, equal to or
+// after other.
+func (t Timestamp) Compare(other Timestamp) int {
+	if c := t.Wall.Compare(other.Wall); c != 0 {
+		return c
+	}
+	if t.Counter != other.Counter {
+		if t.Counter < other.Counter {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(t.Replica[:], other.Replica[:])
+}
+
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%s+%d@%s", t.Wall.Format(time.RFC3339Nano), t.Counter, t.Replica)
+}
+
+// HybridClock is a hybrid logical clock. It issues timestamps that follow
+// physical time when it is ahead of everything the clock has seen, and
+// otherwise advance a logical counter, so a replica whose physical clock
+// lags still orders its writes after the writes it has merged.
+type HybridClock struct {
+	clock   Clock
+	replica uuid.UUID
+	last    Timestamp
+}
+
+func NewHybridClock(clock Clock, replica uuid.UUID) *HybridClock {
+	return &HybridClock{clock: clock, replica: replica}
+}
+
+func newSystemHybridClock() *HybridClock {
+	return NewHybridClock(systemClock{}, uuid.New())
+}
+
+func (c *HybridClock) Replica() uuid.UUID {
+	return c.replica
+}
+
+// Now returns a timestamp greater than every timestamp previously returned
+// by or observed by c.
+func (c *HybridClock) Now() Timestamp {
+	wall := c.clock.Now().Round(0).UTC()
+	switch {
+	case wall.After(c.last.Wall):
+		c.last = Timestamp{Wall: wall}
+	case c.last.Counter == math.MaxUint32:
+		c.last = Timestamp{Wall: c.last.Wall.Add(time.Nanosecond)}
+	default:
+		c.last.Counter++
+	}
+	c.last.Replica = c.replica
+	return c.last
+}
+
+// Observe advances c past a timestamp received from another replica.
+func (c *HybridClock) Observe(t Timestamp) {
+	if t.Compare(c.last) > 0 {
+		c.last = t
+	}
+}
@@ -0,0 +1,94 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestHybridClockMonotonic(t *testing.T) {
+	physical := newFakeClock()
+	clock := NewHybridClock(physical, uuid.New())
+
+	a := clock.Now()
+	b := clock.Now()
+	if b.Compare(a) <= 0 {
+		t.Errorf("Now() = %v after %v with a stopped clock, want later", b, a)
+	}
+
+	physical.now = physical.now.Add(-time.Hour)
+	c := clock.Now()
+	if c.Compare(b) <= 0 {
+		t.Errorf("Now() = %v after %v with a clock going backward, want later", c, b)
+	}
+
+	physical.now = physical.now.Add(2 * time.Hour)
+	d := clock.Now()
+	if !d.Wall.Equal(physical.now) || d.Counter != 0 {
+		t.Errorf("Now() = %v, want physical time %v with a zero counter", d, physical.now)
+	}
+}
+
+func TestHybridClockObserve(t *testing.T) {
+	physical := newFakeClock()
+	clock := NewHybridClock(physical, uuid.New())
+
+	remote := Timestamp{Wall: physical.now.Add(time.Hour), Counter: 7, Replica: uuid.New()}
+	clock.Observe(remote)
+	if got := clock.Now(); got.Compare(remote) <= 0 {
+		t.Errorf("Now() = %v after observing %v, want later", got, remote)
+	}
+}
+
+func TestTimestampJSON(t *testing.T) {
+	want := Timestamp{Wall: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Counter: 3, Replica: uuid.New()}
+	bytes, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("error marshaling timestamp: %s", err)
+	}
+	var got Timestamp
+	if err := json.Unmarshal(bytes, &got); err != nil {
+		t.Fatalf("error unmarshaling timestamp: %s", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("round trip mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSkewedReplicaDoesNotWinLater(t *testing.T) {
+	fast := newFakeClock()
+	fast.now = fast.now.Add(time.Hour)
+	slow := newFakeClock()
+
+	a := NewWithClock(NewHybridClock(fast, uuid.New()))
+	b := NewWithClock(NewHybridClock(slow, uuid.New()))
+
+	id, err := a.NewTodo("written on the fast replica", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	b.Merge(a)
+	b.SetTitle(id, "written later on the slow replica")
+	a.Merge(b)
+
+	if got := a.GetItem(id).Title; got != "written later on the slow replica" {
+		t.Errorf("title after merge = %q, want the later write", got)
+	}
+}
@@ -0,0 +1,162 @@
+package replicatedtodo
+
+import (
+	"github.com/google/uuid"
+)
+
+// VersionVector summarizes the writes a model has seen: for each replica,
+// the timestamp of the latest write from that replica.
+//
+// Because a replica's timestamps only grow, a model that has seen a write
+// from a replica has also seen, or superseded, every earlier write from it.
+type VersionVector map[uuid.UUID]Timestamp
+
+func (vv VersionVector) observe(t Timestamp) {
+	if t.IsZero() {
+		return
+	}
+	if latest, ok := vv[t.Replica]; !ok || t.Compare(latest) > 0 {
+		vv[t.Replica] = t
+	}
+}
+
+// Includes reports whether a write stamped t is covered by vv. The zero
+// timestamp, which marks a register that was never written, is always
+// covered.
+func (vv VersionVector) Includes(t Timestamp) bool {
+	if t.IsZero() {
+		return true
+	}
+	latest, ok := vv[t.Replica]
+	return ok && t.Compare(latest) <= 0
+}
+
+// IncludesAll reports whether every write stamped with one of timestamps
+// is covered by vv.
+func (vv VersionVector) IncludesAll(timestamps []Timestamp) bool {
+	for _, t := range timestamps {
+		if !vv.Includes(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge folds other into vv.
+func (vv VersionVector) Merge(other VersionVector) {
+	for _, t := range other {
+		vv.observe(t)
+	}
+}
+
+// VersionVector returns the version vector of the writes in the model.
+func (model *PersistedModel) VersionVector() VersionVector {
+	vv := make(VersionVector)
+	for _, item := range model.Items {
+		for _, t := range item.Title.timestamps() {
+			vv.observe(t)
+		}
+		vv.observe(item.State.Timestamp)
+		vv.observe(item.Deleted.Timestamp)
+		vv.observe(item.Order.Timestamp)
+		for _, t := range item.Notes.timestamps() {
+			vv.observe(t)
+		}
+		vv.observe(item.Due.Timestamp)
+		vv.observe(item.Priority.Timestamp)
+		for _, t := range item.Tags.Timestamps() {
+			vv.observe(t)
+		}
+	}
+	for _, replica := range model.Replicas {
+		vv.observe(replica.Name.Timestamp)
+	}
+	for _, t := range model.Settings.timestamps() {
+		vv.observe(t)
+	}
+	for _, op := range model.Log {
+		vv.observe(op.Timestamp)
+	}
+	// Compact drops history the local replica has seen, which it recorded
+	// in its Seen first.
+	if model.clock != nil {
+		if replica, ok := model.Replicas[model.clock.Replica()]; ok {
+			vv.Merge(replica.Seen)
+		}
+	}
+	return vv
+}
+
+// DeltaSince returns a model holding only the writes not covered by vv, the
+// version vector of a peer. Merging the delta into the peer, with
+// ApplyDelta or Merge, has the same effect as merging the whole model.
+//
+// Registers the peer has already seen are left at their zero value in the
+// delta, which loses to any written value when merged, and texts hold only
+// the edits the peer has not seen. The replica table is small and is always
+// included in full.
+func (model *PersistedModel) DeltaSince(vv VersionVector) *PersistedModel {
+	delta := &PersistedModel{
+		Items:    make(map[uuid.UUID]*PersistedItem),
+		Replicas: make(map[uuid.UUID]*PersistedReplica),
+	}
+	for id, item := range model.Items {
+		var changed PersistedItem
+		send := !vv.Includes(item.Order.Timestamp)
+		if !vv.IncludesAll(item.Title.timestamps()) {
+			changed.Title = item.Title.since(vv)
+			send = true
+		}
+		if !vv.Includes(item.State.Timestamp) {
+			changed.State = item.State
+			send = true
+		}
+		if !vv.Includes(item.Deleted.Timestamp) {
+			changed.Deleted = item.Deleted
+			send = true
+		}
+		if !vv.IncludesAll(item.Notes.timestamps()) {
+			changed.Notes = item.Notes.since(vv)
+			send = true
+		}
+		if !vv.Includes(item.Due.Timestamp) {
+			changed.Due = item.Due
+			send = true
+		}
+		if !vv.Includes(item.Priority.Timestamp) {
+			changed.Priority = item.Priority
+			send = true
+		}
+		// The tag set is small, and is sent whole if any of it changed.
+		if !vv.IncludesAll(item.Tags.Timestamps()) {
+			changed.Tags = item.Tags.Clone()
+			send = true
+		}
+		if !send {
+			continue
+		}
+		// The order is always sent because sorting needs every item to
+		// have one.
+		changed.ID = item.ID
+		changed.Order = item.Order
+		delta.Items[id] = changed.clone()
+	}
+	for id, replica := range model.Replicas {
+		delta.Replicas[id] = replica.clone()
+	}
+	if !vv.IncludesAll(model.Settings.timestamps()) {
+		settings := *model.Settings
+		delta.Settings = &settings
+	}
+	for _, op := range model.Log {
+		if !vv.Includes(op.Timestamp) {
+			delta.Log = append(delta.Log, op)
+		}
+	}
+	return delta
+}
+
+// ApplyDelta merges a delta produced by DeltaSince into the model.
+func (model *PersistedModel) ApplyDelta(delta *PersistedModel) {
+	model.Merge(delta)
+}
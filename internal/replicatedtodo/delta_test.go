@@ -0,0 +1,78 @@
+package replicatedtodo
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestApplyDeltaMatchesMerge(t *testing.T) {
+	r := rand.New(rand.NewPCG(9, 10))
+	for range 50 {
+		replicas := randomHistory(t, r)
+		source, peer := replicas[0], replicas[1]
+
+		want := New()
+		want.Merge(peer)
+		want.Merge(source)
+
+		got := New()
+		got.Merge(peer)
+		got.ApplyDelta(source.DeltaSince(peer.VersionVector()))
+
+		if diff := cmp.Diff(want, got, modelOptions); diff != "" {
+			t.Fatalf("ApplyDelta() mismatch with Merge() (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestDeltaSinceOmitsSeenWrites(t *testing.T) {
+	physical := newFakeClock()
+	source := NewWithClock(NewHybridClock(physical, uuid.New()))
+	source.SetTextMerge(true)
+	unchanged, err := source.NewTodo("unchanged", orderstring.OrderString("i"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	edited, err := source.NewTodo("edited", orderstring.OrderString("r"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+
+	peer := NewWithClock(NewHybridClock(physical, uuid.New()))
+	peer.Merge(source)
+	vv := peer.VersionVector()
+
+	if delta := source.DeltaSince(vv); len(delta.Items) != 0 || len(delta.Log) != 0 {
+		t.Errorf("DeltaSince() of a synced peer = %s, want no items or operations", delta.DebugString())
+	}
+
+	source.SetTitle(edited, "edited again")
+	delta := source.DeltaSince(vv)
+	if _, ok := delta.Items[unchanged]; ok {
+		t.Errorf("DeltaSince() included an unchanged item")
+	}
+	item, ok := delta.Items[edited]
+	switch {
+	case !ok:
+		t.Fatalf("DeltaSince() omitted an edited item")
+	case !item.Title.Timestamp.IsZero() || len(item.Title.Edits) != 1:
+		t.Errorf("DeltaSince() title = %+v, want only the new edit", item.Title)
+	case !item.State.Timestamp.IsZero():
+		t.Errorf("DeltaSince() included an unchanged state %v", item.State)
+	}
+	if len(delta.Log) != 1 {
+		t.Errorf("DeltaSince() included %d operations, want 1", len(delta.Log))
+	}
+
+	peer.ApplyDelta(delta)
+	if got := peer.GetItem(edited).Title; got != "edited again" {
+		t.Errorf("title after ApplyDelta() = %q, want %q", got, "edited again")
+	}
+	if got := peer.GetItem(edited).State; got != StateTodo {
+		t.Errorf("state after ApplyDelta() = %q, want %q", got, StateTodo)
+	}
+}
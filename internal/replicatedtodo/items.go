@@ -2,66 +2,486 @@
 package replicatedtodo
 
 import (
+	"bytes"
 	"encoding/json"
-	"math/big"
+	"fmt"
 	"slices"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
 )
 
 type Item struct {
-	Title string
-	State string
-	ID    uuid.UUID
+	Title    string
+	State    State
+	ID       uuid.UUID
+	Notes    string
+	Due      time.Time
+	Priority int
+	Tags     []string
 }
 
 type ItemList struct {
 	replicated PersistedModel
 }
 
+// Items returns the items that have not been deleted, in the order Tree
+// walks them.
 func (m *ItemList) Items() []Item {
 	var items []Item
-	for _, v := range m.replicated.sorted() {
-		items = append(items, v.Item())
+	for _, v := range m.Tree() {
+		items = append(items, v.Item)
 	}
 	return items
 }
 
-func (m *ItemList) NewTodo(title string, previous uuid.UUID) (*Item, error) {
-	items := m.replicated.sorted()
-	previousIndex := slices.IndexFunc(items, func(item *PersistedItem) bool {
+// Tree returns the items that have not been deleted, depth first, with
+// each item followed by its children. Children of deleted items are left
+// out along with their parent.
+func (m *ItemList) Tree() []TreeItem {
+	var items []TreeItem
+	m.replicated.walk(func(item *PersistedItem, parent uuid.UUID, depth int, children []*PersistedItem) bool {
+		if item.Deleted.Value {
+			return false
+		}
+		items = append(items, TreeItem{
+			Item:   item.Item(),
+			Parent: parent,
+			Depth:  depth,
+			HasChildren: slices.ContainsFunc(children, func(child *PersistedItem) bool {
+				return !child.Deleted.Value
+			}),
+		})
+		return true
+	})
+	return items
+}
+
+// DeletedItems returns the items that have been deleted, in the order Tree
+// would walk them.
+func (m *ItemList) DeletedItems() []Item {
+	var items []Item
+	m.replicated.walk(func(item *PersistedItem, _ uuid.UUID, _ int, _ []*PersistedItem) bool {
+		if item.Deleted.Value {
+			items = append(items, item.Item())
+		}
+		return true
+	})
+	return items
+}
+
+// AllItems returns every item the list holds, in the order Tree walks
+// them: those that have not been deleted, those that have, and the
+// children of deleted items, which keep whether they were deleted
+// themselves.
+func (m *ItemList) AllItems() []Item {
+	var items []Item
+	m.replicated.walk(func(item *PersistedItem, _ uuid.UUID, _ int, _ []*PersistedItem) bool {
+		items = append(items, item.Item())
+		return true
+	})
+	return items
+}
+
+// Delete moves the item, and with it its children, to the trash.
+func (m *ItemList) Delete(id uuid.UUID) {
+	m.replicated.Delete(id)
+}
+
+// Restore moves the item out of the trash.
+func (m *ItemList) Restore(id uuid.UUID) {
+	m.replicated.Restore(id)
+}
+
+// SetState changes the item's state, returning an error wrapping
+// ErrInvalidTransition if it cannot change to it from its current state.
+func (m *ItemList) SetState(id uuid.UUID, state State) error {
+	return m.replicated.SetState(id, state)
+}
+
+// ToggleDone marks an open item done, and reopens a closed one.
+func (m *ItemList) ToggleDone(id uuid.UUID) {
+	m.replicated.ToggleDone(id)
+}
+
+// CycleState moves the item to the next state of the todo, in progress,
+// done cycle.
+func (m *ItemList) CycleState(id uuid.UUID) {
+	m.replicated.CycleState(id)
+}
+
+// SetTextMerge turns merging concurrent edits to titles and notes
+// character by character on or off. The setting is kept in the list, so it
+// applies to every replica.
+func (m *ItemList) SetTextMerge(on bool) {
+	m.replicated.SetTextMerge(on)
+}
+
+// TextMerge reports whether concurrent edits to titles and notes are
+// merged character by character.
+func (m *ItemList) TextMerge() bool {
+	return m.replicated.TextMerge()
+}
+
+// SetTitle changes the item's title. With TextMerge on, concurrent edits
+// to other parts of the title on other replicas are kept.
+func (m *ItemList) SetTitle(id uuid.UUID, title string) {
+	m.replicated.SetTitle(id, title)
+}
+
+// SetNotes changes the item's notes. With TextMerge on, concurrent edits
+// to other parts of the notes on other replicas are kept.
+func (m *ItemList) SetNotes(id uuid.UUID, notes string) {
+	m.replicated.SetNotes(id, notes)
+}
+
+// SetDue sets the item's due date, or clears it if due is the zero time.
+func (m *ItemList) SetDue(id uuid.UUID, due time.Time) {
+	m.replicated.SetDue(id, due)
+}
+
+// SetPriority sets the item's priority. Higher priorities are more urgent.
+func (m *ItemList) SetPriority(id uuid.UUID, priority int) {
+	m.replicated.SetPriority(id, priority)
+}
+
+// AddTag adds the tag to the item.
+func (m *ItemList) AddTag(id uuid.UUID, tag string) {
+	m.replicated.AddTag(id, tag)
+}
+
+// RemoveTag removes the tag from the item. If another replica adds the tag
+// concurrently, the tag stays.
+func (m *ItemList) RemoveTag(id uuid.UUID, tag string) {
+	m.replicated.RemoveTag(id, tag)
+}
+
+// parent returns the parent of the item with the given ID, or the zero
+// UUID for a top level item or an item not in the list.
+func (m *ItemList) parent(id uuid.UUID) uuid.UUID {
+	return m.replicated.parents()[id]
+}
+
+// neighbors returns the orders an item placed under parent right after
+// previous, or first if previous is not one of parent's children, must
+// fall between. The item with ID exclude, if any, is ignored so that an
+// item can be moved relative to its current neighbors.
+func (m *ItemList) neighbors(parent, previous, exclude uuid.UUID) (lower, upper orderstring.OrderString) {
+	siblings := slices.DeleteFunc(m.replicated.children(m.replicated.parents())[parent], func(item *PersistedItem) bool {
+		return item.ID == exclude
+	})
+	previousIndex := slices.IndexFunc(siblings, func(item *PersistedItem) bool {
 		return item.ID == previous
 	})
-	var order big.Rat
 	if previousIndex >= 0 {
-		order.Set(items[previousIndex].Order)
+		lower = siblings[previousIndex].Order.Value
+	}
+	// Skip items sharing the lower order, since there is no room between
+	// them.
+	for _, item := range siblings[previousIndex+1:] {
+		if bytes.Compare(item.Order.Value, lower) > 0 {
+			upper = item.Order.Value
+			break
+		}
+	}
+	return lower, upper
+}
+
+// allocator returns the order key allocator for the list's replica. Keys
+// carry a suffix unique to the replica so that replicas inserting at the
+// same position concurrently do not generate the same key. The suffix is
+// only as long as it needs to be to tell the replica apart from the others
+// in the replica table.
+func (m *ItemList) allocator() orderstring.Allocator {
+	replica := m.replicated.hybridClock().Replica()
+	var others [][]byte
+	for id := range m.replicated.Replicas {
+		others = append(others, id[:])
+	}
+	return orderAlphabet.NewAllocator(replica[:], others...)
+}
+
+// maxOrderLength bounds the length of the orders NewTodo, NewTodos and
+// Move write. Inserting at the same spot again and again makes orders
+// grow, so when a new order would be longer, the siblings it would go
+// between are rebalanced first.
+const maxOrderLength = 32
+
+// orderAfter returns an order that places an item under parent right after
+// previous, as described for neighbors.
+func (m *ItemList) orderAfter(parent, previous, exclude uuid.UUID) (orderstring.OrderString, error) {
+	orders, err := m.ordersAfter(parent, previous, exclude, 1)
+	if err != nil {
+		return nil, err
+	}
+	return orders[0], nil
+}
+
+// ordersAfter returns n orders that place items under parent right after
+// previous, as described for neighbors, rebalancing parent's children if
+// they would be longer than maxOrderLength.
+func (m *ItemList) ordersAfter(parent, previous, exclude uuid.UUID, n int) ([]orderstring.OrderString, error) {
+	generate := func() ([]orderstring.OrderString, error) {
+		lower, upper := m.neighbors(parent, previous, exclude)
+		if n == 1 {
+			order, err := m.allocator().MidString(lower, upper)
+			return []orderstring.OrderString{order}, err
+		}
+		return m.allocator().Between(lower, upper, n)
 	}
-	if previousIndex+1 < len(items) {
-		order.Add(&order, items[previousIndex+1].Order)
-	} else {
-		order.Add(&order, big.NewRat(1, 1))
+	orders, err := generate()
+	if err != nil || slices.IndexFunc(orders, func(order orderstring.OrderString) bool {
+		return len(order) > maxOrderLength
+	}) < 0 {
+		return orders, err
 	}
-	order.Quo(&order, big.NewRat(2, 1))
+	if err := m.rebalanceChildren(parent, m.replicated.children(m.replicated.parents())[parent]); err != nil {
+		return nil, err
+	}
+	return generate()
+}
+
+// NewTodo adds an item right after previous, as its sibling, or first at
+// the top level if previous is the zero UUID.
+func (m *ItemList) NewTodo(title string, previous uuid.UUID) (*Item, error) {
+	return m.NewTodoUnder(title, m.parent(previous), previous)
+}
 
-	id, err := m.replicated.NewTodo(title, &order)
+// NewTodoUnder adds an item as a child of parent, or at the top level if
+// parent is the zero UUID, right after the child previous, or first if
+// previous is the zero UUID.
+func (m *ItemList) NewTodoUnder(title string, parent, previous uuid.UUID) (*Item, error) {
+	order, err := m.orderAfter(parent, previous, uuid.UUID{})
+	if err != nil {
+		return nil, err
+	}
+	id, err := m.replicated.NewTodoUnder(title, parent, order)
 	if err != nil {
 		return nil, err
 	}
 	return m.replicated.GetItem(id), nil
 }
 
-// MarshalJSON implements the json.Marshaller interface
+// NewTodos adds an item for each title, in order, right after previous, as
+// its siblings, or first at the top level if previous is the zero UUID.
+// The items' orders are spread evenly between their neighbors, so they
+// stay short however many are added.
+func (m *ItemList) NewTodos(titles []string, previous uuid.UUID) ([]*Item, error) {
+	if len(titles) == 0 {
+		return nil, nil
+	}
+	parent := m.parent(previous)
+	orders, err := m.ordersAfter(parent, previous, uuid.UUID{}, len(titles))
+	if err != nil {
+		return nil, err
+	}
+	var items []*Item
+	for i, title := range titles {
+		id, err := m.replicated.NewTodoUnder(title, parent, orders[i])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m.replicated.GetItem(id))
+	}
+	return items, nil
+}
+
+// Move places the item right after previous, as its sibling, or first at
+// the top level if previous is the zero UUID. The item's children move
+// with it. Concurrent moves of the same item resolve to the latest one.
+func (m *ItemList) Move(id uuid.UUID, previous uuid.UUID) error {
+	return m.MoveUnder(id, m.parent(previous), previous)
+}
+
+// MoveUnder makes the item a child of parent, or a top level item if
+// parent is the zero UUID, right after the child previous, or first if
+// previous is the zero UUID. An item cannot be moved under itself or its
+// descendants.
+func (m *ItemList) MoveUnder(id uuid.UUID, parent, previous uuid.UUID) error {
+	if m.replicated.getItem(id) == nil {
+		return fmt.Errorf("no item with ID %s", id)
+	}
+	order, err := m.orderAfter(parent, previous, id)
+	if err != nil {
+		return err
+	}
+	return m.replicated.MoveUnder(id, parent, order)
+}
+
+// Indent makes the item the last child of the sibling before it, skipping
+// deleted siblings. It does nothing to an item without such a sibling.
+func (m *ItemList) Indent(id uuid.UUID) error {
+	parents := m.replicated.parents()
+	children := m.replicated.children(parents)
+	siblings := children[parents[id]]
+	i := slices.IndexFunc(siblings, func(item *PersistedItem) bool {
+		return item.ID == id
+	}) - 1
+	for i >= 0 && siblings[i].Deleted.Value {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+	parent := siblings[i].ID
+	var previous uuid.UUID
+	if n := len(children[parent]); n > 0 {
+		previous = children[parent][n-1].ID
+	}
+	return m.MoveUnder(id, parent, previous)
+}
+
+// Outdent makes the item the sibling right after its parent. It does
+// nothing to a top level item.
+func (m *ItemList) Outdent(id uuid.UUID) error {
+	parent := m.parent(id)
+	if parent == (uuid.UUID{}) {
+		return nil
+	}
+	return m.MoveUnder(id, m.parent(parent), parent)
+}
+
+// Rebalance gives every item, including deleted ones, a new short order
+// that keeps its place among its siblings as Tree shows them. Orders grow
+// long when many items are inserted at the same spot one at a time. Each
+// changed order is written as an ordinary move, so a concurrent move of
+// the same item on another replica resolves as usual. Items Tree shows at
+// the top level because their parent is missing or they are part of a
+// cycle are moved to the top level.
+//
+// The new orders carry no replica suffix, so a concurrent Rebalance on
+// another replica may give other items the same orders. Items with equal
+// orders are ordered by ID until the next Rebalance.
+func (m *ItemList) Rebalance() error {
+	for parent, children := range m.replicated.children(m.replicated.parents()) {
+		if err := m.rebalanceChildren(parent, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebalanceChildren gives children, parent's children in order, new short
+// orders under parent, as Rebalance does.
+func (m *ItemList) rebalanceChildren(parent uuid.UUID, children []*PersistedItem) error {
+	orders := orderAlphabet.Rebalance(len(children))
+	for i, item := range children {
+		if bytes.Equal(item.Order.Value, orders[i]) && item.Order.Parent == parent {
+			continue
+		}
+		m.replicated.Apply(Operation{Kind: OpMove, Timestamp: m.replicated.now(), Item: item.ID, Order: orders[i], Parent: parent})
+	}
+	return nil
+}
+
+// SetClock replaces the clock that stamps the list's writes.
+func (m *ItemList) SetClock(clock *HybridClock) {
+	m.replicated.SetClock(clock)
+}
+
+// SetReplica makes the list write as the replica with the given ID and
+// name.
+func (m *ItemList) SetReplica(id uuid.UUID, name string) {
+	m.replicated.SetReplica(id, name)
+}
+
+// LastEdit returns the name of the replica that last changed the item and
+// when it did so.
+func (m *ItemList) LastEdit(id uuid.UUID) (string, time.Time) {
+	replica, at := m.replicated.LastEdit(id)
+	return m.replicated.ReplicaName(replica), at
+}
+
+// Merge folds the items of another replica into m and records the sync in
+// the replica table.
+func (m *ItemList) Merge(other *ItemList) {
+	m.replicated.Merge(&other.replicated)
+	m.replicated.RecordSync()
+}
+
+// MergeStored folds in a list read back from where lists are stored, such
+// as the saved list another process on this machine has changed or a
+// store's replica files. Unlike Merge it records no sync, since reading
+// the store is not syncing with another replica.
+func (m *ItemList) MergeStored(stored *ItemList) {
+	m.replicated.Merge(&stored.replicated)
+}
+
+// Apply applies an operation received from another replica.
+func (m *ItemList) Apply(op Operation) {
+	m.replicated.Apply(op)
+}
+
+// Operations returns the operations applied to the list that Compact has
+// not dropped, in timestamp order.
+func (m *ItemList) Operations() []Operation {
+	return m.replicated.Operations()
+}
+
+// VersionVector returns the version vector of the writes in the list.
+func (m *ItemList) VersionVector() VersionVector {
+	return m.replicated.VersionVector()
+}
+
+// DeltaSince returns the part of the list a peer with version vector vv has
+// not yet seen.
+func (m *ItemList) DeltaSince(vv VersionVector) ItemList {
+	return ItemList{replicated: *m.replicated.DeltaSince(vv)}
+}
+
+// ApplyDelta merges a delta produced by DeltaSince into the list and
+// records the sync in the replica table.
+func (m *ItemList) ApplyDelta(delta *ItemList) {
+	m.replicated.ApplyDelta(&delta.replicated)
+	m.replicated.RecordSync()
+}
+
+// ReplayDelta merges a delta the list saved earlier, such as one read back
+// from a journal. Unlike ApplyDelta it records no sync, since the delta
+// holds the list's own changes rather than another replica's.
+func (m *ItemList) ReplayDelta(delta *ItemList) {
+	m.replicated.ApplyDelta(&delta.replicated)
+}
+
+// Compact drops the history the list no longer needs because every
+// replica it knows of has seen it.
+func (m *ItemList) Compact() {
+	m.replicated.Compact()
+}
+
+// MarshalJSON implements the json.Marshaller interface. The list is
+// written with its schema version, SchemaVersion.
 func (m *ItemList) MarshalJSON() ([]byte, error) {
-	bytes, err := json.Marshal(m.replicated)
-	return bytes, err
+	return json.Marshal(struct {
+		Version int
+		*PersistedModel
+	}{SchemaVersion, &m.replicated})
 }
 
 var _ json.Marshaler = &ItemList{}
 
-// UnmarshalJSON implements the json.Unmarshaller interface
+// UnmarshalJSON implements the json.Unmarshaller interface. Lists written
+// with an older schema version are migrated to SchemaVersion, and lists
+// written with a newer one are refused with an error wrapping
+// ErrNewerSchema.
 func (m *ItemList) UnmarshalJSON(bytes []byte) error {
+	var doc document
+	if err := json.Unmarshal(bytes, &doc); err != nil {
+		return err
+	}
+	if doc == nil {
+		doc = make(document)
+	}
+	if err := doc.upgrade(migrations, SchemaVersion); err != nil {
+		return err
+	}
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
 	var replicated PersistedModel
-	if err := json.Unmarshal(bytes, &replicated); err != nil {
+	if err := json.Unmarshal(upgraded, &replicated); err != nil {
 		return err
 	}
 	m.replicated = replicated
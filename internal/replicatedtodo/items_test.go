@@ -1,8 +1,12 @@
 package replicatedtodo
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/uuid"
 )
@@ -22,7 +26,7 @@ func TestNewTodo(t *testing.T) {
 
 	item_a_expected := &Item{
 		Title: "title a",
-		State: "unchecked",
+		State: StateTodo,
 	}
 	if diff := cmp.Diff(item_a_expected, item_a, ignoreField("ID")); diff != "" {
 		t.Errorf("NewTodo(\"title a\") mismatch (-want, +got):\n%s", diff)
@@ -41,7 +45,7 @@ func TestNewTodo(t *testing.T) {
 
 	item_b_expected := &Item{
 		Title: "title b",
-		State: "unchecked",
+		State: StateTodo,
 	}
 	if diff := cmp.Diff(item_b_expected, item_b, ignoreField("ID")); diff != "" {
 		t.Errorf("NewTodo(\"title a\") mismatch (-want, +got):\n%s", diff)
@@ -60,7 +64,7 @@ func TestNewTodo(t *testing.T) {
 
 	item_c_expected := &Item{
 		Title: "title c",
-		State: "unchecked",
+		State: StateTodo,
 	}
 	if diff := cmp.Diff(item_c_expected, item_c, ignoreField("ID")); diff != "" {
 		t.Errorf("NewTodo(\"title a\") mismatch (-want, +got):\n%s", diff)
@@ -72,3 +76,324 @@ func TestNewTodo(t *testing.T) {
 		t.Errorf("NewTodo(\"title a\") mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestDeleteRestore(t *testing.T) {
+	list := ItemList{}
+	item_a, err := list.NewTodo("title a", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	item_b, err := list.NewTodo("title b", item_a.ID)
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+
+	list.Delete(item_a.ID)
+	if diff := cmp.Diff([]Item{*item_b}, list.Items()); diff != "" {
+		t.Errorf("Items() after Delete mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]Item{*item_a}, list.DeletedItems()); diff != "" {
+		t.Errorf("DeletedItems() after Delete mismatch (-want, +got):\n%s", diff)
+	}
+
+	list.Restore(item_a.ID)
+	if diff := cmp.Diff([]Item{*item_a, *item_b}, list.Items()); diff != "" {
+		t.Errorf("Items() after Restore mismatch (-want, +got):\n%s", diff)
+	}
+	if got := list.DeletedItems(); len(got) != 0 {
+		t.Errorf("DeletedItems() after Restore = %v, want none", got)
+	}
+}
+
+func titles(items []Item) []string {
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Title)
+	}
+	return result
+}
+
+func TestMove(t *testing.T) {
+	list := ItemList{}
+	var previous uuid.UUID
+	ids := map[string]uuid.UUID{}
+	for _, title := range []string{"a", "b", "c"} {
+		item, err := list.NewTodo(title, previous)
+		if err != nil {
+			t.Fatalf("error creating todo: %s", err)
+		}
+		ids[title] = item.ID
+		previous = item.ID
+	}
+
+	moves := []struct {
+		item, after string
+		want        []string
+	}{
+		{"a", "c", []string{"b", "c", "a"}},
+		{"a", "", []string{"a", "b", "c"}},
+		{"b", "c", []string{"a", "c", "b"}},
+		{"c", "b", []string{"a", "b", "c"}},
+	}
+	for _, move := range moves {
+		if err := list.Move(ids[move.item], ids[move.after]); err != nil {
+			t.Fatalf("Move(%s, %s) error: %s", move.item, move.after, err)
+		}
+		if diff := cmp.Diff(move.want, titles(list.Items())); diff != "" {
+			t.Errorf("Move(%s, %s) mismatch (-want, +got):\n%s", move.item, move.after, diff)
+		}
+	}
+
+	if err := list.Move(uuid.New(), uuid.UUID{}); err == nil {
+		t.Errorf("Move() of a missing item succeeded")
+	}
+}
+
+func TestConcurrentMoves(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetClock(NewHybridClock(physical, uuid.New()))
+	var previous uuid.UUID
+	ids := map[string]uuid.UUID{}
+	for _, title := range []string{"a", "b", "c", "d"} {
+		item, err := laptop.NewTodo(title, previous)
+		if err != nil {
+			t.Fatalf("error creating todo: %s", err)
+		}
+		ids[title] = item.ID
+		previous = item.ID
+	}
+	desktop := ItemList{}
+	desktop.SetClock(NewHybridClock(physical, uuid.New()))
+	desktop.Merge(&laptop)
+
+	// Both replicas move "a", and the desktop also moves "d".
+	if err := laptop.Move(ids["a"], ids["b"]); err != nil {
+		t.Fatalf("Move() error: %s", err)
+	}
+	physical.now = physical.now.Add(time.Second)
+	if err := desktop.Move(ids["a"], ids["d"]); err != nil {
+		t.Fatalf("Move() error: %s", err)
+	}
+	if err := desktop.Move(ids["d"], uuid.UUID{}); err != nil {
+		t.Fatalf("Move() error: %s", err)
+	}
+
+	laptop.Merge(&desktop)
+	desktop.Merge(&laptop)
+
+	// The desktop's move of "a" came last, so it wins on both replicas.
+	want := []string{"d", "b", "c", "a"}
+	if diff := cmp.Diff(want, titles(laptop.Items())); diff != "" {
+		t.Errorf("laptop order mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, titles(desktop.Items())); diff != "" {
+		t.Errorf("desktop order mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestConcurrentInsertsAtSamePosition(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetClock(NewHybridClock(physical, uuid.New()))
+	first, err := laptop.NewTodo("first", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err := laptop.NewTodo("last", first.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	desktop := ItemList{}
+	desktop.SetClock(NewHybridClock(physical, uuid.New()))
+	desktop.Merge(&laptop)
+
+	// Both replicas insert right after "first" before syncing.
+	laptopItem, err := laptop.NewTodo("from laptop", first.ID)
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	desktopItem, err := desktop.NewTodo("from desktop", first.ID)
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	laptop.Merge(&desktop)
+	desktop.Merge(&laptop)
+
+	a := laptop.replicated.Items[laptopItem.ID].Order.Value
+	b := laptop.replicated.Items[desktopItem.ID].Order.Value
+	if bytes.Equal(a, b) {
+		t.Errorf("concurrent inserts got the same order %q", a)
+	}
+	got := titles(laptop.Items())
+	if got[0] != "first" || got[3] != "last" {
+		t.Errorf("order after merge = %q, want both inserts between first and last", got)
+	}
+	if diff := cmp.Diff(got, titles(desktop.Items())); diff != "" {
+		t.Errorf("replicas disagree on order (-laptop, +desktop):\n%s", diff)
+	}
+
+	// There is room to insert between the two concurrent inserts.
+	if _, err := laptop.NewTodo("between", laptop.Items()[1].ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if got := titles(laptop.Items())[2]; got != "between" {
+		t.Errorf("third item = %q, want the item inserted between the concurrent inserts", got)
+	}
+}
+
+func TestNewTodos(t *testing.T) {
+	list := ItemList{}
+	first, err := list.NewTodo("first", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err := list.NewTodo("last", first.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+
+	var pasted []string
+	for i := range 50 {
+		pasted = append(pasted, fmt.Sprintf("pasted %d", i))
+	}
+	if _, err := list.NewTodos(pasted, first.ID); err != nil {
+		t.Fatalf("NewTodos() error: %s", err)
+	}
+
+	want := append(append([]string{"first"}, pasted...), "last")
+	if diff := cmp.Diff(want, titles(list.Items())); diff != "" {
+		t.Errorf("NewTodos() order mismatch (-want, +got):\n%s", diff)
+	}
+	// The pasted orders extend first's, which ends with a suffix, and end
+	// with one of their own.
+	suffix := len(list.allocator().Suffix())
+	for _, item := range list.replicated.Items {
+		if n := len(item.Order.Value); n > 2+2*suffix {
+			t.Errorf("item %q has a %d byte order %q", item.Title, n, item.Order.Value)
+		}
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetClock(NewHybridClock(physical, uuid.New()))
+	for i := range 100 {
+		if _, err := laptop.NewTodo(fmt.Sprintf("todo %d", i), uuid.UUID{}); err != nil {
+			t.Fatalf("error creating todo: %s", err)
+		}
+	}
+	laptop.Delete(laptop.Items()[10].ID)
+	desktop := ItemList{}
+	desktop.SetClock(NewHybridClock(physical, uuid.New()))
+	desktop.Merge(&laptop)
+
+	before := titles(laptop.Items())
+	deleted := titles(laptop.DeletedItems())
+	if err := laptop.Rebalance(); err != nil {
+		t.Fatalf("Rebalance() error: %s", err)
+	}
+	if diff := cmp.Diff(before, titles(laptop.Items())); diff != "" {
+		t.Errorf("Rebalance() changed the order (-before, +after):\n%s", diff)
+	}
+	if diff := cmp.Diff(deleted, titles(laptop.DeletedItems())); diff != "" {
+		t.Errorf("Rebalance() changed the trash (-before, +after):\n%s", diff)
+	}
+	for _, item := range laptop.replicated.Items {
+		if n := len(item.Order.Value); n > 2 {
+			t.Errorf("item %q has a %d byte order %q after Rebalance()", item.Title, n, item.Order.Value)
+		}
+	}
+
+	// The new orders reach other replicas as ordinary moves.
+	vv := desktop.VersionVector()
+	for _, op := range laptop.Operations() {
+		if !vv.Includes(op.Timestamp) {
+			if op.Kind != OpMove {
+				t.Errorf("Rebalance() wrote a %q operation, want only moves", op.Kind)
+			}
+			desktop.Apply(op)
+		}
+	}
+	if diff := cmp.Diff(before, titles(desktop.Items())); diff != "" {
+		t.Errorf("order after applying the moves mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestRebalanceOrphans(t *testing.T) {
+	list := ItemList{}
+	var previous uuid.UUID
+	for _, title := range []string{"first", "second", "third", "fourth"} {
+		item, err := list.NewTodo(title, previous)
+		if err != nil {
+			t.Fatalf("error creating todo: %s", err)
+		}
+		previous = item.ID
+	}
+	second := list.Items()[1]
+	child, err := list.NewTodoUnder("orphan", second.ID, uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	// An item whose parent is missing, as after a merge with a replica
+	// that has not sent the parent yet, is shown at the top level.
+	missing := uuid.New()
+	list.replicated.Items[child.ID].Order.Parent = missing
+	before := titles(list.Items())
+
+	if err := list.Rebalance(); err != nil {
+		t.Fatalf("Rebalance() error: %s", err)
+	}
+	if diff := cmp.Diff(before, titles(list.Items())); diff != "" {
+		t.Errorf("Rebalance() changed the order (-before, +after):\n%s", diff)
+	}
+	if parent := list.replicated.Items[child.ID].Order.Parent; parent != (uuid.UUID{}) {
+		t.Errorf("parent of the orphan after Rebalance() = %s, want the top level it is shown at", parent)
+	}
+}
+
+func TestItemFields(t *testing.T) {
+	list := ItemList{}
+	item, err := list.NewTodo("todo", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	due := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	list.SetNotes(item.ID, "first line\nsecond line")
+	list.SetDue(item.ID, due)
+	list.SetPriority(item.ID, 2)
+	list.AddTag(item.ID, "work")
+	list.AddTag(item.ID, "home")
+
+	want := Item{
+		Title:    "todo",
+		State:    StateTodo,
+		ID:       item.ID,
+		Notes:    "first line\nsecond line",
+		Due:      due,
+		Priority: 2,
+		Tags:     []string{"home", "work"},
+	}
+	if diff := cmp.Diff([]Item{want}, list.Items()); diff != "" {
+		t.Errorf("Items() mismatch (-want, +got):\n%s", diff)
+	}
+
+	data, err := yaml.Marshal(&list)
+	if err != nil {
+		t.Fatalf("Marshal() error: %s", err)
+	}
+	var loaded ItemList
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if diff := cmp.Diff([]Item{want}, loaded.Items()); diff != "" {
+		t.Errorf("Items() after YAML round trip mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(list.Operations(), loaded.Operations()); diff != "" {
+		t.Errorf("Operations() after YAML round trip mismatch (-want, +got):\n%s", diff)
+	}
+
+	list.SetDue(item.ID, time.Time{})
+	if got := list.Items()[0].Due; !got.IsZero() {
+		t.Errorf("Due after clearing = %v, want the zero time", got)
+	}
+}
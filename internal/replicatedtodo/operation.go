@@ -0,0 +1,229 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+type OperationKind string
+
+const (
+	OpNewTodo  OperationKind = "new"
+	OpSetTitle OperationKind = "title"
+	OpSetState OperationKind = "state"
+	OpDelete   OperationKind = "delete"
+	OpRestore  OperationKind = "restore"
+	OpMove     OperationKind = "move"
+
+	OpSetNotes    OperationKind = "notes"
+	OpSetDue      OperationKind = "due"
+	OpSetPriority OperationKind = "priority"
+	OpAddTag      OperationKind = "tag"
+	OpRemoveTag   OperationKind = "untag"
+
+	OpEditTitle OperationKind = "edit-title"
+	OpEditNotes OperationKind = "edit-notes"
+)
+
+// Operation is an immutable record of a single mutation of a model.
+//
+// Operations are identified by their timestamp, which is unique across
+// replicas. Applying an operation merges the registers it writes into the
+// model, so applying the same set of operations in any order, any number of
+// times, produces the same model.
+type Operation struct {
+	Kind      OperationKind
+	Timestamp Timestamp
+	Item      uuid.UUID
+	Title     string                  `json:",omitempty"`
+	State     State                   `json:",omitempty"`
+	Order     orderstring.OrderString `json:",omitempty"`
+	// Parent is the item's parent for OpNewTodo and OpMove, or the zero
+	// UUID for a top level item.
+	Parent   uuid.UUID
+	Notes    string `json:",omitempty"`
+	Due      time.Time
+	Priority int    `json:",omitempty"`
+	Tag      string `json:",omitempty"`
+	// Observed holds the timestamps of the additions of Tag removed by an
+	// OpRemoveTag operation.
+	Observed []Timestamp `json:",omitempty"`
+	// After, Insert and Delete describe the TextEdit made by an
+	// OpEditTitle or OpEditNotes operation.
+	After  *CharID     `json:",omitempty"`
+	Insert string      `json:",omitempty"`
+	Delete []CharRange `json:",omitempty"`
+}
+
+func editOperation(kind OperationKind, timestamp Timestamp, id uuid.UUID, edit TextEdit) Operation {
+	return Operation{
+		Kind:      kind,
+		Timestamp: timestamp,
+		Item:      id,
+		After:     edit.After,
+		Insert:    edit.Insert,
+		Delete:    edit.Delete,
+	}
+}
+
+// textEdit returns the TextEdit made by an OpEditTitle or OpEditNotes
+// operation.
+func (op Operation) textEdit() TextEdit {
+	return TextEdit{Timestamp: op.Timestamp, After: op.After, Insert: op.Insert, Delete: op.Delete}
+}
+
+// MarshalJSON implements the json.Marshaler interface, leaving out the
+// zero parent and due date.
+func (op Operation) MarshalJSON() ([]byte, error) {
+	type plain Operation
+	var due *time.Time
+	if !op.Due.IsZero() {
+		due = &op.Due
+	}
+	return json.Marshal(struct {
+		plain
+		Parent *uuid.UUID `json:",omitempty"`
+		Due    *time.Time `json:",omitempty"`
+	}{plain(op), optionalID(op.Parent), due})
+}
+
+var _ json.Marshaler = Operation{}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, checking that
+// the order is written with orderAlphabet.
+func (op *Operation) UnmarshalJSON(data []byte) error {
+	type plain Operation
+	var raw struct {
+		plain
+		Order json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	order, err := unmarshalOrder(raw.Order)
+	if err != nil {
+		return err
+	}
+	*op = Operation(raw.plain)
+	op.Order = order
+	return nil
+}
+
+var _ json.Unmarshaler = &Operation{}
+
+func (op Operation) String() string {
+	return fmt.Sprintf("%s %s item=%s title=%q state=%q order=%q parent=%s",
+		op.Timestamp, op.Kind, op.Item, op.Title, op.State, op.Order, op.Parent)
+}
+
+// applyTo merges the registers written by op into item.
+func (op Operation) applyTo(item *PersistedItem) {
+	switch op.Kind {
+	case OpNewTodo:
+		item.Title = item.Title.merge(newPersistedText(op.Title, op.Timestamp))
+		item.State = item.State.merge(newPersistedState(op.State, op.Timestamp))
+		item.Order = item.Order.merge(newPersistedOrder(op.Parent, op.Order, op.Timestamp))
+	case OpMove:
+		item.Order = item.Order.merge(newPersistedOrder(op.Parent, op.Order, op.Timestamp))
+	case OpSetTitle:
+		item.Title = item.Title.merge(newPersistedText(op.Title, op.Timestamp))
+	case OpEditTitle:
+		item.Title = item.Title.edit(op.textEdit())
+	case OpSetState:
+		item.State = item.State.merge(newPersistedState(op.State, op.Timestamp))
+	case OpDelete:
+		item.Deleted = item.Deleted.merge(newPersistedBool(true, op.Timestamp))
+	case OpRestore:
+		item.Deleted = item.Deleted.merge(newPersistedBool(false, op.Timestamp))
+	case OpSetNotes:
+		item.Notes = item.Notes.merge(newPersistedText(op.Notes, op.Timestamp))
+	case OpEditNotes:
+		item.Notes = item.Notes.edit(op.textEdit())
+	case OpSetDue:
+		item.Due = item.Due.merge(newPersistedTime(op.Due, op.Timestamp))
+	case OpSetPriority:
+		item.Priority = item.Priority.merge(newPersistedInt(op.Priority, op.Timestamp))
+	case OpAddTag:
+		item.Tags.Add(op.Tag, op.Timestamp)
+	case OpRemoveTag:
+		item.Tags.RemoveObserved(op.Tag, op.Observed, op.Timestamp)
+	}
+}
+
+func compareOperations(a, b Operation) int {
+	return a.Timestamp.Compare(b.Timestamp)
+}
+
+// Apply records op in the model's log and applies it to the model's items.
+// Operations already in the log are ignored.
+//
+// An operation on an item the model has not seen created yet is logged and
+// takes effect once the item arrives, whether by its OpNewTodo operation or
+// by a merge.
+func (model *PersistedModel) Apply(op Operation) {
+	i, found := slices.BinarySearchFunc(model.Log, op, compareOperations)
+	if found {
+		return
+	}
+	model.Log = slices.Insert(model.Log, i, op)
+	model.hybridClock().Observe(op.Timestamp)
+
+	if model.Items == nil {
+		model.Items = make(map[uuid.UUID]*PersistedItem)
+	}
+	item, ok := model.Items[op.Item]
+	if !ok {
+		if op.Kind != OpNewTodo {
+			return
+		}
+		item = &PersistedItem{ID: op.Item}
+		model.Items[op.Item] = item
+		model.catchUp(item)
+	}
+	op.applyTo(item)
+}
+
+// catchUp applies the logged operations on item to it, including any that
+// arrived before the item did. Applying an operation again has no effect,
+// except for those every replica has seen: Compact may have dropped the
+// history they would bring back, and every copy of the item already holds
+// their effect, so they are skipped.
+func (model *PersistedModel) catchUp(item *PersistedItem) {
+	stable := model.stable()
+	for _, op := range model.Log {
+		if op.Item == item.ID && !stable.Includes(op.Timestamp) {
+			op.applyTo(item)
+		}
+	}
+}
+
+// Operations returns the model's log in timestamp order. The operations must
+// not be modified.
+func (model *PersistedModel) Operations() []Operation {
+	return slices.Clone(model.Log)
+}
+
+// Replay builds a model from a log of operations.
+func Replay(ops []Operation) *PersistedModel {
+	model := New()
+	for _, op := range ops {
+		model.Apply(op)
+	}
+	return model
+}
+
+// mergeLog folds the operations of another log into the model's log.
+// The operations' effects are expected to arrive via a state merge, or to
+// be caught up by Merge when their item was not merged with them.
+func (model *PersistedModel) mergeLog(other []Operation) {
+	model.Log = append(model.Log, other...)
+	slices.SortFunc(model.Log, compareOperations)
+	model.Log = slices.CompactFunc(model.Log, func(a, b Operation) bool {
+		return compareOperations(a, b) == 0
+	})
+}
@@ -0,0 +1,174 @@
+package replicatedtodo
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+// randomHistory performs random mutations on several replicas that
+// occasionally merge with each other, and returns the replicas.
+func randomHistory(t *testing.T, r *rand.Rand) []*PersistedModel {
+	t.Helper()
+	physical := newFakeClock()
+	var replicas []*PersistedModel
+	for range 3 {
+		replicas = append(replicas, NewWithClock(NewHybridClock(physical, uuid.New())))
+	}
+
+	for range 30 {
+		model := replicas[r.IntN(len(replicas))]
+		list := model.Model()
+		items := list.Items()
+		switch n := r.IntN(11); {
+		case n == 0 || len(items) == 0:
+			if _, err := model.NewTodo("todo", orderstring.OrderString{byte('b' + r.IntN(24))}); err != nil {
+				t.Fatalf("error creating todo: %s", err)
+			}
+		case n == 1:
+			model.SetTitle(items[r.IntN(len(items))].ID, "edited")
+		case n == 2:
+			model.ToggleDone(items[r.IntN(len(items))].ID)
+		case n == 3:
+			model.Delete(items[r.IntN(len(items))].ID)
+		case n == 4:
+			model.Restore(items[r.IntN(len(items))].ID)
+		case n == 5:
+			id := items[r.IntN(len(items))].ID
+			var parent uuid.UUID
+			if r.IntN(2) == 0 {
+				parent = items[r.IntN(len(items))].ID
+			}
+			err := model.MoveUnder(id, parent, orderstring.OrderString{byte('b' + r.IntN(24))})
+			// Moves under the item itself or its descendants are refused.
+			if err != nil && !isAncestor(model.parents(), id, parent) {
+				t.Fatalf("error moving todo: %s", err)
+			}
+		case n == 6:
+			model.SetNotes(items[r.IntN(len(items))].ID, "notes")
+		case n == 7:
+			model.SetPriority(items[r.IntN(len(items))].ID, r.IntN(3))
+		case n == 8:
+			model.AddTag(items[r.IntN(len(items))].ID, []string{"home", "work"}[r.IntN(2)])
+		case n == 9:
+			model.RemoveTag(items[r.IntN(len(items))].ID, []string{"home", "work"}[r.IntN(2)])
+		default:
+			model.Merge(replicas[r.IntN(len(replicas))])
+		}
+	}
+
+	return replicas
+}
+
+// randomOperations returns every operation logged by randomHistory.
+func randomOperations(t *testing.T, r *rand.Rand) []Operation {
+	t.Helper()
+	all := New()
+	for _, model := range randomHistory(t, r) {
+		all.Merge(model)
+	}
+	return all.Operations()
+}
+
+func TestApplyCommutativeAndIdempotent(t *testing.T) {
+	r := rand.New(rand.NewPCG(7, 8))
+	for range 20 {
+		ops := randomOperations(t, r)
+		want := Replay(ops)
+
+		shuffled := append(ops, ops[:len(ops)/2]...)
+		r.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		got := Replay(shuffled)
+
+		if diff := cmp.Diff(want, got, modelOptions); diff != "" {
+			t.Fatalf("Replay() of shuffled operations mismatch (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestReplayReproducesModel(t *testing.T) {
+	model := New()
+	id, err := model.NewTodo("title", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	model.SetTitle(id, "new title")
+	model.ToggleDone(id)
+	model.Delete(id)
+
+	got := Replay(model.Operations())
+	if diff := cmp.Diff(model.Items, got.Items, modelOptions); diff != "" {
+		t.Errorf("Replay() mismatch (-want, +got):\n%s", diff)
+	}
+	if len(got.Log) != 4 {
+		t.Errorf("Replay() logged %d operations, want 4", len(got.Log))
+	}
+}
+
+func TestApplyBeforeCreate(t *testing.T) {
+	source := New()
+	id, err := source.NewTodo("title", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	source.SetTitle(id, "new title")
+	ops := source.Operations()
+
+	model := New()
+	model.Apply(ops[1])
+	if _, ok := model.Items[id]; ok {
+		t.Fatalf("Apply() of an edit created the item")
+	}
+	model.Apply(ops[0])
+	if got := model.GetItem(id).Title; got != "new title" {
+		t.Errorf("title = %q, want the edit applied before creation", got)
+	}
+}
+
+func TestMergeAppliesOperationsBeforeCreate(t *testing.T) {
+	source := New()
+	id, err := source.NewTodo("title", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	// The edit comes from a replica other than the one the item arrives
+	// from, so the item's state does not already hold it.
+	editor := New()
+	editor.Merge(source)
+	editor.SetTitle(id, "new title")
+	edit := editor.Operations()[len(editor.Operations())-1]
+
+	for name, arrive := range map[string]func(model *PersistedModel){
+		"merge": func(model *PersistedModel) {
+			model.Merge(source)
+		},
+		"delta": func(model *PersistedModel) {
+			model.ApplyDelta(source.DeltaSince(VersionVector{}))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			model := New()
+			model.Apply(edit)
+			arrive(model)
+			if got := model.GetItem(id).Title; got != "new title" {
+				t.Errorf("title = %q, want the edit that arrived first applied", got)
+			}
+		})
+	}
+
+	t.Run("merged into a replica with the item", func(t *testing.T) {
+		other := New()
+		other.Apply(edit)
+		model := New()
+		model.Merge(source)
+		model.Merge(other)
+		if got := model.GetItem(id).Title; got != "new title" {
+			t.Errorf("title = %q, want the merged edit applied", got)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+// orderAlphabet is the alphabet items' order keys are written with, by the
+// allocator, and checked against when they are decoded. Replicas sort keys
+// as bytes, so every replica must use the same one. Base62 keys are about
+// a fifth shorter than Lowercase ones, and every Lowercase key written by
+// schema version 2 and before is also a Base62 key, which sorts the same
+// way.
+var orderAlphabet = orderstring.Base62
+
+// PersistedOrder is a last-writer-wins register holding an item's position:
+// its parent, the zero UUID for a top level item, and its order among its
+// parent's children. Siblings are sorted by their order strings. Keeping
+// both in one register means a move to another parent is never half
+// applied.
+type PersistedOrder struct {
+	Timestamp Timestamp
+	Value     orderstring.OrderString
+	Parent    uuid.UUID
+}
+
+func newPersistedOrder(parent uuid.UUID, value orderstring.OrderString, timestamp Timestamp) PersistedOrder {
+	return PersistedOrder{Value: slices.Clone(value), Parent: parent, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of o and other. Values
+// with the same timestamp resolve to the lower order, then the lower
+// parent.
+func (o PersistedOrder) merge(other PersistedOrder) PersistedOrder {
+	if c := o.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return o
+		}
+		return other
+	}
+	if o.Value == nil {
+		return other
+	}
+	if other.Value == nil {
+		return o
+	}
+	if c := bytes.Compare(other.Value, o.Value); c != 0 {
+		if c < 0 {
+			return other
+		}
+		return o
+	}
+	if bytes.Compare(other.Parent[:], o.Parent[:]) < 0 {
+		return other
+	}
+	return o
+}
+
+// MarshalJSON implements the json.Marshaler interface, leaving out the
+// parent of top level items.
+func (o PersistedOrder) MarshalJSON() ([]byte, error) {
+	type plain PersistedOrder
+	return json.Marshal(struct {
+		plain
+		Parent *uuid.UUID `json:",omitempty"`
+	}{plain(o), optionalID(o.Parent)})
+}
+
+var _ json.Marshaler = PersistedOrder{}
+
+// optionalID returns a pointer to id, or nil for the zero UUID, so that it
+// can be left out of JSON.
+func optionalID(id uuid.UUID) *uuid.UUID {
+	if id == (uuid.UUID{}) {
+		return nil
+	}
+	return &id
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, checking that
+// the order is written with orderAlphabet.
+func (o *PersistedOrder) UnmarshalJSON(data []byte) error {
+	var plain struct {
+		Timestamp Timestamp
+		Value     json.RawMessage
+		Parent    uuid.UUID
+	}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return err
+	}
+	value, err := unmarshalOrder(plain.Value)
+	if err != nil {
+		return err
+	}
+	*o = PersistedOrder{Timestamp: plain.Timestamp, Value: value, Parent: plain.Parent}
+	return nil
+}
+
+var _ json.Unmarshaler = &PersistedOrder{}
+
+func checkOrder(order orderstring.OrderString) error {
+	if len(order) == 0 || !orderAlphabet.Valid(order) {
+		return errors.New("invalid order string")
+	}
+	return nil
+}
+
+// unmarshalOrder decodes an order string stored as JSON.
+func unmarshalOrder(data []byte) (orderstring.OrderString, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return nil, err
+	}
+	return orderAlphabet.Parse([]byte(text))
+}
+
+// ratDigits bounds the length of an order string converted from a rational
+// order. Rational orders closer together than 26^-ratDigits convert to the
+// same order string, leaving their items ordered by ID.
+const ratDigits = 24
+
+// ratAlphabet is the alphabet rational orders are converted with. It stays
+// Lowercase, which orderAlphabet was when rational orders were last
+// written, so that replicas running different versions of sift convert
+// the same old data file to the same order strings.
+var ratAlphabet = orderstring.Lowercase
+
+// ratToOrderString converts a rational order in (0..1) to an order string
+// that sorts the same way, by writing it in base 26 with 'a' as the zero
+// digit. The conversion depends only on the rational, so replicas that
+// migrate the same old data file independently agree on the result.
+func ratToOrderString(rat *big.Rat) (orderstring.OrderString, error) {
+	if rat.Sign() <= 0 || rat.Cmp(big.NewRat(1, 1)) >= 0 {
+		return nil, errors.New("rational order out of range, need (0..1) (non-inclusive)")
+	}
+	symbols := ratAlphabet.String()
+	var digits orderstring.OrderString
+	var frac, digit big.Rat
+	frac.Set(rat)
+	base := big.NewRat(int64(ratAlphabet.Radix()), 1)
+	for range ratDigits {
+		frac.Mul(&frac, base)
+		whole := new(big.Int).Quo(frac.Num(), frac.Denom())
+		digits = append(digits, symbols[whole.Int64()])
+		frac.Sub(&frac, digit.SetInt(whole))
+		if frac.Sign() == 0 {
+			break
+		}
+	}
+	// Trailing zero digits do not change the value, and order strings may
+	// not end with them.
+	digits = bytes.TrimRight(digits, symbols[:1])
+	if len(digits) == 0 {
+		digits = append(bytes.Repeat([]byte{symbols[0]}, ratDigits), symbols[1])
+	}
+	return digits, nil
+}
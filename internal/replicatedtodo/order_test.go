@@ -0,0 +1,263 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestRatToOrderString(t *testing.T) {
+	cases := []struct {
+		rat  *big.Rat
+		want string
+	}{
+		{big.NewRat(1, 2), "n"},
+		{big.NewRat(1, 4), "gn"},
+		{big.NewRat(1, 26), "b"},
+		{big.NewRat(1, 676), "ab"},
+		{big.NewRat(25, 26), "z"},
+	}
+	for _, c := range cases {
+		got, err := ratToOrderString(c.rat)
+		if err != nil {
+			t.Errorf("ratToOrderString(%s) error: %s", c.rat, err)
+		} else if string(got) != c.want {
+			t.Errorf("ratToOrderString(%s) = %q, want %q", c.rat, got, c.want)
+		}
+	}
+
+	for _, rat := range []*big.Rat{big.NewRat(0, 1), big.NewRat(1, 1), big.NewRat(-1, 2)} {
+		if got, err := ratToOrderString(rat); err == nil {
+			t.Errorf("ratToOrderString(%s) = %q, want an error", rat, got)
+		}
+	}
+}
+
+func TestRatToOrderStringPreservesOrder(t *testing.T) {
+	// Orders produced by repeatedly halving, as the rational version of
+	// NewTodo did, must keep their relative order.
+	rats := []*big.Rat{big.NewRat(1, 2)}
+	for range 40 {
+		first := new(big.Rat).Quo(rats[0], big.NewRat(2, 1))
+		last := new(big.Rat).Add(rats[len(rats)-1], big.NewRat(1, 1))
+		last.Quo(last, big.NewRat(2, 1))
+		rats = append([]*big.Rat{first}, append(rats, last)...)
+	}
+	var previous []byte
+	for _, rat := range rats {
+		order, err := ratToOrderString(rat)
+		if err != nil {
+			t.Fatalf("ratToOrderString(%s) error: %s", rat, err)
+		}
+		if !order.Valid() {
+			t.Fatalf("ratToOrderString(%s) = %q, which is invalid", rat, order)
+		}
+		if bytes.Compare(previous, order) >= 0 {
+			t.Fatalf("ratToOrderString(%s) = %q, not after %q", rat, order, previous)
+		}
+		previous = order
+	}
+}
+
+func TestLoadRationalOrders(t *testing.T) {
+	data := []byte(`
+Items:
+  01900000-0000-7000-8000-000000000001:
+    ID: 01900000-0000-7000-8000-000000000001
+    Order: 3/4
+    State: {Timestamp: "2024-06-01T12:00:00Z", Value: unchecked}
+    Title: {Timestamp: "2024-06-01T12:00:00Z", Value: second}
+  01900000-0000-7000-8000-000000000002:
+    ID: 01900000-0000-7000-8000-000000000002
+    Order: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: 1/4}
+    State: {Timestamp: "2024-06-01T12:00:00Z", Value: unchecked}
+    Title: {Timestamp: "2024-06-01T12:00:00Z", Value: first}
+Log:
+  - Kind: move
+    Item: 01900000-0000-7000-8000-000000000002
+    Timestamp: {Wall: "2024-06-01T12:00:00Z"}
+    Order: 1/4
+`)
+	var list ItemList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"first", "second"}, titles(list.Items())); diff != "" {
+		t.Errorf("Items() mismatch (-want, +got):\n%s", diff)
+	}
+	if got := string(list.Operations()[0].Order); got != "gn" {
+		t.Errorf("logged order = %q, want %q", got, "gn")
+	}
+
+	// Saving writes order strings.
+	saved, err := json.Marshal(&list)
+	if err != nil {
+		t.Fatalf("Marshal() error: %s", err)
+	}
+	if bytes.Contains(saved, []byte("/")) {
+		t.Errorf("Marshal() = %s, still contains rational orders", saved)
+	}
+}
+
+// minKeyLength returns the shortest key length at which n keys fit, which
+// is the length Rebalance gives keys for n items.
+func minKeyLength(n int) int {
+	radix := orderAlphabet.Radix()
+	length := 1
+	for count := radix - 1; count < n; count *= radix {
+		length++
+	}
+	return length
+}
+
+// TestKeyLengthBounded inserts items one at a time in patterns that make
+// orders grow, and checks that no order grows past maxOrderLength and the
+// items stay in the order they were inserted in. Rebalance and NewTodos
+// then give keys a length logarithmic in the number of items.
+func TestKeyLengthBounded(t *testing.T) {
+	const n = 2000
+	// Each pattern returns the index the next item is inserted at, given
+	// the number of items so far.
+	patterns := map[string]func(count int) int{
+		"always first":  func(int) int { return 0 },
+		"always last":   func(count int) int { return count },
+		"always second": func(count int) int { return min(count, 1) },
+	}
+	longest := func(list *ItemList) int {
+		length := 0
+		for _, item := range list.replicated.Items {
+			length = max(length, len(item.Order.Value))
+		}
+		return length
+	}
+	for name, at := range patterns {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			list := ItemList{}
+			suffix := len(list.allocator().Suffix())
+			var ids []uuid.UUID
+			var want []string
+			for i := range n {
+				index := at(len(ids))
+				var previous uuid.UUID
+				if index > 0 {
+					previous = ids[index-1]
+				}
+				title := fmt.Sprintf("todo %d", i)
+				item, err := list.NewTodo(title, previous)
+				if err != nil {
+					t.Fatalf("error creating todo: %s", err)
+				}
+				ids = slices.Insert(ids, index, item.ID)
+				want = slices.Insert(want, index, title)
+			}
+			if got := longest(&list); got > maxOrderLength {
+				t.Errorf("longest key after %d inserts is %d bytes, want at most %d", n, got, maxOrderLength)
+			}
+			if diff := cmp.Diff(want, titles(list.Items())); diff != "" {
+				t.Errorf("Items() mismatch (-want, +got):\n%s", diff)
+			}
+
+			if err := list.Rebalance(); err != nil {
+				t.Fatalf("Rebalance() error: %s", err)
+			}
+			if diff := cmp.Diff(want, titles(list.Items())); diff != "" {
+				t.Errorf("Rebalance() changed the order (-want, +got):\n%s", diff)
+			}
+			if got, want := longest(&list), minKeyLength(n); got > want {
+				t.Errorf("longest key after Rebalance() is %d bytes, want at most %d", got, want)
+			}
+
+			// Pasting as many items again at the same spot adds keys
+			// about as long as the pasted items need, beyond their
+			// neighbors' keys.
+			var previous uuid.UUID
+			if index := at(len(ids)); index > 0 {
+				previous = ids[index-1]
+			}
+			lower, upper := list.neighbors(list.parent(previous), previous, uuid.UUID{})
+			pasted := slices.Repeat([]string{"pasted"}, n)
+			if _, err := list.NewTodos(pasted, previous); err != nil {
+				t.Fatalf("NewTodos() error: %s", err)
+			}
+			bound := max(len(lower), len(upper)) + minKeyLength(n) + suffix
+			if got := longest(&list); got > bound {
+				t.Errorf("longest key after pasting %d items is %d bytes, want at most %d", n, got, bound)
+			}
+		})
+	}
+}
+
+// TestOrderAlphabet checks that new keys are written with Base62, that the
+// Lowercase keys of schema version 2 load unchanged and sort among them,
+// and that rational orders still convert to Lowercase keys.
+func TestOrderAlphabet(t *testing.T) {
+	data := []byte(`
+Version: 2
+Items:
+  01900000-0000-7000-8000-000000000001:
+    ID: 01900000-0000-7000-8000-000000000001
+    Order: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: gn}
+    State: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: todo}
+    Title: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: first}
+  01900000-0000-7000-8000-000000000002:
+    ID: 01900000-0000-7000-8000-000000000002
+    Order: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: "n"}
+    State: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: todo}
+    Title: {Timestamp: {Wall: "2024-06-01T12:00:00Z"}, Value: third}
+`)
+	var list ItemList
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	first, third := list.Items()[0], list.Items()[1]
+	if _, err := list.NewTodo("second", first.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err := list.NewTodo("fourth", third.ID); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	if _, err := list.NewTodo("zeroth", uuid.UUID{}); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	want := []string{"zeroth", "first", "second", "third", "fourth"}
+	if diff := cmp.Diff(want, titles(list.Items())); diff != "" {
+		t.Errorf("Items() mismatch (-want, +got):\n%s", diff)
+	}
+	for _, item := range list.replicated.Items {
+		if !orderstring.Base62.Valid(item.Order.Value) {
+			t.Errorf("order %q of item %s is not a Base62 key", item.Order.Value, item.ID)
+		}
+	}
+	if got := string(list.replicated.Items[third.ID].Order.Value); got != "n" {
+		t.Errorf("order of a version 2 item = %q, want it unchanged as %q", got, "n")
+	}
+
+	saved, err := json.Marshal(&list)
+	if err != nil {
+		t.Fatalf("Marshal() error: %s", err)
+	}
+	var loaded ItemList
+	if err := json.Unmarshal(saved, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if diff := cmp.Diff(want, titles(loaded.Items())); diff != "" {
+		t.Errorf("Items() after saving mismatch (-want, +got):\n%s", diff)
+	}
+
+	order, err := ratToOrderString(big.NewRat(1, 2))
+	if err != nil {
+		t.Fatalf("ratToOrderString() error: %s", err)
+	}
+	if want := "n"; string(order) != want {
+		t.Errorf("ratToOrderString(1/2) = %q, want %q", order, want)
+	}
+}
@@ -0,0 +1,243 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+)
+
+// Dot records one addition of a value to an ORSet, and the value's removal
+// if it was removed by a replica that had seen the addition.
+type Dot struct {
+	// Added is the timestamp of the addition. Timestamps are unique, so
+	// it identifies the addition.
+	Added Timestamp
+	// Removed is the timestamp of the removal, or nil.
+	Removed *Timestamp `json:",omitempty"`
+}
+
+func compareDots(a, b Dot) int {
+	return a.Added.Compare(b.Added)
+}
+
+// clone returns a copy of the dot that shares no memory with it.
+func (dot Dot) clone() Dot {
+	if dot.Removed != nil {
+		removed := *dot.Removed
+		dot.Removed = &removed
+	}
+	return dot
+}
+
+// ORSet is an add-wins observed-remove set, a CRDT.
+//
+// Every addition of a value is recorded as a dot identified by its
+// timestamp. Removing a value marks only the dots the removing replica has
+// observed, so an addition on another replica that it had not seen
+// survives: a concurrent add and remove resolve to the value being
+// present. Removed dots are kept as tombstones so the removal survives
+// merges with replicas that still have the addition, until Compact drops
+// them.
+//
+// The zero ORSet is an empty set ready to use.
+type ORSet[T comparable] struct {
+	// dots holds each value's dots sorted by Added.
+	dots map[T][]Dot
+}
+
+// Contains reports whether value is in the set.
+func (s *ORSet[T]) Contains(value T) bool {
+	return len(s.Observed(value)) > 0
+}
+
+// Values returns the values in the set, in no particular order.
+func (s *ORSet[T]) Values() []T {
+	var values []T
+	for value := range s.dots {
+		if s.Contains(value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Len returns the number of values in the set.
+func (s *ORSet[T]) Len() int {
+	return len(s.Values())
+}
+
+// Observed returns the timestamps of the additions of value that have not
+// been removed. These are the additions a removal of the value removes.
+func (s *ORSet[T]) Observed(value T) []Timestamp {
+	var added []Timestamp
+	for _, dot := range s.dots[value] {
+		if dot.Removed == nil {
+			added = append(added, dot.Added)
+		}
+	}
+	return added
+}
+
+// insert adds a copy of dot to the value's dots. If the dot is already
+// present the removals are combined, so inserting is commutative and
+// idempotent.
+func (s *ORSet[T]) insert(value T, dot Dot) {
+	if s.dots == nil {
+		s.dots = make(map[T][]Dot)
+	}
+	dot = dot.clone()
+	dots := s.dots[value]
+	i, found := slices.BinarySearchFunc(dots, dot, compareDots)
+	if !found {
+		s.dots[value] = slices.Insert(dots, i, dot)
+		return
+	}
+	if dot.Removed != nil && (dots[i].Removed == nil || dot.Removed.Compare(*dots[i].Removed) > 0) {
+		dots[i].Removed = dot.Removed
+	}
+}
+
+// Add adds value to the set. The timestamp must be unique, such as one
+// issued by a HybridClock, and identifies the addition.
+func (s *ORSet[T]) Add(value T, at Timestamp) {
+	s.insert(value, Dot{Added: at})
+}
+
+// Remove removes value from the set by removing every addition of it the
+// set has observed, and returns the timestamps of those additions.
+func (s *ORSet[T]) Remove(value T, at Timestamp) []Timestamp {
+	observed := s.Observed(value)
+	s.RemoveObserved(value, observed, at)
+	return observed
+}
+
+// RemoveObserved removes the additions of value stamped observed, as
+// returned by Remove or Observed on another replica. Additions that have
+// not arrived yet are removed when they do.
+func (s *ORSet[T]) RemoveObserved(value T, observed []Timestamp, at Timestamp) {
+	for _, added := range observed {
+		s.insert(value, Dot{Added: added, Removed: &at})
+	}
+}
+
+// Merge folds other into s. Merging is commutative, associative and
+// idempotent. The sets share no memory afterward.
+func (s *ORSet[T]) Merge(other *ORSet[T]) {
+	for value, dots := range other.dots {
+		for _, dot := range dots {
+			s.insert(value, dot)
+		}
+	}
+}
+
+// Clone returns a copy of s that shares no memory with it.
+func (s *ORSet[T]) Clone() ORSet[T] {
+	var c ORSet[T]
+	if len(s.dots) > 0 {
+		c.dots = make(map[T][]Dot, len(s.dots))
+		for value, dots := range s.dots {
+			c.dots[value] = make([]Dot, len(dots))
+			for i, dot := range dots {
+				c.dots[value][i] = dot.clone()
+			}
+		}
+	}
+	return c
+}
+
+// Compact drops the tombstones of removals covered by stable, which must
+// be a version vector that every replica has reached. Every replica then
+// knows of the removal, so none can bring the removed addition back.
+func (s *ORSet[T]) Compact(stable VersionVector) {
+	for value, dots := range s.dots {
+		dots = slices.DeleteFunc(dots, func(dot Dot) bool {
+			return dot.Removed != nil && stable.Includes(*dot.Removed) && stable.Includes(dot.Added)
+		})
+		if len(dots) == 0 {
+			delete(s.dots, value)
+		} else {
+			s.dots[value] = dots
+		}
+	}
+}
+
+// Timestamps returns the timestamp of every addition and removal in the
+// set.
+func (s *ORSet[T]) Timestamps() []Timestamp {
+	var timestamps []Timestamp
+	for _, dots := range s.dots {
+		for _, dot := range dots {
+			timestamps = append(timestamps, dot.Added)
+			if dot.Removed != nil {
+				timestamps = append(timestamps, *dot.Removed)
+			}
+		}
+	}
+	return timestamps
+}
+
+// Equal reports whether s and other hold the same additions and removals.
+func (s ORSet[T]) Equal(other ORSet[T]) bool {
+	return maps.EqualFunc(s.dots, other.dots, func(a, b []Dot) bool {
+		return slices.EqualFunc(a, b, func(a, b Dot) bool {
+			if a.Added != b.Added || (a.Removed == nil) != (b.Removed == nil) {
+				return false
+			}
+			return a.Removed == nil || *a.Removed == *b.Removed
+		})
+	})
+}
+
+// orSetEntry is the JSON form of one value of an ORSet.
+type orSetEntry[T comparable] struct {
+	Value T
+	Dots  []Dot
+}
+
+// MarshalJSON implements the json.Marshaler interface. The set is written
+// as a list of values with their dots, sorted by the values' JSON so the
+// output is deterministic.
+func (s ORSet[T]) MarshalJSON() ([]byte, error) {
+	type encoded struct {
+		key   []byte
+		entry orSetEntry[T]
+	}
+	var entries []encoded
+	for value, dots := range s.dots {
+		key, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, encoded{key, orSetEntry[T]{value, dots}})
+	}
+	slices.SortFunc(entries, func(a, b encoded) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	list := make([]orSetEntry[T], 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.entry)
+	}
+	return json.Marshal(list)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, reading the
+// list form written by MarshalJSON.
+func (s *ORSet[T]) UnmarshalJSON(data []byte) error {
+	*s = ORSet[T]{}
+	var list []orSetEntry[T]
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, entry := range list {
+		for _, dot := range entry.Dots {
+			s.insert(entry.Value, dot)
+		}
+	}
+	return nil
+}
+
+var (
+	_ json.Marshaler   = ORSet[string]{}
+	_ json.Unmarshaler = &ORSet[string]{}
+)
@@ -0,0 +1,263 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// orSetHistory is a random run of adds, removes and merges on replicas of
+// an ORSet, with every add and remove recorded.
+type orSetHistory struct {
+	replicas []*ORSet[int]
+	// adds maps each addition's timestamp to the value added.
+	adds map[Timestamp]int
+	// removed holds the timestamps of the additions removes observed.
+	removed map[Timestamp]bool
+}
+
+func randomORSets(r *rand.Rand, n int) *orSetHistory {
+	physical := newFakeClock()
+	h := &orSetHistory{adds: make(map[Timestamp]int), removed: make(map[Timestamp]bool)}
+	var clocks []*HybridClock
+	for range n {
+		h.replicas = append(h.replicas, &ORSet[int]{})
+		clocks = append(clocks, NewHybridClock(physical, uuid.New()))
+	}
+	for range r.IntN(40) {
+		i := r.IntN(n)
+		set, clock := h.replicas[i], clocks[i]
+		value := r.IntN(4)
+		switch r.IntN(3) {
+		case 0:
+			at := clock.Now()
+			set.Add(value, at)
+			h.adds[at] = value
+		case 1:
+			for _, added := range set.Remove(value, clock.Now()) {
+				h.removed[added] = true
+			}
+		case 2:
+			j := r.IntN(n)
+			set.Merge(h.replicas[j])
+			for _, t := range h.replicas[j].Timestamps() {
+				clock.Observe(t)
+			}
+		}
+	}
+	return h
+}
+
+func mergedORSets(sets ...*ORSet[int]) *ORSet[int] {
+	result := &ORSet[int]{}
+	for _, s := range sets {
+		result.Merge(s)
+	}
+	return result
+}
+
+func sortedValues(s *ORSet[int]) []int {
+	values := s.Values()
+	slices.Sort(values)
+	return values
+}
+
+func TestORSetMergeCommutativeAssociativeIdempotent(t *testing.T) {
+	r := rand.New(rand.NewPCG(21, 22))
+	for range 200 {
+		s := randomORSets(r, 3).replicas
+		if diff := cmp.Diff(mergedORSets(s[0], s[1]), mergedORSets(s[1], s[0])); diff != "" {
+			t.Fatalf("a.Merge(b) != b.Merge(a) (-ab, +ba):\n%s", diff)
+		}
+		left := mergedORSets(mergedORSets(s[0], s[1]), s[2])
+		right := mergedORSets(s[0], mergedORSets(s[1], s[2]))
+		if diff := cmp.Diff(left, right); diff != "" {
+			t.Fatalf("(a+b)+c != a+(b+c) (-left, +right):\n%s", diff)
+		}
+		once := mergedORSets(s[0], s[1])
+		twice := mergedORSets(once, s[1], once)
+		if diff := cmp.Diff(once, twice); diff != "" {
+			t.Fatalf("merge is not idempotent (-once, +twice):\n%s", diff)
+		}
+	}
+}
+
+func TestORSetMergeSharesNoMemory(t *testing.T) {
+	physical := newFakeClock()
+	clock := NewHybridClock(physical, uuid.New())
+	var a, b ORSet[string]
+	a.Add("home", clock.Now())
+	b.Merge(&a)
+	c := b.Clone()
+	b.Remove("home", clock.Now())
+	if !a.Contains("home") || !c.Contains("home") {
+		t.Errorf("removing from a merged set changed the original or a clone")
+	}
+
+	// Tombstones are copied too.
+	a.Merge(&b)
+	d := a.Clone()
+	for _, set := range []*ORSet[string]{&a, &d} {
+		if set.dots["home"][0].Removed == b.dots["home"][0].Removed {
+			t.Errorf("merged or cloned set shares a removal with the set it came from")
+		}
+	}
+}
+
+// TestORSetAddWins checks that once every replica's state is merged, a
+// value is present exactly when one of its additions was not observed by
+// any remove.
+func TestORSetAddWins(t *testing.T) {
+	r := rand.New(rand.NewPCG(23, 24))
+	for range 200 {
+		h := randomORSets(r, 3)
+		var want []int
+		for added, value := range h.adds {
+			if !h.removed[added] && !slices.Contains(want, value) {
+				want = append(want, value)
+			}
+		}
+		slices.Sort(want)
+		got := sortedValues(mergedORSets(h.replicas...))
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("values mismatch (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestORSetConcurrentAddAndRemove(t *testing.T) {
+	physical := newFakeClock()
+	laptop := NewHybridClock(physical, uuid.New())
+	desktop := NewHybridClock(physical, uuid.New())
+	var a ORSet[string]
+	a.Add("home", laptop.Now())
+	b := a.Clone()
+
+	// The laptop removes the value after the desktop adds it again.
+	b.Add("home", desktop.Now())
+	a.Remove("home", laptop.Now())
+	a.Merge(&b)
+	if !a.Contains("home") {
+		t.Errorf("concurrent add lost to a remove that had not observed it")
+	}
+	if got := a.Observed("home"); len(got) != 1 {
+		t.Errorf("Observed(home) = %v, want the desktop's addition only", got)
+	}
+}
+
+// versionVector returns the version vector of everything s has seen.
+func versionVector(s *ORSet[int]) VersionVector {
+	vv := make(VersionVector)
+	for _, t := range s.Timestamps() {
+		vv.observe(t)
+	}
+	return vv
+}
+
+// stableVersionVector returns the version vector every replica has reached.
+func stableVersionVector(replicas []*ORSet[int]) VersionVector {
+	stable := versionVector(replicas[0])
+	for _, s := range replicas[1:] {
+		vv := versionVector(s)
+		for replica, t := range stable {
+			if other, ok := vv[replica]; !ok {
+				delete(stable, replica)
+			} else if other.Compare(t) < 0 {
+				stable[replica] = other
+			}
+		}
+	}
+	return stable
+}
+
+func TestORSetCompact(t *testing.T) {
+	r := rand.New(rand.NewPCG(25, 26))
+	for range 200 {
+		h := randomORSets(r, 3)
+		want := sortedValues(mergedORSets(h.replicas...))
+
+		// Compacting any replica against the stable version vector
+		// keeps its values, and merging it with the others gives the
+		// same values as before.
+		stable := stableVersionVector(h.replicas)
+		for i, s := range h.replicas {
+			compacted := s.Clone()
+			compacted.Compact(stable)
+			if diff := cmp.Diff(sortedValues(s), sortedValues(&compacted)); diff != "" {
+				t.Fatalf("compaction changed replica %d's values (-before, +after):\n%s", i, diff)
+			}
+			others := slices.Clone(h.replicas)
+			others[i] = &compacted
+			if diff := cmp.Diff(want, sortedValues(mergedORSets(others...))); diff != "" {
+				t.Fatalf("merging compacted replica %d changed the values (-want, +got):\n%s", i, diff)
+			}
+		}
+
+		// Once every replica has everything, compaction drops every
+		// tombstone.
+		all := mergedORSets(h.replicas...)
+		all.Compact(versionVector(all))
+		for value, dots := range all.dots {
+			for _, dot := range dots {
+				if dot.Removed != nil {
+					t.Fatalf("tombstone for %d left after compacting a converged set", value)
+				}
+			}
+		}
+		if diff := cmp.Diff(want, sortedValues(all)); diff != "" {
+			t.Fatalf("compacting a converged set changed its values (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestORSetJSON(t *testing.T) {
+	r := rand.New(rand.NewPCG(27, 28))
+	for range 50 {
+		want := mergedORSets(randomORSets(r, 2).replicas...)
+		data, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		var got ORSet[int]
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s): %v", data, err)
+		}
+		if diff := cmp.Diff(*want, got); diff != "" {
+			t.Fatalf("JSON round trip mismatch (-want, +got):\n%s", diff)
+		}
+		again, err := json.Marshal(got)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		if string(again) != string(data) {
+			t.Fatalf("JSON is not deterministic:\n%s\n%s", data, again)
+		}
+	}
+}
+
+func TestORSetYAML(t *testing.T) {
+	type document struct {
+		Set ORSet[string]
+	}
+	clock := NewHybridClock(newFakeClock(), uuid.New())
+	var want document
+	want.Set.Add("home", clock.Now())
+	want.Set.Add("work", clock.Now())
+	want.Set.Remove("work", clock.Now())
+	data, err := yaml.Marshal(&want)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	var got document
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("YAML round trip mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -3,86 +3,286 @@ package replicatedtodo
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"maps"
-	"math/big"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
 )
 
 // PersistedModel is a collection of CRTDs used to store a synchronized set of items.
 //
 // Initial design inspired from https://adamreeve.co.nz/blog/todo-crdt.html
 type PersistedModel struct {
-	// This is a "grow only set" (G-Set) of items, keyed by UUID.
+	// This is a "grow only set" (G-Set) of items, keyed by UUID. Items are
+	// never removed; deleting one sets its Deleted tombstone instead, so the
+	// deletion survives merges with replicas that still have the item.
 	Items map[uuid.UUID]*PersistedItem
+
+	// Replicas records every replica that has written to the model, keyed
+	// by the replica ID found in the timestamps of its writes.
+	Replicas map[uuid.UUID]*PersistedReplica
+
+	// Log is every operation applied to the model, in timestamp order,
+	// except those Compact dropped once every replica had seen them. Items
+	// created before the log was introduced have no operations in it.
+	Log []Operation
+
+	// Settings holds the options that apply to the whole list, or nil if
+	// none has been set.
+	Settings *PersistedSettings `json:",omitempty"`
+
+	// clock stamps local writes. It is created on first use if unset.
+	clock *HybridClock
 }
 
 type PersistedString struct {
-	Timestamp time.Time
+	Timestamp Timestamp
 	Value     string
 }
 
-func newPersistedString(value string) PersistedString {
-	return PersistedString{Value: value, Timestamp: time.Now()}
+func newPersistedString(value string, timestamp Timestamp) PersistedString {
+	return PersistedString{Value: value, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of s and other. Values
+// with the same timestamp, which only happens for data written before
+// timestamps identified their replica, are ordered by their value so every
+// replica picks the same winner.
+func (s PersistedString) merge(other PersistedString) PersistedString {
+	if c := s.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return s
+		}
+		return other
+	}
+	if strings.Compare(s.Value, other.Value) >= 0 {
+		return s
+	}
+	return other
+}
+
+// PersistedBool is a last-writer-wins boolean register.
+type PersistedBool struct {
+	Timestamp Timestamp
+	Value     bool
+}
+
+func newPersistedBool(value bool, timestamp Timestamp) PersistedBool {
+	return PersistedBool{Value: value, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of b and other. Values
+// with the same timestamp resolve to true.
+func (b PersistedBool) merge(other PersistedBool) PersistedBool {
+	if c := b.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return b
+		}
+		return other
+	}
+	if b.Value {
+		return b
+	}
+	return other
+}
+
+// PersistedTime is a last-writer-wins time register.
+type PersistedTime struct {
+	Timestamp Timestamp
+	Value     time.Time
+}
+
+func newPersistedTime(value time.Time, timestamp Timestamp) PersistedTime {
+	return PersistedTime{Value: value, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of t and other. Values
+// with the same timestamp resolve to the later time.
+func (t PersistedTime) merge(other PersistedTime) PersistedTime {
+	if c := t.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return t
+		}
+		return other
+	}
+	if t.Value.Compare(other.Value) >= 0 {
+		return t
+	}
+	return other
+}
+
+// PersistedInt is a last-writer-wins integer register.
+type PersistedInt struct {
+	Timestamp Timestamp
+	Value     int
+}
+
+func newPersistedInt(value int, timestamp Timestamp) PersistedInt {
+	return PersistedInt{Value: value, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of i and other. Values
+// with the same timestamp resolve to the greater value.
+func (i PersistedInt) merge(other PersistedInt) PersistedInt {
+	if c := i.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return i
+		}
+		return other
+	}
+	if i.Value >= other.Value {
+		return i
+	}
+	return other
 }
 
 type PersistedItem struct {
-	Title PersistedString
-	State PersistedString
-	Order *big.Rat
+	Title PersistedText
+	State PersistedState
+	Order PersistedOrder
 	ID    uuid.UUID
+	// Deleted is the item's tombstone. Deleted items are hidden from
+	// ItemList.Items but kept so they can be merged and restored.
+	Deleted PersistedBool
+	// Notes is free text about the item.
+	Notes PersistedText
+	// Due is when the item is due, or the zero time if it has no due date.
+	Due PersistedTime
+	// Priority is 0 by default, and higher for more urgent items.
+	Priority PersistedInt
+	// Tags is the set of the item's tags.
+	Tags ORSet[string]
 }
 
 func (i *PersistedItem) String() string {
-	return fmt.Sprintf("PersistedItem @%p title=%q state=%q order=%d/%d id=%q",
-		i, i.Title, i.State, i.Order.Num(), i.Order.Denom(), i.ID.String())
+	return fmt.Sprintf("PersistedItem @%p title=%q state=%q order=%q id=%q deleted=%t",
+		i, i.Title, i.State, i.Order.Value, i.ID.String(), i.Deleted.Value)
 }
 
-func newPersistedItem(title string, order *big.Rat) (*PersistedItem, error) {
-	if big.NewRat(0, 1).Cmp(order) != -1 || order.Cmp(big.NewRat(1, 1)) != -1 {
-		return nil, errors.New("Order out of range, need (0..1) (non-inclusive)")
+func newTodoOperation(timestamp Timestamp, title string, parent uuid.UUID, order orderstring.OrderString) (Operation, error) {
+	if err := checkOrder(order); err != nil {
+		return Operation{}, err
 	}
 	id, err := uuid.NewV7()
 	if err != nil {
-		return nil, err
+		return Operation{}, err
 	}
 
-	item := &PersistedItem{
-		Title: newPersistedString(title),
-		State: newPersistedString("unchecked"),
-		Order: order,
-		ID:    id,
+	op := Operation{
+		Kind:      OpNewTodo,
+		Timestamp: timestamp,
+		Item:      id,
+		Title:     title,
+		State:     StateTodo,
+		Order:     order,
+		Parent:    parent,
 	}
-	return item, nil
+	return op, nil
+}
+
+func (i *PersistedItem) clone() *PersistedItem {
+	c := *i
+	c.Order.Value = slices.Clone(i.Order.Value)
+	c.Tags = i.Tags.Clone()
+	return &c
+}
+
+// merge folds other, a replica of the same item, into i.
+func (i *PersistedItem) merge(other *PersistedItem) {
+	i.Title = i.Title.merge(other.Title)
+	i.State = i.State.merge(other.State)
+	i.Deleted = i.Deleted.merge(other.Deleted)
+	i.Notes = i.Notes.merge(other.Notes)
+	i.Due = i.Due.merge(other.Due)
+	i.Priority = i.Priority.merge(other.Priority)
+	i.Tags.Merge(&other.Tags)
+	// Copy the winning order so the two items share no memory.
+	merged := i.Order.merge(other.Order)
+	i.Order = newPersistedOrder(merged.Parent, merged.Value, merged.Timestamp)
+}
+
+// latest returns the most recent timestamp of any of the item's registers.
+func (i *PersistedItem) latest() Timestamp {
+	var latest Timestamp
+	timestamps := []Timestamp{
+		i.State.Timestamp, i.Deleted.Timestamp, i.Order.Timestamp,
+		i.Due.Timestamp, i.Priority.Timestamp,
+	}
+	timestamps = append(timestamps, i.Title.timestamps()...)
+	timestamps = append(timestamps, i.Notes.timestamps()...)
+	for _, t := range append(timestamps, i.Tags.Timestamps()...) {
+		if t.Compare(latest) > 0 {
+			latest = t
+		}
+	}
+	return latest
 }
 
 func (i *PersistedItem) Item() Item {
+	tags := i.Tags.Values()
+	slices.Sort(tags)
+	// Operations on an item can arrive before the one creating it.
+	state := i.State.Value
+	if state == "" {
+		state = StateTodo
+	}
 	return Item{
-		Title: i.Title.Value,
-		State: i.State.Value,
-		ID:    i.ID,
+		Title:    i.Title.String(),
+		State:    state,
+		ID:       i.ID,
+		Notes:    i.Notes.String(),
+		Due:      i.Due.Value,
+		Priority: i.Priority.Value,
+		Tags:     tags,
 	}
 }
 
 func New() *PersistedModel {
+	return NewWithClock(newSystemHybridClock())
+}
+
+// NewWithClock returns an empty model whose writes are stamped by clock.
+func NewWithClock(clock *HybridClock) *PersistedModel {
 	return &PersistedModel{
-		Items: make(map[uuid.UUID]*PersistedItem),
+		Items:    make(map[uuid.UUID]*PersistedItem),
+		Replicas: make(map[uuid.UUID]*PersistedReplica),
+		clock:    clock,
 	}
 }
 
-func (model *PersistedModel) GetItem(id uuid.UUID) *Item {
-	item := model.getItem(id)
+// SetClock replaces the clock that stamps the model's writes. The clock is
+// advanced past every timestamp already in the model.
+func (model *PersistedModel) SetClock(clock *HybridClock) {
+	model.clock = clock
+	for _, item := range model.Items {
+		clock.Observe(item.latest())
+	}
+	for _, replica := range model.Replicas {
+		clock.Observe(replica.Name.Timestamp)
+	}
+	for _, t := range model.Settings.timestamps() {
+		clock.Observe(t)
+	}
+}
 
-	return &Item{
-		ID:    id,
-		Title: item.Title.Value,
-		State: item.State.Value,
+func (model *PersistedModel) hybridClock() *HybridClock {
+	if model.clock == nil {
+		model.SetClock(newSystemHybridClock())
 	}
+	return model.clock
+}
+
+func (model *PersistedModel) now() Timestamp {
+	return model.hybridClock().Now()
+}
+
+func (model *PersistedModel) GetItem(id uuid.UUID) *Item {
+	item := model.getItem(id).Item()
+	return &item
 }
 
 func (model *PersistedModel) sorted() []*PersistedItem {
@@ -91,7 +291,7 @@ func (model *PersistedModel) sorted() []*PersistedItem {
 		items = append(items, v)
 	}
 	slices.SortFunc(items, func(i, j *PersistedItem) int {
-		c := i.Order.Cmp(j.Order)
+		c := bytes.Compare(i.Order.Value, j.Order.Value)
 		if c != 0 {
 			return c
 		}
@@ -106,51 +306,240 @@ func (model *PersistedModel) sorted() []*PersistedItem {
 func (model *PersistedModel) GetAllItems() []Item {
 	items := make([]Item, 0, len(model.Items))
 
-	for id, item := range model.Items {
-		items = append(items, Item{
-			ID:    id,
-			Title: item.Title.Value,
-			State: item.State.Value,
-		})
+	for _, item := range model.Items {
+		items = append(items, item.Item())
 	}
 
 	return items
 }
 
-func (model *PersistedModel) NewTodo(title string, order *big.Rat) (uuid.UUID, error) {
-	item, err := newPersistedItem(title, order)
-	if err != nil {
+func (model *PersistedModel) NewTodo(title string, order orderstring.OrderString) (uuid.UUID, error) {
+	return model.NewTodoUnder(title, uuid.UUID{}, order)
+}
+
+// NewTodoUnder adds an item as a child of parent, or at the top level if
+// parent is the zero UUID.
+func (model *PersistedModel) NewTodoUnder(title string, parent uuid.UUID, order orderstring.OrderString) (uuid.UUID, error) {
+	if err := model.checkParent(parent, uuid.UUID{}); err != nil {
 		return uuid.UUID{}, err
 	}
-
-	if model.Items == nil {
-		model.Items = make(map[uuid.UUID]*PersistedItem)
+	op, err := newTodoOperation(model.now(), title, parent, order)
+	if err != nil {
+		return uuid.UUID{}, err
 	}
-	model.Items[item.ID] = item
+	model.Apply(op)
 
-	return item.ID, nil
+	return op.Item, nil
 }
 
 func (model *PersistedModel) getItem(id uuid.UUID) *PersistedItem {
 	return model.Items[id]
 }
 
-func (model *PersistedModel) GetState(id uuid.UUID) string {
-	return model.getItem(id).State.Value
+func (model *PersistedModel) GetState(id uuid.UUID) State {
+	return model.getItem(id).Item().State
 }
 
+// SetState changes the item's state, returning an error wrapping
+// ErrInvalidTransition if the item cannot change to it from its current
+// state. Only local changes are checked; concurrent changes on other
+// replicas are merged last writer wins.
+func (model *PersistedModel) SetState(id uuid.UUID, state State) error {
+	item := model.getItem(id)
+	if item == nil {
+		return fmt.Errorf("no item with ID %s", id)
+	}
+	current := item.Item().State
+	if state == current {
+		return nil
+	}
+	if !current.CanTransition(state) {
+		return fmt.Errorf("cannot change state from %q to %q: %w", current, state, ErrInvalidTransition)
+	}
+	model.Apply(Operation{Kind: OpSetState, Timestamp: model.now(), Item: id, State: state})
+	return nil
+}
+
+// ToggleDone marks an open item done, and reopens a closed one.
 func (model *PersistedModel) ToggleDone(id uuid.UUID) {
 	item := model.getItem(id)
-	switch item.State.Value {
-	case "unchecked":
-		item.State = newPersistedString("checked")
-	case "checked":
-		item.State = newPersistedString("unchecked")
+	if item == nil {
+		return
+	}
+	state := StateDone
+	if item.Item().State.Closed() {
+		state = StateTodo
+	}
+	if err := model.SetState(id, state); err != nil {
+		panic(err)
+	}
+}
+
+// CycleState moves the item to the next state of the todo, in progress,
+// done cycle. See State.Next.
+func (model *PersistedModel) CycleState(id uuid.UUID) {
+	item := model.getItem(id)
+	if item == nil {
+		return
+	}
+	if err := model.SetState(id, item.Item().State.Next()); err != nil {
+		panic(err)
 	}
 }
 
+// Move gives the item a new position among its siblings.
+func (model *PersistedModel) Move(id uuid.UUID, order orderstring.OrderString) error {
+	if err := checkOrder(order); err != nil {
+		return err
+	}
+	var parent uuid.UUID
+	if item := model.getItem(id); item != nil {
+		parent = item.Order.Parent
+	}
+	model.Apply(Operation{Kind: OpMove, Timestamp: model.now(), Item: id, Order: order, Parent: parent})
+	return nil
+}
+
+// MoveUnder makes the item a child of parent, or a top level item if
+// parent is the zero UUID, at the given position among its new siblings.
+// An item cannot be moved under itself or its descendants.
+func (model *PersistedModel) MoveUnder(id uuid.UUID, parent uuid.UUID, order orderstring.OrderString) error {
+	if err := checkOrder(order); err != nil {
+		return err
+	}
+	if err := model.checkParent(parent, id); err != nil {
+		return err
+	}
+	model.Apply(Operation{Kind: OpMove, Timestamp: model.now(), Item: id, Order: order, Parent: parent})
+	return nil
+}
+
+// SetTitle changes the item's title. With TextMerge on, only the
+// characters that differ are replaced, so edits to other parts of the title
+// made concurrently on other replicas are kept. Otherwise the whole title
+// is replaced, and the last replica to change it wins.
 func (model *PersistedModel) SetTitle(id uuid.UUID, title string) {
-	model.getItem(id).Title = newPersistedString(title)
+	item := model.getItem(id)
+	if item == nil || !model.TextMerge() {
+		if item == nil || item.Title.String() != title {
+			model.Apply(Operation{Kind: OpSetTitle, Timestamp: model.now(), Item: id, Title: title})
+		}
+		return
+	}
+	if edit, ok := item.Title.diff(title); ok {
+		model.Apply(editOperation(OpEditTitle, model.now(), id, edit))
+	}
+}
+
+// SetNotes changes the item's notes, like SetTitle.
+func (model *PersistedModel) SetNotes(id uuid.UUID, notes string) {
+	item := model.getItem(id)
+	if item == nil || !model.TextMerge() {
+		if item == nil || item.Notes.String() != notes {
+			model.Apply(Operation{Kind: OpSetNotes, Timestamp: model.now(), Item: id, Notes: notes})
+		}
+		return
+	}
+	if edit, ok := item.Notes.diff(notes); ok {
+		model.Apply(editOperation(OpEditNotes, model.now(), id, edit))
+	}
+}
+
+// SetDue sets the item's due date, or clears it if due is the zero time.
+func (model *PersistedModel) SetDue(id uuid.UUID, due time.Time) {
+	model.Apply(Operation{Kind: OpSetDue, Timestamp: model.now(), Item: id, Due: due})
+}
+
+func (model *PersistedModel) SetPriority(id uuid.UUID, priority int) {
+	model.Apply(Operation{Kind: OpSetPriority, Timestamp: model.now(), Item: id, Priority: priority})
+}
+
+func (model *PersistedModel) AddTag(id uuid.UUID, tag string) {
+	model.Apply(Operation{Kind: OpAddTag, Timestamp: model.now(), Item: id, Tag: tag})
+}
+
+// RemoveTag removes the tag from the item. Additions of the tag on other
+// replicas that have not been merged yet are not affected.
+func (model *PersistedModel) RemoveTag(id uuid.UUID, tag string) {
+	item := model.getItem(id)
+	if item == nil {
+		return
+	}
+	observed := item.Tags.Observed(tag)
+	if len(observed) == 0 {
+		return
+	}
+	model.Apply(Operation{Kind: OpRemoveTag, Timestamp: model.now(), Item: id, Tag: tag, Observed: observed})
+}
+
+// Merge folds the state of another replica into model. Merging is
+// commutative, associative and idempotent, so replicas that have merged the
+// same set of states hold identical models regardless of merge order.
+// The other model is not modified and shares no memory with model afterward.
+// The model's clock is advanced past every merged timestamp, so later local
+// writes win over everything merged.
+func (model *PersistedModel) Merge(other *PersistedModel) {
+	if model.Items == nil {
+		model.Items = make(map[uuid.UUID]*PersistedItem)
+	}
+	clock := model.hybridClock()
+	// arrived holds the items that logged operations may not have been
+	// applied to yet: those new to model, and those with operations new to
+	// its log. Either side may hold operations on items it has not seen.
+	arrived := make(map[uuid.UUID]bool)
+	for id, theirs := range other.Items {
+		clock.Observe(theirs.latest())
+		if ours, ok := model.Items[id]; ok {
+			ours.merge(theirs)
+		} else {
+			model.Items[id] = theirs.clone()
+			arrived[id] = true
+		}
+	}
+	if model.Replicas == nil {
+		model.Replicas = make(map[uuid.UUID]*PersistedReplica)
+	}
+	for id, theirs := range other.Replicas {
+		clock.Observe(theirs.Name.Timestamp)
+		if ours, ok := model.Replicas[id]; ok {
+			ours.merge(theirs)
+		} else {
+			model.Replicas[id] = theirs.clone()
+		}
+	}
+	for _, t := range other.Settings.timestamps() {
+		clock.Observe(t)
+	}
+	model.mergeSettings(other.Settings)
+	for _, op := range other.Log {
+		if _, found := slices.BinarySearchFunc(model.Log, op, compareOperations); !found {
+			clock.Observe(op.Timestamp)
+			arrived[op.Item] = true
+		}
+	}
+	model.mergeLog(other.Log)
+	for id := range arrived {
+		if item, ok := model.Items[id]; ok {
+			model.catchUp(item)
+		}
+	}
+}
+
+// Delete marks the item as deleted by setting its tombstone.
+func (model *PersistedModel) Delete(id uuid.UUID) {
+	model.Apply(Operation{Kind: OpDelete, Timestamp: model.now(), Item: id})
+}
+
+// Restore clears the item's tombstone, undoing a Delete.
+func (model *PersistedModel) Restore(id uuid.UUID) {
+	model.Apply(Operation{Kind: OpRestore, Timestamp: model.now(), Item: id})
+}
+
+// IsDeleted reports whether the item is in the trash. An item the model
+// does not have is not deleted.
+func (model *PersistedModel) IsDeleted(id uuid.UUID) bool {
+	item := model.getItem(id)
+	return item != nil && item.Deleted.Value
 }
 
 func (model *PersistedModel) DebugString() string {
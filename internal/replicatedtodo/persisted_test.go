@@ -1,7 +1,15 @@
 package replicatedtodo
 
 import (
+	"fmt"
+	"math/rand/v2"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
 )
 
 func TestNew(t *testing.T) {
@@ -13,3 +21,203 @@ func TestNew(t *testing.T) {
 		t.Errorf("New() = %v, want non-nil Items", got)
 	}
 }
+
+var modelOptions = cmp.Options{
+	cmpopts.IgnoreUnexported(PersistedModel{}),
+}
+
+// randomReplicas returns n models drawing items from a shared pool of IDs,
+// with timestamps and values chosen from small sets so that conflicts and
+// ties are common.
+func randomReplicas(r *rand.Rand, n int) []*PersistedModel {
+	const poolSize = 6
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	randomString := func() PersistedString {
+		return PersistedString{
+			Timestamp: Timestamp{Wall: base.Add(time.Duration(r.IntN(3)) * time.Second)},
+			Value:     fmt.Sprintf("v%d", r.IntN(3)),
+		}
+	}
+
+	randomTimestamp := func() Timestamp {
+		return Timestamp{Wall: base.Add(time.Duration(r.IntN(3)) * time.Second)}
+	}
+	// randomText returns a random base value with random edits inserting
+	// text at the start. Each edit's text is derived from its timestamp
+	// so that equal timestamps, which replicas never issue, hold equal
+	// edits.
+	randomText := func() PersistedText {
+		s := randomString()
+		text := newPersistedText(s.Value, s.Timestamp)
+		for range r.IntN(3) {
+			at := randomTimestamp()
+			text = text.edit(TextEdit{Timestamp: at, Insert: at.Wall.Format("05")})
+		}
+		return text
+	}
+	randomState := func() PersistedState {
+		return newPersistedState(States[r.IntN(len(States))], randomTimestamp())
+	}
+	randomTags := func() ORSet[string] {
+		var tags ORSet[string]
+		for _, tag := range []string{"home", "work"} {
+			for range r.IntN(3) {
+				tags.Add(tag, randomTimestamp())
+			}
+			if r.IntN(2) == 0 {
+				tags.RemoveObserved(tag, []Timestamp{randomTimestamp()}, randomTimestamp())
+			}
+		}
+		return tags
+	}
+
+	var ids []uuid.UUID
+	for range poolSize {
+		ids = append(ids, uuid.New())
+	}
+
+	var models []*PersistedModel
+	for range n {
+		model := New()
+		for _, id := range ids {
+			if r.IntN(2) == 0 {
+				continue
+			}
+			model.Items[id] = &PersistedItem{
+				Title: randomText(),
+				State: randomState(),
+				Order: PersistedOrder{
+					Timestamp: Timestamp{Wall: base.Add(time.Duration(r.IntN(3)) * time.Second)},
+					Value:     orderstring.OrderString{byte('b' + r.IntN(poolSize))},
+				},
+				ID: id,
+				Deleted: PersistedBool{
+					Timestamp: Timestamp{Wall: base.Add(time.Duration(r.IntN(3)) * time.Second)},
+					Value:     r.IntN(2) == 0,
+				},
+				Notes:    randomText(),
+				Priority: PersistedInt{Timestamp: randomTimestamp(), Value: r.IntN(3)},
+				Tags:     randomTags(),
+			}
+		}
+		for _, id := range ids[:2] {
+			if r.IntN(2) == 0 {
+				continue
+			}
+			model.Replicas[id] = &PersistedReplica{
+				Name:      randomString(),
+				FirstSeen: base.Add(time.Duration(r.IntN(3)) * time.Second),
+				LastSync:  base.Add(time.Duration(r.IntN(3)) * time.Second),
+			}
+		}
+		models = append(models, model)
+	}
+	return models
+}
+
+func merged(models ...*PersistedModel) *PersistedModel {
+	result := New()
+	for _, m := range models {
+		result.Merge(m)
+	}
+	return result
+}
+
+func TestMergeCommutative(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 100 {
+		m := randomReplicas(r, 2)
+		ab := merged(m[0], m[1])
+		ba := merged(m[1], m[0])
+		if diff := cmp.Diff(ab, ba, modelOptions); diff != "" {
+			t.Fatalf("a.Merge(b) != b.Merge(a) (-ab, +ba):\n%s", diff)
+		}
+	}
+}
+
+func TestMergeAssociative(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	for range 100 {
+		m := randomReplicas(r, 3)
+		left := merged(merged(m[0], m[1]), m[2])
+		right := merged(m[0], merged(m[1], m[2]))
+		if diff := cmp.Diff(left, right, modelOptions); diff != "" {
+			t.Fatalf("(a+b)+c != a+(b+c) (-left, +right):\n%s", diff)
+		}
+	}
+}
+
+func TestMergeIdempotent(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 6))
+	for range 100 {
+		m := randomReplicas(r, 2)
+		once := merged(m[0], m[1])
+		twice := merged(once, m[1])
+		twice.Merge(once)
+		if diff := cmp.Diff(once, twice, modelOptions); diff != "" {
+			t.Fatalf("merge is not idempotent (-once, +twice):\n%s", diff)
+		}
+	}
+}
+
+func TestMergeLastWriterWins(t *testing.T) {
+	id := uuid.New()
+	early := Timestamp{Wall: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	late := Timestamp{Wall: early.Wall.Add(time.Second)}
+	a := New()
+	a.Items[id] = &PersistedItem{
+		Title: newPersistedText("new title", late),
+		State: newPersistedState(StateTodo, early),
+		Order: PersistedOrder{Value: orderstring.OrderString("n")},
+		ID:    id,
+	}
+	b := New()
+	b.Items[id] = &PersistedItem{
+		Title: newPersistedText("old title", early),
+		State: newPersistedState(StateDone, late),
+		Order: PersistedOrder{Value: orderstring.OrderString("n")},
+		ID:    id,
+	}
+
+	a.Merge(b)
+
+	want := Item{Title: "new title", State: StateDone, ID: id}
+	if diff := cmp.Diff(&want, a.GetItem(id)); diff != "" {
+		t.Errorf("Merge() mismatch (-want, +got):\n%s", diff)
+	}
+	if b.Items[id].Title.String() != "old title" {
+		t.Errorf("Merge() modified its argument")
+	}
+}
+
+func TestMergeDeleteSurvives(t *testing.T) {
+	a := New()
+	id, err := a.NewTodo("title", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	b := New()
+	b.Merge(a)
+
+	a.Delete(id)
+	a.Merge(b)
+	if !a.IsDeleted(id) {
+		t.Errorf("merging a replica that still has the item undid the delete")
+	}
+	b.Merge(a)
+	if !b.IsDeleted(id) {
+		t.Errorf("merging a replica with a tombstone did not delete the item")
+	}
+
+	b.Restore(id)
+	a.Merge(b)
+	if a.IsDeleted(id) {
+		t.Errorf("merging a later restore did not undelete the item")
+	}
+}
+
+func TestIsDeletedUnknownItem(t *testing.T) {
+	if New().IsDeleted(uuid.New()) {
+		t.Errorf("IsDeleted() of an unknown item = true, want false")
+	}
+}
@@ -0,0 +1,179 @@
+package replicatedtodo
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PersistedReplica records what is known about one replica of the model.
+type PersistedReplica struct {
+	// Name is a human readable name for the replica, such as a host name.
+	Name PersistedString
+	// FirstSeen is when the replica first wrote to the model.
+	FirstSeen time.Time
+	// LastSync is when the replica last merged state from another replica.
+	LastSync time.Time
+	// Seen is the version vector of the writes the replica held when it
+	// last compacted. Only the replica itself sets it, so it only grows.
+	Seen VersionVector `json:",omitempty"`
+}
+
+func (r *PersistedReplica) clone() *PersistedReplica {
+	c := *r
+	c.Seen = maps.Clone(r.Seen)
+	return &c
+}
+
+// merge folds other, a copy of the same replica's record, into r.
+func (r *PersistedReplica) merge(other *PersistedReplica) {
+	r.Name = r.Name.merge(other.Name)
+	if r.FirstSeen.IsZero() || (!other.FirstSeen.IsZero() && other.FirstSeen.Before(r.FirstSeen)) {
+		r.FirstSeen = other.FirstSeen
+	}
+	if other.LastSync.After(r.LastSync) {
+		r.LastSync = other.LastSync
+	}
+	if len(other.Seen) > 0 {
+		if r.Seen == nil {
+			r.Seen = make(VersionVector)
+		}
+		r.Seen.Merge(other.Seen)
+	}
+}
+
+// SetReplica makes the model write as the replica with the given ID, and
+// records the replica in the model's replica table under the given name.
+func (model *PersistedModel) SetReplica(id uuid.UUID, name string) {
+	model.setReplica(NewHybridClock(systemClock{}, id), name)
+}
+
+func (model *PersistedModel) setReplica(clock *HybridClock, name string) {
+	model.SetClock(clock)
+	if model.Replicas == nil {
+		model.Replicas = make(map[uuid.UUID]*PersistedReplica)
+	}
+	now := model.now()
+	replica, ok := model.Replicas[clock.Replica()]
+	if !ok {
+		replica = &PersistedReplica{FirstSeen: now.Wall}
+		model.Replicas[clock.Replica()] = replica
+	}
+	if replica.Name.Value != name {
+		replica.Name = newPersistedString(name, now)
+	}
+}
+
+// Replica returns the ID of the replica the model writes as.
+func (model *PersistedModel) Replica() uuid.UUID {
+	return model.hybridClock().Replica()
+}
+
+// ReplicaName returns the name recorded for a replica, or a shortened form
+// of its ID if the replica is not in the table.
+func (model *PersistedModel) ReplicaName(id uuid.UUID) string {
+	if replica, ok := model.Replicas[id]; ok && replica.Name.Value != "" {
+		return replica.Name.Value
+	}
+	return id.String()[:8]
+}
+
+// RecordSync notes that the local replica has just merged state from
+// another replica.
+func (model *PersistedModel) RecordSync() {
+	now := model.now()
+	if replica, ok := model.Replicas[now.Replica]; ok {
+		replica.LastSync = now.Wall
+	}
+}
+
+// LastEdit returns the replica that last changed the item and when.
+func (model *PersistedModel) LastEdit(id uuid.UUID) (uuid.UUID, time.Time) {
+	latest := model.getItem(id).latest()
+	return latest.Replica, latest.Wall
+}
+
+// stable returns the version vector every replica in the replica table has
+// reached, going by what each last recorded as Seen. A replica that has
+// recorded nothing holds everything back.
+func (model *PersistedModel) stable() VersionVector {
+	var stable VersionVector
+	for _, replica := range model.Replicas {
+		if stable == nil {
+			stable = maps.Clone(replica.Seen)
+			continue
+		}
+		for id, t := range stable {
+			if seen, ok := replica.Seen[id]; !ok {
+				delete(stable, id)
+			} else if seen.Compare(t) < 0 {
+				stable[id] = seen
+			}
+		}
+	}
+	return stable
+}
+
+// settled returns a timestamp such that every replica in the replica table
+// has every write, by any of them, stamped at or before it, or the zero
+// timestamp if there is none.
+//
+// A replica's writes that some replica has not seen are stamped after the
+// replica's entry in stable. If every replica has all the writes the
+// replica had made when it last recorded Seen, its later writes are also
+// stamped after everything in its Seen, which its clock had observed.
+func (model *PersistedModel) settled() Timestamp {
+	stable := model.stable()
+	var settled Timestamp
+	for id, replica := range model.Replicas {
+		bound, ok := stable[id]
+		if !ok {
+			return Timestamp{}
+		}
+		if own, ok := replica.Seen[id]; ok && own.Compare(bound) <= 0 {
+			for _, t := range replica.Seen {
+				if t.Compare(bound) > 0 {
+					bound = t
+				}
+			}
+		}
+		if settled.IsZero() || bound.Compare(settled) < 0 {
+			settled = bound
+		}
+	}
+	return settled
+}
+
+// Compact records in the replica table what the local replica has seen,
+// and drops the history every replica in the table has seen: the logged
+// operations on items the model holds, the tombstones of tags, and the
+// edits to titles and notes, which are folded into their base values. A replica joins the table when it first writes
+// as itself, so one that has not yet merged that history holds back its
+// compaction.
+func (model *PersistedModel) Compact() {
+	replica, ok := model.Replicas[model.Replica()]
+	if !ok {
+		return
+	}
+	if replica.Seen == nil {
+		replica.Seen = make(VersionVector)
+	}
+	replica.Seen.Merge(model.VersionVector())
+	stable := model.stable()
+	settled := model.settled()
+	for _, item := range model.Items {
+		item.Tags.Compact(stable)
+		if !settled.IsZero() {
+			item.Title = item.Title.compact(settled)
+			item.Notes = item.Notes.compact(settled)
+		}
+	}
+	// Operations on items the model does not hold yet are kept until the
+	// items arrive and catch up on them.
+	model.Log = slices.DeleteFunc(model.Log, func(op Operation) bool {
+		_, held := model.Items[op.Item]
+		return held && stable.Includes(op.Timestamp)
+	})
+}
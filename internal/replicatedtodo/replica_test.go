@@ -0,0 +1,134 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/orderstring"
+)
+
+func TestSetReplicaStampsWrites(t *testing.T) {
+	replica := uuid.New()
+	model := New()
+	model.SetReplica(replica, "laptop")
+
+	id, err := model.NewTodo("title", orderstring.OrderString("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	model.SetTitle(id, "new title")
+
+	item := model.Items[id]
+	for _, ts := range []Timestamp{item.Title.Timestamp, item.State.Timestamp} {
+		if ts.Replica != replica {
+			t.Errorf("write stamped with replica %v, want %v", ts.Replica, replica)
+		}
+	}
+	if got := model.ReplicaName(replica); got != "laptop" {
+		t.Errorf("ReplicaName() = %q, want %q", got, "laptop")
+	}
+	if model.Replicas[replica].FirstSeen.IsZero() {
+		t.Errorf("FirstSeen not recorded")
+	}
+}
+
+func TestLastEditAcrossReplicas(t *testing.T) {
+	laptop := New()
+	laptop.SetReplica(uuid.New(), "laptop")
+	desktop := New()
+	desktop.SetReplica(uuid.New(), "desktop")
+
+	list := laptop.Model()
+	item, err := list.NewTodo("title", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+
+	desktop.Merge(laptop)
+	desktop.SetTitle(item.ID, "edited on the desktop")
+	other := desktop.Model()
+	list.Merge(&other)
+
+	name, at := list.LastEdit(item.ID)
+	if name != "desktop" {
+		t.Errorf("LastEdit() name = %q, want %q", name, "desktop")
+	}
+	if time.Since(at) > time.Minute {
+		t.Errorf("LastEdit() time = %v, want about now", at)
+	}
+	if list.replicated.Replicas[laptop.Replica()].LastSync.IsZero() {
+		t.Errorf("ItemList.Merge() did not record the sync")
+	}
+}
+
+func TestMergeStoredRecordsNoSync(t *testing.T) {
+	laptop := New()
+	laptop.SetReplica(uuid.New(), "laptop")
+	desktop := New()
+	desktop.SetReplica(uuid.New(), "desktop")
+	if _, err := desktop.NewTodo("title", orderstring.OrderString("n")); err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+
+	list := laptop.Model()
+	stored := desktop.Model()
+	list.MergeStored(&stored)
+	if got := len(list.Items()); got != 1 {
+		t.Errorf("MergeStored() left %d items, want 1", got)
+	}
+	if at := list.replicated.Replicas[laptop.Replica()].LastSync; !at.IsZero() {
+		t.Errorf("MergeStored() recorded a sync at %v", at)
+	}
+}
+
+func TestCompactKeepsSizeFlat(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.replicated.setReplica(NewHybridClock(physical, uuid.New()), "laptop")
+	item, err := laptop.NewTodo("todo", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	desktop := ItemList{}
+	desktop.replicated.setReplica(NewHybridClock(physical, uuid.New()), "desktop")
+
+	// size edits both lists, syncs them fully, compacts them and returns
+	// the size of the laptop's list.
+	size := func(round int) int {
+		t.Helper()
+		physical.now = physical.now.Add(time.Second)
+		title := []string{"title a", "title b"}[round%2]
+		laptop.SetTitle(item.ID, title)
+		laptop.AddTag(item.ID, "home")
+		laptop.RemoveTag(item.ID, "home")
+		desktop.Merge(&laptop)
+		desktop.SetNotes(item.ID, title)
+		for range 2 {
+			laptop.Merge(&desktop)
+			laptop.Compact()
+			desktop.Merge(&laptop)
+			desktop.Compact()
+		}
+		data, err := json.Marshal(&laptop)
+		if err != nil {
+			t.Fatalf("Marshal() error: %s", err)
+		}
+		return len(data)
+	}
+
+	for round := range 5 {
+		size(round)
+	}
+	before := size(5)
+	for round := 6; round < 50; round++ {
+		size(round)
+	}
+	if after := size(50); after > before {
+		t.Errorf("size after 50 rounds of edits = %d, want at most %d, the size after 5", after, before)
+	}
+	if got := len(laptop.Operations()); got != 0 {
+		t.Errorf("operations after compacting = %d, want 0", got)
+	}
+}
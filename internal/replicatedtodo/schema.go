@@ -0,0 +1,88 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the format ItemList.MarshalJSON writes.
+// It is stored in the document's Version field. Documents without one are
+// version 0.
+const SchemaVersion = 3
+
+// ErrNewerSchema is returned when reading a document written by a newer
+// version of sift, which this version may not understand.
+var ErrNewerSchema = errors.New("written by a newer version of sift")
+
+// document is a serialized ItemList, split into its top level fields so
+// migrations can rewrite them.
+type document map[string]json.RawMessage
+
+// migration upgrades a document from version from to version from+1.
+type migration struct {
+	from        int
+	description string
+	migrate     func(doc document) error
+}
+
+// migrations upgrades documents one version at a time. There is one entry
+// for every version before SchemaVersion, in order; changing the format
+// means incrementing SchemaVersion and appending a migration from the old
+// version.
+var migrations = []migration{
+	{
+		from:        0,
+		description: "rewrite the encodings of the versions before the Version field",
+		migrate:     migrateV0,
+	},
+	{
+		from:        1,
+		description: "write the timestamps of text edits once each",
+		migrate:     migrateV1,
+	},
+	{
+		from:        2,
+		description: "write order keys with the Base62 alphabet",
+		migrate:     migrateV2,
+	},
+}
+
+// version returns the document's schema version.
+func (doc document) version() (int, error) {
+	raw, ok := doc["Version"]
+	if !ok {
+		return 0, nil
+	}
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, fmt.Errorf("invalid schema version %s: %w", raw, err)
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("invalid schema version %d", version)
+	}
+	return version, nil
+}
+
+// upgrade migrates doc to version target with the given migrations.
+func (doc document) upgrade(migrations []migration, target int) error {
+	version, err := doc.version()
+	if err != nil {
+		return err
+	}
+	if version > target {
+		return fmt.Errorf("data has schema version %d, but this version of sift reads up to version %d: %w",
+			version, target, ErrNewerSchema)
+	}
+	for ; version < target; version++ {
+		if version >= len(migrations) || migrations[version].from != version {
+			return fmt.Errorf("no migration from schema version %d", version)
+		}
+		m := migrations[version]
+		if err := m.migrate(doc); err != nil {
+			return fmt.Errorf("migrating from schema version %d (%s): %w", version, m.description, err)
+		}
+	}
+	doc["Version"] = json.RawMessage(fmt.Sprint(target))
+	return nil
+}
@@ -0,0 +1,219 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// summary describes what a user sees of a list, for comparing lists loaded
+// from different schema versions.
+func summary(list *ItemList) string {
+	var b strings.Builder
+	for _, item := range list.Tree() {
+		fmt.Fprintf(&b, "%s[%s] %s", strings.Repeat("  ", item.Depth), item.State, item.Title)
+		if item.Notes != "" {
+			fmt.Fprintf(&b, " notes=%q", item.Notes)
+		}
+		if !item.Due.IsZero() {
+			fmt.Fprintf(&b, " due=%s", item.Due.Format("2006-01-02"))
+		}
+		if item.Priority != 0 {
+			fmt.Fprintf(&b, " priority=%d", item.Priority)
+		}
+		if len(item.Tags) > 0 {
+			fmt.Fprintf(&b, " tags=%s", strings.Join(item.Tags, ","))
+		}
+		b.WriteString("\n")
+	}
+	for _, item := range list.DeletedItems() {
+		fmt.Fprintf(&b, "deleted: [%s] %s\n", item.State, item.Title)
+	}
+	return b.String()
+}
+
+// TestSchemaFixtures loads a list saved by every version of the format,
+// and checks what it holds against a golden summary. Run with -update to
+// rewrite the summaries.
+func TestSchemaFixtures(t *testing.T) {
+	paths, err := filepath.Glob("testdata/schema/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixtures")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var list ItemList
+			if err := yaml.Unmarshal(data, &list); err != nil {
+				t.Fatalf("Unmarshal() error: %s", err)
+			}
+			got := summary(&list)
+
+			golden := strings.TrimSuffix(path, ".yaml") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), got); diff != "" {
+				t.Errorf("summary mismatch (-want, +got):\n%s", diff)
+			}
+
+			// Saving writes the current version, which loads the same.
+			saved, err := yaml.Marshal(&list)
+			if err != nil {
+				t.Fatalf("Marshal() error: %s", err)
+			}
+			var doc struct{ Version int }
+			if err := yaml.Unmarshal(saved, &doc); err != nil || doc.Version != SchemaVersion {
+				t.Errorf("saved version = %d (error %v), want %d", doc.Version, err, SchemaVersion)
+			}
+			var reloaded ItemList
+			if err := yaml.Unmarshal(saved, &reloaded); err != nil {
+				t.Fatalf("Unmarshal() of saved list error: %s", err)
+			}
+			if diff := cmp.Diff(got, summary(&reloaded)); diff != "" {
+				t.Errorf("summary after saving mismatch (-before, +after):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSchemaRefusesNewerVersion(t *testing.T) {
+	data := fmt.Sprintf(`{"Version": %d, "Items": {}}`, SchemaVersion+1)
+	var list ItemList
+	if err := json.Unmarshal([]byte(data), &list); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("json.Unmarshal() error = %v, want ErrNewerSchema", err)
+	}
+	// The YAML package does not wrap errors, but keeps their text.
+	err := yaml.Unmarshal([]byte(data), &list)
+	if err == nil || !strings.Contains(err.Error(), ErrNewerSchema.Error()) {
+		t.Errorf("yaml.Unmarshal() error = %v, want it to mention %q", err, ErrNewerSchema)
+	}
+}
+
+func TestSchemaInvalidVersion(t *testing.T) {
+	for _, data := range []string{"Version: -1", "Version: one"} {
+		var list ItemList
+		if err := yaml.Unmarshal([]byte(data), &list); err == nil {
+			t.Errorf("Unmarshal(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestSchemaUpgradeStepByStep(t *testing.T) {
+	var steps []int
+	step := func(from int) migration {
+		return migration{from: from, description: fmt.Sprint("step ", from), migrate: func(doc document) error {
+			steps = append(steps, from)
+			doc["Steps"] = json.RawMessage(fmt.Sprint(len(steps)))
+			return nil
+		}}
+	}
+	registry := []migration{step(0), step(1), step(2)}
+
+	doc := document{"Version": json.RawMessage("1")}
+	if err := doc.upgrade(registry, 3); err != nil {
+		t.Fatalf("upgrade() error: %s", err)
+	}
+	if diff := cmp.Diff([]int{1, 2}, steps); diff != "" {
+		t.Errorf("migrations run mismatch (-want, +got):\n%s", diff)
+	}
+	if got := string(doc["Version"]); got != "3" {
+		t.Errorf("version after upgrade = %s, want 3", got)
+	}
+
+	if err := (document{}).upgrade(registry[:1], 3); err == nil {
+		t.Errorf("upgrade() with a missing migration succeeded")
+	}
+	failing := []migration{{from: 0, description: "fails", migrate: func(document) error {
+		return os.ErrInvalid
+	}}}
+	if err := (document{}).upgrade(failing, 1); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("upgrade() with a failing migration error = %v, want it wrapped", err)
+	}
+}
+
+func TestMigrationsRegistry(t *testing.T) {
+	if len(migrations) != SchemaVersion {
+		t.Fatalf("%d migrations for schema version %d", len(migrations), SchemaVersion)
+	}
+	for i, m := range migrations {
+		if m.from != i {
+			t.Errorf("migrations[%d] is from version %d", i, m.from)
+		}
+	}
+}
+
+// TestMigrateV0 checks that the encodings of version 0 are rewritten by
+// the migration, and refused in a version 1 document.
+func TestMigrateV0(t *testing.T) {
+	const item = "01900000-0000-7000-8000-000000000001"
+	v0 := `{
+	"Items": {"` + item + `": {
+		"ID": "` + item + `",
+		"Title": {"Timestamp": "2024-06-01T12:00:00Z", "Value": "Buy milk"},
+		"State": {"Timestamp": "2024-06-01T12:00:00Z", "Value": "checked"},
+		"Order": "1/2",
+		"Tags": {
+			"home": [{"Added": {"Wall": "2024-06-01T12:00:01Z"}}],
+			"work": [{"Added": {"Wall": "2024-06-01T12:00:02Z"}, "Removed": {"Wall": "2024-06-01T12:00:03Z"}}]
+		}
+	}},
+	"Log": [{"Kind": "new", "Item": "` + item + `", "Timestamp": {"Wall": "2024-06-01T12:00:00Z"},
+		"Title": "Buy milk", "State": "unchecked", "Order": "1/2"}]
+}`
+	var list ItemList
+	if err := json.Unmarshal([]byte(v0), &list); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if got, want := summary(&list), "[done] Buy milk tags=home\n"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	got := list.replicated.Items[uuid.MustParse(item)]
+	if want := (Timestamp{Wall: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}); got.Title.Timestamp != want {
+		t.Errorf("title timestamp = %v, want %v", got.Title.Timestamp, want)
+	}
+	if len(got.Tags.dots["work"]) != 1 {
+		t.Errorf("tombstone for work not kept: %v", got.Tags.dots["work"])
+	}
+	op := list.Operations()[0]
+	if op.State != StateTodo || string(op.Order) != "n" {
+		t.Errorf("logged state and order = %q, %q, want %q, %q", op.State, op.Order, StateTodo, "n")
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(v0), &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc["Version"] = 1
+	v1, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(v1, &list); err == nil {
+		t.Errorf("Unmarshal() of version 0 encodings marked version 1 succeeded, want an error")
+	}
+}
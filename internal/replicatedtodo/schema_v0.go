@@ -0,0 +1,189 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// migrateV0 rewrites the encodings that the versions of sift before the
+// Version field wrote, and that version 1 does not read:
+//
+//   - timestamps stored as a bare time, from before hybrid logical clocks;
+//   - orders stored as a bare value, from before items could be moved;
+//   - rational orders, from before order strings;
+//   - the states "unchecked", "checked" and "completed", from before
+//     typed states;
+//   - tag sets stored as an object mapping tags to their dots.
+//
+// Besides data files, deltas and journal records from peers that predate
+// the Version field are version 0 documents, and are migrated the same
+// way.
+func migrateV0(doc document) error {
+	if err := rewriteField(doc, "Items", func(items map[string]any) error {
+		for id, item := range items {
+			item, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := migrateItemV0(item); err != nil {
+				return fmt.Errorf("item %s: %w", id, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := rewriteField(doc, "Log", func(log []any) error {
+		for i, op := range log {
+			op, ok := op.(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := migrateOperationV0(op); err != nil {
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return rewriteField(doc, "Replicas", func(replicas map[string]any) error {
+		for _, replica := range replicas {
+			if replica, ok := replica.(map[string]any); ok {
+				if name, ok := replica["Name"].(map[string]any); ok {
+					migrateTimestampV0(name, "Timestamp")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// rewriteField decodes the document's field as a T, passes it to rewrite,
+// and stores the result back. Numbers are kept as written. A field that is
+// missing, null or not a T is left alone, for the decoder to judge.
+func rewriteField[T any](doc document, field string, rewrite func(T) error) error {
+	raw, ok := doc[field]
+	if !ok {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return nil
+	}
+	if err := rewrite(typed); err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	data, err := json.Marshal(typed)
+	if err != nil {
+		return err
+	}
+	doc[field] = data
+	return nil
+}
+
+func migrateItemV0(item map[string]any) error {
+	if order, ok := item["Order"].(string); ok {
+		item["Order"] = map[string]any{"Value": order}
+	}
+	for _, name := range []string{"Title", "State", "Order", "Deleted", "Notes", "Due", "Priority"} {
+		if register, ok := item[name].(map[string]any); ok {
+			migrateTimestampV0(register, "Timestamp")
+		}
+	}
+	if order, ok := item["Order"].(map[string]any); ok {
+		value, err := migrateOrderV0(order["Value"])
+		if err != nil {
+			return err
+		}
+		order["Value"] = value
+	}
+	if state, ok := item["State"].(map[string]any); ok {
+		state["Value"] = migrateStateV0(state["Value"])
+	}
+	if tags, ok := item["Tags"].(map[string]any); ok {
+		item["Tags"] = migrateTagsV0(tags)
+	}
+	return nil
+}
+
+func migrateOperationV0(op map[string]any) error {
+	migrateTimestampV0(op, "Timestamp")
+	if observed, ok := op["Observed"].([]any); ok {
+		for i, t := range observed {
+			if wall, ok := t.(string); ok {
+				observed[i] = map[string]any{"Wall": wall}
+			}
+		}
+	}
+	if order, ok := op["Order"]; ok {
+		order, err := migrateOrderV0(order)
+		if err != nil {
+			return err
+		}
+		op["Order"] = order
+	}
+	if state, ok := op["State"]; ok {
+		op["State"] = migrateStateV0(state)
+	}
+	return nil
+}
+
+// migrateTimestampV0 rewrites a timestamp stored under key in m as a bare
+// time to a Timestamp with that wall time.
+func migrateTimestampV0(m map[string]any, key string) {
+	if wall, ok := m[key].(string); ok {
+		m[key] = map[string]any{"Wall": wall}
+	}
+}
+
+// migrateOrderV0 converts a rational order to an order string with
+// ratToOrderString, and returns any other value unchanged.
+func migrateOrderV0(value any) (any, error) {
+	text, ok := value.(string)
+	if !ok || !strings.Contains(text, "/") {
+		return value, nil
+	}
+	var rat big.Rat
+	if err := rat.UnmarshalText([]byte(text)); err != nil {
+		return nil, err
+	}
+	order, err := ratToOrderString(&rat)
+	if err != nil {
+		return nil, err
+	}
+	return string(order), nil
+}
+
+// migrateStateV0 returns the State a state of version 0 was renamed to.
+func migrateStateV0(value any) any {
+	switch value {
+	case "unchecked":
+		return string(StateTodo)
+	case "checked", "completed":
+		return string(StateDone)
+	default:
+		return value
+	}
+}
+
+// migrateTagsV0 converts a tag set stored as an object to the list form
+// ORSet.MarshalJSON writes, sorted by tag.
+func migrateTagsV0(tags map[string]any) []any {
+	list := make([]any, 0, len(tags))
+	for _, tag := range slices.Sorted(maps.Keys(tags)) {
+		list = append(list, map[string]any{"Value": tag, "Dots": tags[tag]})
+	}
+	return list
+}
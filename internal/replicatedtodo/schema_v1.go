@@ -0,0 +1,56 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// textV1 is a PersistedText as version 1 wrote it, with the timestamps of
+// its edits, and of the characters they refer to, written in full each
+// time.
+type textV1 struct {
+	Timestamp Timestamp
+	Value     string
+	Edits     []TextEdit
+}
+
+// migrateV1 rewrites the titles and notes of version 1, which wrote the
+// timestamps of their edits in full, in the compact form of version 2.
+func migrateV1(doc document) error {
+	return rewriteField(doc, "Items", func(items map[string]any) error {
+		for id, item := range items {
+			item, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, name := range []string{"Title", "Notes"} {
+				text, ok := item[name].(map[string]any)
+				if !ok {
+					continue
+				}
+				migrated, err := migrateTextV1(text)
+				if err != nil {
+					return fmt.Errorf("item %s: %s: %w", id, name, err)
+				}
+				item[name] = migrated
+			}
+		}
+		return nil
+	})
+}
+
+func migrateTextV1(text map[string]any) (json.RawMessage, error) {
+	data, err := json.Marshal(text)
+	if err != nil {
+		return nil, err
+	}
+	var old textV1
+	if err := json.Unmarshal(data, &old); err != nil {
+		return nil, err
+	}
+	t := newPersistedText(old.Value, old.Timestamp)
+	for _, edit := range old.Edits {
+		t = t.edit(edit)
+	}
+	return json.Marshal(t)
+}
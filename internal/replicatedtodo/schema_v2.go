@@ -0,0 +1,11 @@
+package replicatedtodo
+
+// migrateV2 upgrades a document of version 2, whose order keys are written
+// with the Lowercase alphabet, to version 3, which writes them with
+// Base62. Lowercase keys are valid Base62 keys that sort the same way
+// among themselves and among Base62 keys, so they are kept as they are.
+// The version changes only so that older versions of sift refuse to read
+// Base62 keys rather than failing on them as invalid orders.
+func migrateV2(document) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package replicatedtodo
+
+// PersistedSettings holds the options that apply to the whole list. They
+// are kept in the list, rather than on each machine, so that every replica
+// uses the same ones.
+type PersistedSettings struct {
+	// TextMerge is whether titles and notes are changed character by
+	// character, so that concurrent edits to the same text are merged,
+	// rather than replaced whole with the last writer winning.
+	TextMerge PersistedBool
+}
+
+// merge folds other into s.
+func (s *PersistedSettings) merge(other *PersistedSettings) {
+	s.TextMerge = s.TextMerge.merge(other.TextMerge)
+}
+
+// timestamps returns the timestamps of the settings' writes.
+func (s *PersistedSettings) timestamps() []Timestamp {
+	if s == nil {
+		return nil
+	}
+	return []Timestamp{s.TextMerge.Timestamp}
+}
+
+// mergeSettings folds other into the model's settings.
+func (model *PersistedModel) mergeSettings(other *PersistedSettings) {
+	if other == nil {
+		return
+	}
+	if model.Settings == nil {
+		model.Settings = &PersistedSettings{}
+	}
+	model.Settings.merge(other)
+}
+
+// SetTextMerge turns merging concurrent edits to titles and notes
+// character by character on or off for every replica.
+func (model *PersistedModel) SetTextMerge(on bool) {
+	model.mergeSettings(&PersistedSettings{TextMerge: newPersistedBool(on, model.now())})
+}
+
+// TextMerge reports whether titles and notes are changed character by
+// character. It is off unless turned on with SetTextMerge.
+func (model *PersistedModel) TextMerge() bool {
+	return model.Settings != nil && model.Settings.TextMerge.Value
+}
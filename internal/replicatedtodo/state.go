@@ -0,0 +1,98 @@
+package replicatedtodo
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// State is the status of an item.
+type State string
+
+const (
+	StateTodo       State = "todo"
+	StateInProgress State = "in-progress"
+	StateBlocked    State = "blocked"
+	StateDone       State = "done"
+	StateCancelled  State = "cancelled"
+)
+
+// States lists every state, in the order they are usually worked through.
+var States = []State{StateTodo, StateInProgress, StateBlocked, StateDone, StateCancelled}
+
+// ErrInvalidTransition is returned when an item cannot change from its
+// state to the one asked for.
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// Valid reports whether s is one of States.
+func (s State) Valid() bool {
+	return slices.Contains(States, s)
+}
+
+// Closed reports whether s is a state work on the item has finished in.
+func (s State) Closed() bool {
+	return s == StateDone || s == StateCancelled
+}
+
+// CanTransition reports whether an item in state s can be changed to
+// state to. Open items can change to any state, and closed items can only
+// be reopened as StateTodo.
+func (s State) CanTransition(to State) bool {
+	if !to.Valid() {
+		return false
+	}
+	return !s.Closed() || to == StateTodo
+}
+
+// Next returns the state that cycling forward from s leads to:
+// todo, in progress, done and back to todo. Blocked items resume in
+// progress, and cancelled items reopen as todo.
+func (s State) Next() State {
+	switch s {
+	case StateTodo, StateBlocked:
+		return StateInProgress
+	case StateInProgress:
+		return StateDone
+	default:
+		return StateTodo
+	}
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface. The
+// empty string is accepted as the value of a register that was never
+// written.
+func (s *State) UnmarshalText(text []byte) error {
+	if state := State(text); state != "" && !state.Valid() {
+		return fmt.Errorf("unknown state %q", text)
+	}
+	*s = State(text)
+	return nil
+}
+
+var _ encoding.TextUnmarshaler = new(State)
+
+// PersistedState is a last-writer-wins state register.
+type PersistedState struct {
+	Timestamp Timestamp
+	Value     State
+}
+
+func newPersistedState(value State, timestamp Timestamp) PersistedState {
+	return PersistedState{Value: value, Timestamp: timestamp}
+}
+
+// merge returns the last-writer-wins combination of s and other. Values
+// with the same timestamp are ordered by their name.
+func (s PersistedState) merge(other PersistedState) PersistedState {
+	if c := s.Timestamp.Compare(other.Timestamp); c != 0 {
+		if c > 0 {
+			return s
+		}
+		return other
+	}
+	if s.Value >= other.Value {
+		return s
+	}
+	return other
+}
@@ -0,0 +1,114 @@
+package replicatedtodo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestStateCanTransition(t *testing.T) {
+	for _, from := range States {
+		for _, to := range States {
+			want := !from.Closed() || to == StateTodo
+			if got := from.CanTransition(to); got != want {
+				t.Errorf("%q.CanTransition(%q) = %t, want %t", from, to, got, want)
+			}
+		}
+		if from.CanTransition("archived") {
+			t.Errorf("%q.CanTransition(%q) = true for an unknown state", from, "archived")
+		}
+	}
+}
+
+func TestStateNext(t *testing.T) {
+	want := map[State]State{
+		StateTodo:       StateInProgress,
+		StateInProgress: StateDone,
+		StateBlocked:    StateInProgress,
+		StateDone:       StateTodo,
+		StateCancelled:  StateTodo,
+	}
+	for _, s := range States {
+		if got := s.Next(); got != want[s] {
+			t.Errorf("%q.Next() = %q, want %q", s, got, want[s])
+		}
+		if !s.CanTransition(s.Next()) {
+			t.Errorf("%q cannot transition to %q.Next()", s, s)
+		}
+	}
+}
+
+func TestStateUnmarshal(t *testing.T) {
+	for text, want := range map[string]State{
+		"todo":        StateTodo,
+		"done":        StateDone,
+		"in-progress": StateInProgress,
+		"cancelled":   StateCancelled,
+	} {
+		var got State
+		if err := yaml.Unmarshal([]byte(text), &got); err != nil {
+			t.Errorf("Unmarshal(%q) error: %s", text, err)
+		} else if got != want {
+			t.Errorf("Unmarshal(%q) = %q, want %q", text, got, want)
+		}
+	}
+	// The states of version 0 are renamed by migrateV0.
+	for _, text := range []string{"archived", "unchecked"} {
+		var s State
+		if err := yaml.Unmarshal([]byte(text), &s); err == nil {
+			t.Errorf("Unmarshal(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestSetState(t *testing.T) {
+	var list ItemList
+	item, err := list.NewTodo("todo", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	id := item.ID
+	state := func() State {
+		return list.Items()[0].State
+	}
+
+	if err := list.SetState(id, StateBlocked); err != nil {
+		t.Fatalf("SetState(blocked) error: %s", err)
+	}
+	list.CycleState(id)
+	if got := state(); got != StateInProgress {
+		t.Errorf("state after CycleState() from blocked = %q, want %q", got, StateInProgress)
+	}
+	list.ToggleDone(id)
+	if got := state(); got != StateDone {
+		t.Errorf("state after ToggleDone() = %q, want %q", got, StateDone)
+	}
+	if err := list.SetState(id, StateCancelled); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("SetState(cancelled) from done error = %v, want ErrInvalidTransition", err)
+	}
+	if err := list.SetState(id, "archived"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("SetState(archived) error = %v, want ErrInvalidTransition", err)
+	}
+	list.ToggleDone(id)
+	if got := state(); got != StateTodo {
+		t.Errorf("state after ToggleDone() on a done item = %q, want %q", got, StateTodo)
+	}
+	if err := list.SetState(id, StateCancelled); err != nil {
+		t.Fatalf("SetState(cancelled) error: %s", err)
+	}
+
+	data, err := yaml.Marshal(&list)
+	if err != nil {
+		t.Fatalf("Marshal() error: %s", err)
+	}
+	var loaded ItemList
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if diff := cmp.Diff(list.Items(), loaded.Items()); diff != "" {
+		t.Errorf("Items() after YAML round trip mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,134 @@
+package replicatedtodo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+func TestTagsAddWins(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetClock(NewHybridClock(physical, uuid.New()))
+	item, err := laptop.NewTodo("todo", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	laptop.AddTag(item.ID, "home")
+	laptop.AddTag(item.ID, "work")
+	desktop := ItemList{}
+	desktop.SetClock(NewHybridClock(physical, uuid.New()))
+	desktop.Merge(&laptop)
+
+	// The laptop removes both tags while the desktop adds "home" again.
+	// The desktop's addition was not seen by the removal, so it wins
+	// even though the removal came later.
+	desktop.AddTag(item.ID, "home")
+	physical.now = physical.now.Add(time.Second)
+	laptop.RemoveTag(item.ID, "home")
+	laptop.RemoveTag(item.ID, "work")
+
+	laptop.Merge(&desktop)
+	desktop.Merge(&laptop)
+	for name, list := range map[string]*ItemList{"laptop": &laptop, "desktop": &desktop} {
+		if diff := cmp.Diff([]string{"home"}, list.Items()[0].Tags); diff != "" {
+			t.Errorf("%s tags mismatch (-want, +got):\n%s", name, diff)
+		}
+	}
+
+	// A removal that has seen every addition removes the tag everywhere.
+	desktop.RemoveTag(item.ID, "home")
+	laptop.Merge(&desktop)
+	if got := laptop.Items()[0].Tags; len(got) != 0 {
+		t.Errorf("tags after removing every addition = %q, want none", got)
+	}
+
+	// The tag can be added again after being removed.
+	laptop.AddTag(item.ID, "home")
+	desktop.Merge(&laptop)
+	if diff := cmp.Diff([]string{"home"}, desktop.Items()[0].Tags); diff != "" {
+		t.Errorf("tags after adding again mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestTagsRemoveBeforeAdd(t *testing.T) {
+	laptop := New()
+	id, err := laptop.NewTodo("todo", []byte("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	laptop.AddTag(id, "home")
+	laptop.RemoveTag(id, "home")
+
+	// Operations can arrive in any order; the removal applies to the
+	// addition it observed even if it arrives first.
+	ops := laptop.Operations()
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	got := Replay(ops)
+	if got.Items[id].Tags.Contains("home") {
+		t.Errorf("tag removed before it was added is present after replay")
+	}
+}
+
+func TestCompactDropsStableTagTombstones(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetReplica(uuid.New(), "laptop")
+	laptop.SetClock(NewHybridClock(physical, laptop.replicated.Replica()))
+	item, err := laptop.NewTodo("todo", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	laptop.AddTag(item.ID, "home")
+	desktop := ItemList{}
+	desktop.SetReplica(uuid.New(), "desktop")
+	desktop.SetClock(NewHybridClock(physical, desktop.replicated.Replica()))
+	desktop.Merge(&laptop)
+	desktop.Compact()
+	laptop.Merge(&desktop)
+	timestamps := func(list *ItemList) int {
+		return len(list.replicated.Items[item.ID].Tags.Timestamps())
+	}
+
+	// The desktop has not seen the removal, so the laptop keeps the
+	// tombstone that stops the desktop's addition from coming back.
+	laptop.RemoveTag(item.ID, "home")
+	laptop.Compact()
+	if got := timestamps(&laptop); got != 2 {
+		t.Errorf("timestamps after compacting before the desktop saw the removal = %d, want 2", got)
+	}
+
+	// Once the desktop has recorded seeing the removal and the laptop has
+	// merged that, both can drop the tombstone.
+	desktop.Merge(&laptop)
+	desktop.Compact()
+	laptop.Merge(&desktop)
+	laptop.Compact()
+	if got := timestamps(&laptop); got != 0 {
+		t.Errorf("laptop timestamps after compacting = %d, want 0", got)
+	}
+	desktop.Merge(&laptop)
+	desktop.Compact()
+	if got := timestamps(&desktop); got != 0 {
+		t.Errorf("desktop timestamps after compacting = %d, want 0", got)
+	}
+	if got := desktop.Items()[0].Tags; len(got) != 0 {
+		t.Errorf("tags after compacting = %q, want none", got)
+	}
+
+	// A new replica catches the item up on the logged operations, which
+	// must not bring back the compacted addition and removal.
+	tablet := ItemList{}
+	tablet.SetClock(NewHybridClock(physical, uuid.New()))
+	tablet.Merge(&desktop)
+	if got := timestamps(&tablet); got != 0 {
+		t.Errorf("timestamps on a new replica after compacting = %d, want 0", got)
+	}
+	if got := tablet.Items()[0].Tags; len(got) != 0 {
+		t.Errorf("tags on a new replica after compacting = %q, want none", got)
+	}
+}
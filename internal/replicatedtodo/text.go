@@ -0,0 +1,577 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// CharID identifies a character of a PersistedText: the Offset-th
+// character, counting runes from zero, of the base value or edit stamped
+// Edit.
+type CharID struct {
+	Edit   Timestamp
+	Offset int
+}
+
+// Compare returns -1, 0 or +1 depending on whether id was written before,
+// at the same time as or after other.
+func (id CharID) Compare(other CharID) int {
+	if c := id.Edit.Compare(other.Edit); c != 0 {
+		return c
+	}
+	return id.Offset - other.Offset
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. IDs are
+// written as the edit's timestamp and the offset, such as
+// "2024-06-01T12:00:00Z+0@<replica>#3".
+func (id CharID) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%s#%d", id.Edit, id.Offset), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *CharID) UnmarshalText(text []byte) error {
+	edit, offset, err := splitCharRef(string(text))
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(offset)
+	if err != nil {
+		return fmt.Errorf("invalid character ID %q: %w", text, err)
+	}
+	*id = CharID{Edit: edit, Offset: n}
+	return nil
+}
+
+// CharRange is the characters of a base value or edit stamped Edit with
+// offsets in [From, To).
+type CharRange struct {
+	Edit     Timestamp
+	From, To int
+}
+
+func (r CharRange) contains(id CharID) bool {
+	return r.Edit.Compare(id.Edit) == 0 && r.From <= id.Offset && id.Offset < r.To
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. Ranges are
+// written as the edit's timestamp and the offsets, such as
+// "2024-06-01T12:00:00Z+0@<replica>#3-5".
+func (r CharRange) MarshalText() ([]byte, error) {
+	return fmt.Appendf(nil, "%s#%d-%d", r.Edit, r.From, r.To), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (r *CharRange) UnmarshalText(text []byte) error {
+	edit, offsets, err := splitCharRef(string(text))
+	if err != nil {
+		return err
+	}
+	from, to, ok := strings.Cut(offsets, "-")
+	if !ok {
+		return fmt.Errorf("invalid character range %q", text)
+	}
+	f, err := strconv.Atoi(from)
+	if err != nil {
+		return fmt.Errorf("invalid character range %q: %w", text, err)
+	}
+	t, err := strconv.Atoi(to)
+	if err != nil {
+		return fmt.Errorf("invalid character range %q: %w", text, err)
+	}
+	*r = CharRange{Edit: edit, From: f, To: t}
+	return nil
+}
+
+// splitCharRef splits a CharID or CharRange's text into its timestamp and
+// what follows the '#'.
+func splitCharRef(s string) (Timestamp, string, error) {
+	i := strings.LastIndexByte(s, '#')
+	if i < 0 {
+		return Timestamp{}, "", fmt.Errorf("invalid character reference %q", s)
+	}
+	t, err := parseTimestamp(s[:i])
+	if err != nil {
+		return Timestamp{}, "", err
+	}
+	return t, s[i+1:], nil
+}
+
+// parseTimestamp parses the output of Timestamp.String.
+func parseTimestamp(s string) (Timestamp, error) {
+	at := strings.LastIndexByte(s, '@')
+	if at < 0 {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	plus := strings.LastIndexByte(s[:at], '+')
+	if plus < 0 {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	wall, err := time.Parse(time.RFC3339Nano, s[:plus])
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	counter, err := strconv.ParseUint(s[plus+1:at], 10, 32)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	replica, err := uuid.Parse(s[at+1:])
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+	return Timestamp{Wall: wall, Counter: uint32(counter), Replica: replica}, nil
+}
+
+// TextEdit is one change to a PersistedText: the removal of some of its
+// characters and the insertion of new ones after a character.
+type TextEdit struct {
+	Timestamp Timestamp
+	// After is the character Insert follows, or nil to insert at the
+	// start.
+	After *CharID `json:",omitempty"`
+	// Insert is the inserted text. Its characters are identified by
+	// Timestamp and their offset in it.
+	Insert string `json:",omitempty"`
+	// Delete is the removed characters.
+	Delete []CharRange `json:",omitempty"`
+}
+
+// TextRun identifies Len characters of a PersistedText's base value that
+// were inserted together: those with offsets from Start.Offset of the
+// base value or edit stamped Start.Edit. Deleted characters are kept as
+// runs so that edits can still refer to them, but their text is not.
+type TextRun struct {
+	Start   CharID
+	Len     int
+	Deleted bool
+}
+
+// PersistedText is a text that replicas can edit concurrently without
+// losing each other's changes, a sequence CRDT in the style of RGA.
+//
+// The text starts from a base value, a last-writer-wins register like
+// PersistedString that holds the whole text when it was last set outright.
+// Edits since then are kept as a list of insertions and deletions of
+// characters, each character identified by the edit that inserted it, so
+// edits made concurrently on different replicas apply to the characters
+// they meant to change. Setting the base value again supersedes the edits
+// that came before it. Text with no edits is stored just like a
+// PersistedString, which is how titles and notes were stored before they
+// could be edited character by character.
+//
+// Once every replica has every edit up to some point, compact folds those
+// edits into the base value, which then keeps the IDs of its characters
+// in Runs.
+type PersistedText struct {
+	Timestamp Timestamp
+	// Base is the visible text of the base value.
+	Base string
+	// Runs identifies the characters of the base value, including deleted
+	// ones, in order. If it is nil the base value is Base, its characters
+	// identified by Timestamp and their offset.
+	Runs []TextRun
+	// Edits holds the edits made after Timestamp, sorted by timestamp.
+	Edits []TextEdit
+}
+
+func newPersistedText(value string, timestamp Timestamp) PersistedText {
+	return PersistedText{Base: value, Timestamp: timestamp}
+}
+
+// merge returns the combination of t and other: the later base value, and
+// the edits of both that came after it. Base values with the same
+// timestamp are ordered by their value, as in PersistedString.merge.
+func (t PersistedText) merge(other PersistedText) PersistedText {
+	base := t
+	if c := t.Timestamp.Compare(other.Timestamp); c < 0 || (c == 0 && strings.Compare(t.Base, other.Base) < 0) {
+		base = other
+	}
+	merged := PersistedText{Timestamp: base.Timestamp, Base: base.Base, Runs: base.Runs}
+	for _, edits := range [][]TextEdit{t.Edits, other.Edits} {
+		for _, edit := range edits {
+			merged = merged.edit(edit)
+		}
+	}
+	return merged
+}
+
+// edit returns t with edit added, unless it is already there or the base
+// value was set after it.
+func (t PersistedText) edit(edit TextEdit) PersistedText {
+	if edit.Timestamp.Compare(t.Timestamp) <= 0 {
+		return t
+	}
+	i, found := slices.BinarySearchFunc(t.Edits, edit, func(a, b TextEdit) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	if !found {
+		t.Edits = slices.Insert(slices.Clip(t.Edits), i, edit)
+	}
+	return t
+}
+
+// textChar is a character of a PersistedText, kept after it is deleted so
+// that edits can still refer to it.
+type textChar struct {
+	id      CharID
+	r       rune
+	deleted bool
+}
+
+// baseChars returns the characters of the base value in order.
+func (t PersistedText) baseChars() []textChar {
+	runes := []rune(t.Base)
+	if t.Runs == nil {
+		chars := make([]textChar, len(runes))
+		for i, r := range runes {
+			chars[i] = textChar{id: CharID{Edit: t.Timestamp, Offset: i}, r: r}
+		}
+		return chars
+	}
+	var chars []textChar
+	for _, run := range t.Runs {
+		for i := range run.Len {
+			c := textChar{id: CharID{Edit: run.Start.Edit, Offset: run.Start.Offset + i}, deleted: run.Deleted}
+			if !run.Deleted {
+				c.r, runes = runes[0], runes[1:]
+			}
+			chars = append(chars, c)
+		}
+	}
+	return chars
+}
+
+// chars returns the characters of the text in order.
+//
+// The edits are replayed in timestamp order, which respects causality
+// because a replica stamps an edit after every edit it has seen. Text
+// inserted after a character goes before any text already inserted after
+// that character by a later edit, so concurrent insertions at the same
+// place are ordered the same way on every replica, newest first, and are
+// never interleaved. An edit whose insertion follows a character of a
+// superseded base value has nowhere to go and is dropped.
+func (t PersistedText) chars() []textChar {
+	chars := t.baseChars()
+	for _, edit := range t.Edits {
+		for _, removed := range edit.Delete {
+			for i := range chars {
+				if removed.contains(chars[i].id) {
+					chars[i].deleted = true
+				}
+			}
+		}
+		if edit.Insert == "" {
+			continue
+		}
+		p := 0
+		if edit.After != nil {
+			i := slices.IndexFunc(chars, func(c textChar) bool {
+				return c.id.Compare(*edit.After) == 0
+			})
+			if i < 0 {
+				continue
+			}
+			p = i + 1
+		}
+		first := CharID{Edit: edit.Timestamp}
+		for p < len(chars) && chars[p].id.Compare(first) > 0 {
+			p++
+		}
+		var inserted []textChar
+		for i, r := range []rune(edit.Insert) {
+			inserted = append(inserted, textChar{id: CharID{Edit: edit.Timestamp, Offset: i}, r: r})
+		}
+		chars = slices.Insert(chars, p, inserted...)
+	}
+	return chars
+}
+
+// compact returns t with the edits stamped at or before settled folded
+// into the base value. Settled must be a timestamp every replica has every
+// write up to, so that every replica that folds edits up to the same
+// point folds the same ones, and no edit folded away can arrive later.
+func (t PersistedText) compact(settled Timestamp) PersistedText {
+	n, _ := slices.BinarySearchFunc(t.Edits, settled, func(edit TextEdit, settled Timestamp) int {
+		if edit.Timestamp.Compare(settled) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if n == 0 {
+		return t
+	}
+	upTo := PersistedText{Timestamp: t.Timestamp, Base: t.Base, Runs: t.Runs, Edits: t.Edits[:n]}
+	var base strings.Builder
+	var runs []TextRun
+	for _, c := range upTo.chars() {
+		if !c.deleted {
+			base.WriteRune(c.r)
+		}
+		if k := len(runs); k > 0 {
+			last := &runs[k-1]
+			if last.Deleted == c.deleted && last.Start.Edit.Compare(c.id.Edit) == 0 &&
+				last.Start.Offset+last.Len == c.id.Offset {
+				last.Len++
+				continue
+			}
+		}
+		runs = append(runs, TextRun{Start: c.id, Len: 1, Deleted: c.deleted})
+	}
+	folded := PersistedText{Timestamp: t.Edits[n-1].Timestamp, Base: base.String(), Runs: runs}
+	if n < len(t.Edits) {
+		folded.Edits = slices.Clone(t.Edits[n:])
+	}
+	return folded
+}
+
+// visible returns the characters of the text that have not been deleted.
+func (t PersistedText) visible() []textChar {
+	return slices.DeleteFunc(t.chars(), func(c textChar) bool {
+		return c.deleted
+	})
+}
+
+// String returns the text.
+func (t PersistedText) String() string {
+	if len(t.Edits) == 0 {
+		return t.Base
+	}
+	var b strings.Builder
+	for _, c := range t.visible() {
+		b.WriteRune(c.r)
+	}
+	return b.String()
+}
+
+// diff returns the edit, without a timestamp, that changes the text to
+// value by replacing the characters between their common prefix and
+// suffix, and false if the text is already value.
+func (t PersistedText) diff(value string) (TextEdit, bool) {
+	old := t.visible()
+	runes := []rune(value)
+	prefix := 0
+	for prefix < len(old) && prefix < len(runes) && old[prefix].r == runes[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(runes)-prefix &&
+		old[len(old)-1-suffix].r == runes[len(runes)-1-suffix] {
+		suffix++
+	}
+	if prefix+suffix == len(old) && prefix+suffix == len(runes) {
+		return TextEdit{}, false
+	}
+
+	edit := TextEdit{Insert: string(runes[prefix : len(runes)-suffix])}
+	if prefix > 0 {
+		after := old[prefix-1].id
+		edit.After = &after
+	}
+	for _, c := range old[prefix : len(old)-suffix] {
+		if n := len(edit.Delete); n > 0 {
+			last := &edit.Delete[n-1]
+			if last.Edit.Compare(c.id.Edit) == 0 && last.To == c.id.Offset {
+				last.To++
+				continue
+			}
+		}
+		edit.Delete = append(edit.Delete, CharRange{Edit: c.id.Edit, From: c.id.Offset, To: c.id.Offset + 1})
+	}
+	return edit, true
+}
+
+// timestamps returns the timestamps of the base value and every edit.
+func (t PersistedText) timestamps() []Timestamp {
+	timestamps := []Timestamp{t.Timestamp}
+	for _, edit := range t.Edits {
+		timestamps = append(timestamps, edit.Timestamp)
+	}
+	return timestamps
+}
+
+// since returns the part of t a replica that has reached vv is missing:
+// the base value if it has not seen it, and the edits it has not seen.
+func (t PersistedText) since(vv VersionVector) PersistedText {
+	var delta PersistedText
+	if !vv.Includes(t.Timestamp) {
+		delta = PersistedText{Timestamp: t.Timestamp, Base: t.Base, Runs: t.Runs}
+	}
+	for _, edit := range t.Edits {
+		if !vv.Includes(edit.Timestamp) {
+			delta.Edits = append(delta.Edits, edit)
+		}
+	}
+	return delta
+}
+
+// textJSON is the JSON form of a PersistedText. The timestamps of the
+// edits, and of the characters the edits and runs refer to, are written
+// once each in Stamps, sorted, and referred to by their index there. A
+// character is written as "<stamp>:<offset>", a range of them as
+// "<stamp>:<from>-<to>", and a run as its range followed by " deleted" if
+// its characters are deleted.
+type textJSON struct {
+	Timestamp Timestamp
+	Value     string
+	Stamps    []string       `json:",omitempty"`
+	Runs      []string       `json:",omitempty"`
+	Edits     []textEditJSON `json:",omitempty"`
+}
+
+// textEditJSON is the JSON form of a TextEdit, with At the index of its
+// timestamp in Stamps.
+type textEditJSON struct {
+	At     int
+	After  string   `json:",omitempty"`
+	Insert string   `json:",omitempty"`
+	Delete []string `json:",omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t PersistedText) MarshalJSON() ([]byte, error) {
+	var stamps []Timestamp
+	for _, run := range t.Runs {
+		stamps = append(stamps, run.Start.Edit)
+	}
+	for _, edit := range t.Edits {
+		stamps = append(stamps, edit.Timestamp)
+		if edit.After != nil {
+			stamps = append(stamps, edit.After.Edit)
+		}
+		for _, removed := range edit.Delete {
+			stamps = append(stamps, removed.Edit)
+		}
+	}
+	slices.SortFunc(stamps, Timestamp.Compare)
+	stamps = slices.CompactFunc(stamps, func(a, b Timestamp) bool {
+		return a.Compare(b) == 0
+	})
+	index := func(t Timestamp) int {
+		i, _ := slices.BinarySearchFunc(stamps, t, Timestamp.Compare)
+		return i
+	}
+
+	out := textJSON{Timestamp: t.Timestamp, Value: t.Base}
+	for _, stamp := range stamps {
+		out.Stamps = append(out.Stamps, stamp.String())
+	}
+	for _, run := range t.Runs {
+		text := fmt.Sprintf("%d:%d-%d", index(run.Start.Edit), run.Start.Offset, run.Start.Offset+run.Len)
+		if run.Deleted {
+			text += " deleted"
+		}
+		out.Runs = append(out.Runs, text)
+	}
+	for _, edit := range t.Edits {
+		e := textEditJSON{At: index(edit.Timestamp), Insert: edit.Insert}
+		if edit.After != nil {
+			e.After = fmt.Sprintf("%d:%d", index(edit.After.Edit), edit.After.Offset)
+		}
+		for _, removed := range edit.Delete {
+			e.Delete = append(e.Delete, fmt.Sprintf("%d:%d-%d", index(removed.Edit), removed.From, removed.To))
+		}
+		out.Edits = append(out.Edits, e)
+	}
+	return json.Marshal(out)
+}
+
+var _ json.Marshaler = PersistedText{}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, reading the
+// form written by MarshalJSON. Fields it does not write are refused, so
+// the edits of schema version 1, which wrote each timestamp in full, are
+// left for the migration to rewrite.
+func (t *PersistedText) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var in textJSON
+	if err := dec.Decode(&in); err != nil {
+		return err
+	}
+	var stamps []Timestamp
+	for _, text := range in.Stamps {
+		stamp, err := parseTimestamp(text)
+		if err != nil {
+			return err
+		}
+		stamps = append(stamps, stamp)
+	}
+	// ref parses "<stamp>:<rest>" and returns the stamp and rest.
+	ref := func(text string) (Timestamp, string, error) {
+		i, rest, ok := strings.Cut(text, ":")
+		n, err := strconv.Atoi(i)
+		if !ok || err != nil || n < 0 || n >= len(stamps) {
+			return Timestamp{}, "", fmt.Errorf("invalid character reference %q", text)
+		}
+		return stamps[n], rest, nil
+	}
+	span := func(text string) (CharRange, error) {
+		stamp, rest, err := ref(text)
+		if err != nil {
+			return CharRange{}, err
+		}
+		from, to, ok := strings.Cut(rest, "-")
+		f, ferr := strconv.Atoi(from)
+		l, lerr := strconv.Atoi(to)
+		if !ok || ferr != nil || lerr != nil || f < 0 || l <= f {
+			return CharRange{}, fmt.Errorf("invalid character range %q", text)
+		}
+		return CharRange{Edit: stamp, From: f, To: l}, nil
+	}
+
+	*t = newPersistedText(in.Value, in.Timestamp)
+	if in.Runs != nil {
+		t.Runs = []TextRun{}
+	}
+	visible := 0
+	for _, text := range in.Runs {
+		text, deleted := strings.CutSuffix(text, " deleted")
+		r, err := span(text)
+		if err != nil {
+			return err
+		}
+		t.Runs = append(t.Runs, TextRun{Start: CharID{Edit: r.Edit, Offset: r.From}, Len: r.To - r.From, Deleted: deleted})
+		if !deleted {
+			visible += r.To - r.From
+		}
+	}
+	if t.Runs != nil && visible != utf8.RuneCountInString(t.Base) {
+		return fmt.Errorf("text runs hold %d characters, but the value has %d", visible, utf8.RuneCountInString(t.Base))
+	}
+	for _, e := range in.Edits {
+		if e.At < 0 || e.At >= len(stamps) {
+			return fmt.Errorf("invalid edit timestamp index %d", e.At)
+		}
+		edit := TextEdit{Timestamp: stamps[e.At], Insert: e.Insert}
+		if e.After != "" {
+			stamp, rest, err := ref(e.After)
+			if err != nil {
+				return err
+			}
+			offset, err := strconv.Atoi(rest)
+			if err != nil || offset < 0 {
+				return fmt.Errorf("invalid character reference %q", e.After)
+			}
+			edit.After = &CharID{Edit: stamp, Offset: offset}
+		}
+		for _, text := range e.Delete {
+			r, err := span(text)
+			if err != nil {
+				return err
+			}
+			edit.Delete = append(edit.Delete, r)
+		}
+		*t = t.edit(edit)
+	}
+	return nil
+}
+
+var _ json.Unmarshaler = &PersistedText{}
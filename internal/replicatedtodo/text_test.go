@@ -0,0 +1,375 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// newTextReplicas returns n replicas, with TextMerge on, sharing one item
+// titled title, and the physical clock they share.
+func newTextReplicas(t *testing.T, n int, title string) ([]*PersistedModel, uuid.UUID, *fakeClock) {
+	t.Helper()
+	physical := newFakeClock()
+	var models []*PersistedModel
+	for range n {
+		models = append(models, NewWithClock(NewHybridClock(physical, uuid.New())))
+	}
+	models[0].SetTextMerge(true)
+	id, err := models[0].NewTodo(title, []byte("n"))
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	for _, m := range models[1:] {
+		m.Merge(models[0])
+	}
+	return models, id, physical
+}
+
+// syncAll merges every model into every other.
+func syncAll(models []*PersistedModel) {
+	for _, m := range models[1:] {
+		models[0].Merge(m)
+	}
+	for _, m := range models[1:] {
+		m.Merge(models[0])
+	}
+}
+
+func TestTextConcurrentEdits(t *testing.T) {
+	tests := []struct {
+		name, start, a, b, want string
+	}{
+		{"typo and append", "Buy mlk", "Buy milk", "Buy mlk today", "Buy milk today"},
+		{"insert into deleted word", "call the bank", "call bank", "call thee bank", "call ebank"},
+		{"same place", "ab", "aXb", "aYb", "aYXb"},
+		{"both delete", "abcdef", "adef", "abef", "aef"},
+		{"unicode", "héllo", "héllo wörld", "¡héllo", "¡héllo wörld"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models, id, physical := newTextReplicas(t, 2, tt.start)
+			models[0].SetTitle(id, tt.a)
+			// Later insertions at the same place go first.
+			physical.now = physical.now.Add(time.Second)
+			models[1].SetTitle(id, tt.b)
+			syncAll(models)
+			for i, m := range models {
+				if got := m.GetItem(id).Title; got != tt.want {
+					t.Errorf("replica %d title = %q, want %q", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTextNotes(t *testing.T) {
+	models, id, _ := newTextReplicas(t, 2, "todo")
+	models[0].SetNotes(id, "first line\nsecond line")
+	syncAll(models)
+	models[0].SetNotes(id, "first line\nsecond line\nthird line")
+	models[1].SetNotes(id, "1st line\nsecond line")
+	syncAll(models)
+	want := "1st line\nsecond line\nthird line"
+	for i, m := range models {
+		if got := m.GetItem(id).Notes; got != want {
+			t.Errorf("replica %d notes = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestTextReplaceSupersedesEdits(t *testing.T) {
+	models, id, physical := newTextReplicas(t, 2, "draft")
+	models[0].SetTitle(id, "draft one")
+	physical.now = physical.now.Add(time.Second)
+
+	// A whole replacement, as logged by older versions, stamped after
+	// the edit wins over it.
+	models[1].Apply(Operation{Kind: OpSetTitle, Timestamp: models[1].now(), Item: id, Title: "final"})
+	syncAll(models)
+	for i, m := range models {
+		if got := m.GetItem(id).Title; got != "final" {
+			t.Errorf("replica %d title = %q, want %q", i, got, "final")
+		}
+		if edits := m.Items[id].Title.Edits; len(edits) != 0 {
+			t.Errorf("replica %d kept %d superseded edits", i, len(edits))
+		}
+	}
+
+	// Edits after the replacement apply to it.
+	models[0].SetTitle(id, "final!")
+	syncAll(models)
+	if got := models[1].GetItem(id).Title; got != "final!" {
+		t.Errorf("title = %q, want %q", got, "final!")
+	}
+}
+
+func TestTextSetTitleUnchanged(t *testing.T) {
+	models, id, _ := newTextReplicas(t, 1, "same")
+	before := len(models[0].Log)
+	models[0].SetTitle(id, "same")
+	if len(models[0].Log) != before {
+		t.Errorf("SetTitle() with the current title logged an operation")
+	}
+}
+
+// mutate returns s with a random run of characters replaced by random
+// letters.
+func mutate(r *rand.Rand, s string) string {
+	runes := []rune(s)
+	from := r.IntN(len(runes) + 1)
+	to := from + r.IntN(len(runes)-from+1)
+	var insert []rune
+	for range r.IntN(4) {
+		insert = append(insert, rune('a'+r.IntN(4)))
+	}
+	return string(runes[:from]) + string(insert) + string(runes[to:])
+}
+
+func TestTextConverges(t *testing.T) {
+	r := rand.New(rand.NewPCG(31, 32))
+	for range 100 {
+		models, id, _ := newTextReplicas(t, 3, "abcdef")
+		for range r.IntN(30) {
+			m := models[r.IntN(len(models))]
+			if r.IntN(4) == 0 {
+				m.Merge(models[r.IntN(len(models))])
+				continue
+			}
+			title := mutate(r, m.GetItem(id).Title)
+			m.SetTitle(id, title)
+			if got := m.GetItem(id).Title; got != title {
+				t.Fatalf("title after SetTitle(%q) = %q", title, got)
+			}
+		}
+		syncAll(models)
+		want := models[0].GetItem(id).Title
+		for i, m := range models[1:] {
+			if got := m.GetItem(id).Title; got != want {
+				t.Fatalf("replica %d title = %q, replica 0 title = %q", i+1, got, want)
+			}
+		}
+		if got := Replay(models[0].Operations()).GetItem(id).Title; got != want {
+			t.Fatalf("replayed title = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestTextMergeOff(t *testing.T) {
+	models, id, physical := newTextReplicas(t, 2, "Buy mlk")
+	models[1].SetTextMerge(false)
+	syncAll(models)
+	if models[0].TextMerge() {
+		t.Fatalf("TextMerge() after another replica turned it off = true, want false")
+	}
+
+	// Without TextMerge the whole title is replaced, and the later
+	// replacement wins.
+	models[0].SetTitle(id, "Buy milk")
+	physical.now = physical.now.Add(time.Second)
+	models[1].SetTitle(id, "Buy mlk today")
+	syncAll(models)
+	for i, m := range models {
+		if got := m.GetItem(id).Title; got != "Buy mlk today" {
+			t.Errorf("replica %d title = %q, want %q", i, got, "Buy mlk today")
+		}
+		if edits := m.Items[id].Title.Edits; len(edits) != 0 {
+			t.Errorf("replica %d recorded %d character edits with TextMerge off", i, len(edits))
+		}
+	}
+}
+
+// latestEdit returns the timestamp of the latest edit to the item's title.
+func latestEdit(m *PersistedModel, id uuid.UUID) Timestamp {
+	timestamps := m.Items[id].Title.timestamps()
+	return timestamps[len(timestamps)-1]
+}
+
+func TestTextCompact(t *testing.T) {
+	r := rand.New(rand.NewPCG(33, 34))
+	for range 100 {
+		models, id, _ := newTextReplicas(t, 3, "abcdef")
+		edit := func(n int) {
+			for range n {
+				m := models[r.IntN(len(models))]
+				if r.IntN(4) == 0 {
+					m.Merge(models[r.IntN(len(models))])
+					continue
+				}
+				m.SetTitle(id, mutate(r, m.GetItem(id).Title))
+			}
+		}
+		edit(r.IntN(30))
+		syncAll(models)
+
+		// Once every replica has every edit, folding them into the base
+		// value on one replica keeps the title.
+		want := models[0].GetItem(id).Title
+		compacted := &models[r.IntN(len(models))].Items[id].Title
+		*compacted = compacted.compact(latestEdit(models[0], id))
+		if got := compacted.String(); got != want {
+			t.Fatalf("title after compact() = %q, want %q", got, want)
+		}
+		if len(compacted.Edits) != 0 {
+			t.Fatalf("compact() left %d edits", len(compacted.Edits))
+		}
+
+		// Edits made afterwards, by replicas that have compacted or not,
+		// converge to the same title as when nothing was compacted.
+		edit(r.IntN(30))
+		syncAll(models)
+		want = Replay(models[0].Operations()).GetItem(id).Title
+		for i, m := range models {
+			if got := m.GetItem(id).Title; got != want {
+				t.Fatalf("replica %d title = %q, want %q as replayed without compacting", i, got, want)
+			}
+		}
+	}
+}
+
+func TestCompactFoldsSettledEdits(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetReplica(uuid.New(), "laptop")
+	laptop.SetClock(NewHybridClock(physical, laptop.replicated.Replica()))
+	laptop.SetTextMerge(true)
+	item, err := laptop.NewTodo("Buy mlk", uuid.UUID{})
+	if err != nil {
+		t.Fatalf("error creating todo: %s", err)
+	}
+	desktop := ItemList{}
+	desktop.SetReplica(uuid.New(), "desktop")
+	desktop.SetClock(NewHybridClock(physical, desktop.replicated.Replica()))
+	desktop.Merge(&laptop)
+	desktop.Compact()
+	laptop.Merge(&desktop)
+	edits := func(list *ItemList) int {
+		return len(list.replicated.Items[item.ID].Title.Edits)
+	}
+
+	// The desktop has not recorded seeing the edit, so it is kept.
+	laptop.SetTitle(item.ID, "Buy milk")
+	laptop.Compact()
+	if got := edits(&laptop); got != 1 {
+		t.Errorf("edits after compacting before the desktop saw the edit = %d, want 1", got)
+	}
+
+	desktop.Merge(&laptop)
+	desktop.Compact()
+	laptop.Merge(&desktop)
+	laptop.Compact()
+	if got := edits(&laptop); got != 0 {
+		t.Errorf("edits after compacting = %d, want 0", got)
+	}
+	if got := laptop.Items()[0].Title; got != "Buy milk" {
+		t.Errorf("title after compacting = %q, want %q", got, "Buy milk")
+	}
+	// A new replica catches the item up on the logged operations, which
+	// must not bring back the folded edit.
+	tablet := ItemList{}
+	tablet.SetClock(NewHybridClock(physical, uuid.New()))
+	tablet.Merge(&laptop)
+	if got := edits(&tablet); got != 0 {
+		t.Errorf("edits on a new replica after compacting = %d, want 0", got)
+	}
+	if got := tablet.Items()[0].Title; got != "Buy milk" {
+		t.Errorf("title on a new replica after compacting = %q, want %q", got, "Buy milk")
+	}
+
+	// The desktop, which has not compacted, edits the same text again.
+	desktop.SetTitle(item.ID, "Buy milk today")
+	laptop.Merge(&desktop)
+	if got := laptop.Items()[0].Title; got != "Buy milk today" {
+		t.Errorf("title after merging an edit to the compacted text = %q, want %q", got, "Buy milk today")
+	}
+}
+
+func TestTextJSON(t *testing.T) {
+	models, id, _ := newTextReplicas(t, 1, "Buy mlk")
+	models[0].SetTitle(id, "Buy milk")
+	text := models[0].Items[id].Title
+	data, err := json.Marshal(text)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	// The edit refers to the base value's timestamp by its index.
+	if want := `"After":"0:4"`; !strings.Contains(string(data), want) {
+		t.Errorf("json.Marshal() = %s, want it to contain %s", data, want)
+	}
+	if n := strings.Count(string(data), text.Edits[0].Timestamp.String()); n != 1 {
+		t.Errorf("json.Marshal() = %s, want the edit's timestamp written once, not %d times", data, n)
+	}
+	var got PersistedText
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+	if diff := cmp.Diff(text, got); diff != "" {
+		t.Errorf("JSON round trip mismatch (-want, +got):\n%s", diff)
+	}
+	if got.String() != "Buy milk" {
+		t.Errorf("text after JSON round trip = %q, want %q", got, "Buy milk")
+	}
+
+	// A compacted text keeps the IDs of its characters, deleted ones too.
+	models[0].SetTitle(id, "Buy oat milk")
+	models[0].SetTitle(id, "Buy oat mik")
+	compacted := models[0].Items[id].Title.compact(latestEdit(models[0], id))
+	data, err = json.Marshal(compacted)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if want := ` deleted"`; !strings.Contains(string(data), want) {
+		t.Errorf("json.Marshal() = %s, want it to contain %s", data, want)
+	}
+	got = PersistedText{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+	if diff := cmp.Diff(compacted, got); diff != "" {
+		t.Errorf("JSON round trip of compacted text mismatch (-want, +got):\n%s", diff)
+	}
+	// Deleted characters keep only their IDs.
+	want := models[0].Items[id].Title.chars()
+	for i := range want {
+		if want[i].deleted {
+			want[i].r = 0
+		}
+	}
+	if diff := cmp.Diff(want, got.chars(), cmp.AllowUnexported(textChar{})); diff != "" {
+		t.Errorf("characters after compacting and JSON round trip mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Text stored as a PersistedString loads as the base value.
+	var legacy PersistedText
+	if err := json.Unmarshal([]byte(`{"Timestamp":{"Wall":"2024-06-01T12:00:00Z"},"Value":"old"}`), &legacy); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if legacy.String() != "old" || len(legacy.Edits) != 0 {
+		t.Errorf("legacy text = %+v, want base value %q", legacy, "old")
+	}
+}
+
+func TestCharRangeText(t *testing.T) {
+	want := CharRange{Edit: NewHybridClock(newFakeClock(), uuid.New()).Now(), From: 2, To: 5}
+	data, err := want.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: %v", err)
+	}
+	var got CharRange
+	if err := got.UnmarshalText(data); err != nil {
+		t.Fatalf("UnmarshalText(%s): %v", data, err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("text round trip mismatch (-want, +got):\n%s", diff)
+	}
+	for _, bad := range []string{"", "#1-2", want.Edit.String() + "#1", want.Edit.String() + "#a-b"} {
+		if err := got.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package replicatedtodo
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// TreeItem is an item with its place in the tree of items.
+type TreeItem struct {
+	Item
+	// Parent is the ID of the item's parent, or the zero UUID for a top
+	// level item.
+	Parent uuid.UUID
+	// Depth is 0 for top level items, 1 for their children, and so on.
+	Depth int
+	// HasChildren reports whether the item has children that are not
+	// deleted.
+	HasChildren bool
+}
+
+// parents returns the parent of every item in the tree the model's items
+// form, with the zero UUID for top level items.
+//
+// Each replica refuses moves that would put an item under itself, but
+// concurrent moves on different replicas, such as moving a under b on one
+// and b under a on the other, can still form a cycle once merged. Every
+// item has one parent, so cycles are disjoint, and each is broken by
+// treating the item in it that was moved last as a top level item. This
+// depends only on the merged state, so every replica builds the same tree.
+// Items whose parent has not been seen yet are also treated as top level
+// items until it arrives.
+func (model *PersistedModel) parents() map[uuid.UUID]uuid.UUID {
+	parents := make(map[uuid.UUID]uuid.UUID, len(model.Items))
+	for id, item := range model.Items {
+		parent := item.Order.Parent
+		if _, ok := model.Items[parent]; !ok {
+			parent = uuid.UUID{}
+		}
+		parents[id] = parent
+	}
+
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[uuid.UUID]int, len(model.Items))
+	for id := range model.Items {
+		var path []uuid.UUID
+		current := id
+		for current != (uuid.UUID{}) && state[current] == unvisited {
+			state[current] = onPath
+			path = append(path, current)
+			current = parents[current]
+		}
+		if current != (uuid.UUID{}) && state[current] == onPath {
+			cycle := path[slices.Index(path, current):]
+			last := slices.MaxFunc(cycle, func(a, b uuid.UUID) int {
+				return model.compareMoves(a, b)
+			})
+			parents[last] = uuid.UUID{}
+		}
+		for _, visited := range path {
+			state[visited] = done
+		}
+	}
+	return parents
+}
+
+// compareMoves orders two items by when they were last moved, breaking
+// ties by ID.
+func (model *PersistedModel) compareMoves(a, b uuid.UUID) int {
+	if c := model.Items[a].Order.Timestamp.Compare(model.Items[b].Order.Timestamp); c != 0 {
+		return c
+	}
+	return bytes.Compare(a[:], b[:])
+}
+
+// children returns the children of every item, and the top level items
+// under the zero UUID, each sorted by order. Deleted items are included.
+func (model *PersistedModel) children(parents map[uuid.UUID]uuid.UUID) map[uuid.UUID][]*PersistedItem {
+	children := make(map[uuid.UUID][]*PersistedItem)
+	for _, item := range model.sorted() {
+		parent := parents[item.ID]
+		children[parent] = append(children[parent], item)
+	}
+	return children
+}
+
+// isAncestor reports whether ancestor is id or one of its ancestors.
+func isAncestor(parents map[uuid.UUID]uuid.UUID, ancestor, id uuid.UUID) bool {
+	for id != (uuid.UUID{}) {
+		if id == ancestor {
+			return true
+		}
+		id = parents[id]
+	}
+	return false
+}
+
+// checkParent returns an error unless parent is the zero UUID or an item
+// that id could be placed under.
+func (model *PersistedModel) checkParent(parent uuid.UUID, id uuid.UUID) error {
+	if parent == (uuid.UUID{}) {
+		return nil
+	}
+	if model.getItem(parent) == nil {
+		return fmt.Errorf("no parent item with ID %s", parent)
+	}
+	if id != (uuid.UUID{}) && isAncestor(model.parents(), id, parent) {
+		return fmt.Errorf("cannot move item %s under itself", id)
+	}
+	return nil
+}
+
+// walk visits the items depth first, each parent before its children and
+// siblings in order. If visit returns false the item's children are
+// skipped.
+func (model *PersistedModel) walk(visit func(item *PersistedItem, parent uuid.UUID, depth int, children []*PersistedItem) bool) {
+	children := model.children(model.parents())
+	var walk func(parent uuid.UUID, depth int)
+	walk = func(parent uuid.UUID, depth int) {
+		for _, item := range children[parent] {
+			if visit(item, parent, depth, children[item.ID]) {
+				walk(item.ID, depth+1)
+			}
+		}
+	}
+	walk(uuid.UUID{}, 0)
+}
@@ -0,0 +1,251 @@
+package replicatedtodo
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+)
+
+// outline describes a tree as one line per item, indented by depth, with a
+// trailing "+" on items with children.
+func outline(items []TreeItem) []string {
+	var lines []string
+	for _, item := range items {
+		line := strings.Repeat("  ", item.Depth) + item.Title
+		if item.HasChildren {
+			line += " +"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// newTree adds items to list from an outline written as for outline,
+// without the "+" markers, and returns their IDs by title.
+func newTree(t *testing.T, list *ItemList, lines ...string) map[string]uuid.UUID {
+	t.Helper()
+	ids := map[string]uuid.UUID{}
+	// last holds the last item added at each depth.
+	var last []uuid.UUID
+	for _, line := range lines {
+		title := strings.TrimLeft(line, " ")
+		depth := (len(line) - len(title)) / 2
+		last = last[:depth]
+		var parent, previous uuid.UUID
+		if depth > 0 {
+			parent = last[depth-1]
+		}
+		if children := list.replicated.children(list.replicated.parents())[parent]; len(children) > 0 {
+			previous = children[len(children)-1].ID
+		}
+		item, err := list.NewTodoUnder(title, parent, previous)
+		if err != nil {
+			t.Fatalf("NewTodoUnder(%q) error: %s", title, err)
+		}
+		ids[title] = item.ID
+		last = append(last, item.ID)
+	}
+	return ids
+}
+
+func TestTree(t *testing.T) {
+	list := ItemList{}
+	ids := newTree(t, &list,
+		"a",
+		"  a1",
+		"  a2",
+		"    a2x",
+		"b",
+		"  b1",
+	)
+	want := []string{"a +", "  a1", "  a2 +", "    a2x", "b +", "  b1"}
+	if diff := cmp.Diff(want, outline(list.Tree())); diff != "" {
+		t.Errorf("Tree() mismatch (-want, +got):\n%s", diff)
+	}
+	for _, item := range list.Tree() {
+		if item.Title == "a2x" && item.Parent != ids["a2"] {
+			t.Errorf("a2x has parent %s, want a2 %s", item.Parent, ids["a2"])
+		}
+	}
+	wantItems := []string{"a", "a1", "a2", "a2x", "b", "b1"}
+	if diff := cmp.Diff(wantItems, titles(list.Items())); diff != "" {
+		t.Errorf("Items() mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A new sibling of a nested item stays at its level.
+	if _, err := list.NewTodo("a3", ids["a2"]); err != nil {
+		t.Fatalf("NewTodo() error: %s", err)
+	}
+	// Deleting an item hides its children.
+	list.Delete(ids["a2"])
+	want = []string{"a +", "  a1", "  a3", "b +", "  b1"}
+	if diff := cmp.Diff(want, outline(list.Tree())); diff != "" {
+		t.Errorf("Tree() after delete mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"a2"}, titles(list.DeletedItems())); diff != "" {
+		t.Errorf("DeletedItems() mismatch (-want, +got):\n%s", diff)
+	}
+	wantItems = []string{"a", "a1", "a2", "a2x", "a3", "b", "b1"}
+	if diff := cmp.Diff(wantItems, titles(list.AllItems())); diff != "" {
+		t.Errorf("AllItems() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestMoveUnder(t *testing.T) {
+	list := ItemList{}
+	ids := newTree(t, &list,
+		"a",
+		"  a1",
+		"b",
+	)
+	if err := list.MoveUnder(ids["b"], ids["a1"], uuid.UUID{}); err != nil {
+		t.Fatalf("MoveUnder() error: %s", err)
+	}
+	want := []string{"a +", "  a1 +", "    b"}
+	if diff := cmp.Diff(want, outline(list.Tree())); diff != "" {
+		t.Errorf("Tree() mismatch (-want, +got):\n%s", diff)
+	}
+
+	for _, parent := range []string{"a", "a1", "b"} {
+		if err := list.MoveUnder(ids["a"], ids[parent], uuid.UUID{}); err == nil {
+			t.Errorf("MoveUnder(a, %s) succeeded, want an error", parent)
+		}
+	}
+	if err := list.MoveUnder(ids["a"], uuid.New(), uuid.UUID{}); err == nil {
+		t.Errorf("MoveUnder() to an unknown parent succeeded, want an error")
+	}
+
+	// Moving an item next to a nested item makes it that item's sibling.
+	if err := list.Move(ids["a"], ids["b"]); err == nil {
+		t.Errorf("Move(a, b) succeeded, want an error since b is under a")
+	}
+	if err := list.Move(ids["b"], ids["a"]); err != nil {
+		t.Fatalf("Move() error: %s", err)
+	}
+	want = []string{"a +", "  a1", "b"}
+	if diff := cmp.Diff(want, outline(list.Tree())); diff != "" {
+		t.Errorf("Tree() after Move() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestIndentOutdent(t *testing.T) {
+	list := ItemList{}
+	ids := newTree(t, &list,
+		"a",
+		"  a1",
+		"b",
+		"c",
+	)
+	steps := []struct {
+		indent bool
+		item   string
+		want   []string
+	}{
+		{true, "b", []string{"a +", "  a1", "  b", "c"}},
+		{true, "b", []string{"a +", "  a1 +", "    b", "c"}},
+		{true, "a", []string{"a +", "  a1 +", "    b", "c"}},
+		{false, "b", []string{"a +", "  a1", "  b", "c"}},
+		{false, "b", []string{"a +", "  a1", "b", "c"}},
+		{false, "b", []string{"a +", "  a1", "b", "c"}},
+		{true, "c", []string{"a +", "  a1", "b +", "  c"}},
+	}
+	for _, step := range steps {
+		var err error
+		if step.indent {
+			err = list.Indent(ids[step.item])
+		} else {
+			err = list.Outdent(ids[step.item])
+		}
+		if err != nil {
+			t.Fatalf("error indenting %s: %s", step.item, err)
+		}
+		if diff := cmp.Diff(step.want, outline(list.Tree())); diff != "" {
+			t.Errorf("indent=%t %s mismatch (-want, +got):\n%s", step.indent, step.item, diff)
+		}
+	}
+}
+
+func TestConcurrentMovesCannotFormCycle(t *testing.T) {
+	physical := newFakeClock()
+	laptop := ItemList{}
+	laptop.SetClock(NewHybridClock(physical, uuid.New()))
+	ids := newTree(t, &laptop,
+		"a",
+		"b",
+	)
+	desktop := ItemList{}
+	desktop.SetClock(NewHybridClock(physical, uuid.New()))
+	desktop.Merge(&laptop)
+
+	// Each replica's move is fine on its own, but together they would
+	// put a and b under each other.
+	if err := laptop.MoveUnder(ids["a"], ids["b"], uuid.UUID{}); err != nil {
+		t.Fatalf("MoveUnder() error: %s", err)
+	}
+	physical.now = physical.now.Add(time.Second)
+	if err := desktop.MoveUnder(ids["b"], ids["a"], uuid.UUID{}); err != nil {
+		t.Fatalf("MoveUnder() error: %s", err)
+	}
+	laptop.Merge(&desktop)
+	desktop.Merge(&laptop)
+
+	// The desktop moved b last, so b loses its parent and a stays under it.
+	want := []string{"b +", "  a"}
+	if diff := cmp.Diff(want, outline(laptop.Tree())); diff != "" {
+		t.Errorf("laptop tree mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, outline(desktop.Tree())); diff != "" {
+		t.Errorf("desktop tree mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Further moves see the tree as shown.
+	if err := laptop.MoveUnder(ids["b"], ids["a"], uuid.UUID{}); err == nil {
+		t.Errorf("MoveUnder(b, a) succeeded, want an error since a is under b")
+	}
+}
+
+func TestTreeConverges(t *testing.T) {
+	r := rand.New(rand.NewPCG(9, 10))
+	for range 50 {
+		replicas := randomHistory(t, r)
+		forward := merged(replicas...)
+		backward := merged(replicas[2], replicas[1], replicas[0])
+		a, b := forward.Model(), backward.Model()
+		if diff := cmp.Diff(a.Tree(), b.Tree()); diff != "" {
+			t.Fatalf("trees differ by merge order (-forward, +backward):\n%s", diff)
+		}
+		if got, want := len(a.Items())+len(a.DeletedItems()), len(forward.Items); got > want {
+			t.Fatalf("tree has %d items, more than the model's %d", got, want)
+		}
+	}
+}
+
+func TestParentJSON(t *testing.T) {
+	list := ItemList{}
+	ids := newTree(t, &list,
+		"a",
+		"  a1",
+	)
+	data, err := json.Marshal(&list)
+	if err != nil {
+		t.Fatalf("Marshal() error: %s", err)
+	}
+	if n := strings.Count(string(data), `"Parent"`); n != 2 {
+		t.Errorf("JSON has %d parents, want 2, one in the item and one in its operation:\n%s", n, data)
+	}
+	var loaded ItemList
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if diff := cmp.Diff(list.Tree(), loaded.Tree()); diff != "" {
+		t.Errorf("tree after round trip mismatch (-want, +got):\n%s", diff)
+	}
+	if got := loaded.parent(ids["a1"]); got != ids["a"] {
+		t.Errorf("a1 has parent %s after round trip, want %s", got, ids["a"])
+	}
+}
@@ -0,0 +1,53 @@
+package safefile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned by TryLockFile when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an advisory lock held on a file, which other processes taking
+// the same lock wait for. It does not stop processes that do not take it
+// from reading or writing.
+type Lock struct {
+	f *os.File
+}
+
+// LockFile takes an exclusive lock on the file at path, creating the file
+// if it does not exist, and waits until any other process holding the lock
+// releases it. The lock file is left in place after Unlock, since removing
+// it would let two processes lock different files of the same name.
+func LockFile(path string) (*Lock, error) {
+	return lockFile(path, lock)
+}
+
+// TryLockFile takes an exclusive lock on the file at path like LockFile,
+// but returns an error wrapping ErrLocked instead of waiting if another
+// process holds the lock.
+func TryLockFile(path string) (*Lock, error) {
+	return lockFile(path, tryLock)
+}
+
+func lockFile(path string, lock func(f *os.File) error) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if err := lock(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if err := unlock(l.f); err != nil {
+		_ = l.f.Close()
+		return fmt.Errorf("failed to unlock %s: %w", l.f.Name(), err)
+	}
+	return l.f.Close()
+}
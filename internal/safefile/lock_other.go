@@ -0,0 +1,23 @@
+//go:build !unix && !windows
+
+package safefile
+
+import (
+	"errors"
+	"os"
+)
+
+// Other systems get no locking. Taking a lock fails rather than pretending
+// to succeed, since callers rely on it to keep processes apart.
+
+func lock(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func tryLock(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func unlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,67 @@
+//go:build unix || windows
+
+package safefile
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFileExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.yaml.lock")
+	first, err := LockFile(path)
+	if err != nil {
+		t.Fatalf("LockFile() error: %s", err)
+	}
+
+	locked := make(chan *Lock)
+	go func() {
+		second, err := LockFile(path)
+		if err != nil {
+			t.Errorf("second LockFile() error: %s", err)
+		}
+		locked <- second
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("second LockFile() returned while the first lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock() error: %s", err)
+	}
+	select {
+	case second := <-locked:
+		if second != nil {
+			if err := second.Unlock(); err != nil {
+				t.Errorf("second Unlock() error: %s", err)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second LockFile() still waiting after Unlock()")
+	}
+}
+
+func TestTryLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replica.lock")
+	first, err := TryLockFile(path)
+	if err != nil {
+		t.Fatalf("TryLockFile() error: %s", err)
+	}
+	if _, err := TryLockFile(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("TryLockFile() while locked error = %v, want ErrLocked", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatalf("Unlock() error: %s", err)
+	}
+	second, err := TryLockFile(path)
+	if err != nil {
+		t.Fatalf("TryLockFile() after Unlock() error: %s", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Errorf("second Unlock() error: %s", err)
+	}
+}
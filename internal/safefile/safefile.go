@@ -0,0 +1,227 @@
+// Package safefile saves files so that a crash, a full disk or any other
+// failure part way through leaves them holding either their old contents or
+// their new ones, never a mixture or nothing, and keeps timestamped backups
+// of their earlier contents to roll back to.
+package safefile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tempFile is the part of *os.File that WriteFile uses.
+type tempFile interface {
+	io.Writer
+	Name() string
+	Chmod(mode os.FileMode) error
+	Sync() error
+	Close() error
+}
+
+// The file system operations WriteFile uses, replaced in tests to inject
+// failures.
+var (
+	createTemp = func(dir, pattern string) (tempFile, error) {
+		return os.CreateTemp(dir, pattern)
+	}
+	rename = os.Rename
+)
+
+// WriteFile writes data to a temporary file in the same directory as path,
+// flushes it to disk and renames it over path, so that path is replaced
+// atomically. If anything fails, path is left as it was and the temporary
+// file is removed.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	// The leading dot and trailing suffix keep the temporary file from
+	// matching patterns such as "*.yaml".
+	tmp, err := createTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err = rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a rename in dir to disk. Not every system can sync a
+// directory, and the rename has happened either way, so errors are
+// ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// backupTimeFormat names backups. It sorts the same as the times it
+// formats, in UTC.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// File is a file saved with WriteFile that keeps backups of its earlier
+// contents in a directory next to it.
+type File struct {
+	path  string
+	keep  int
+	every time.Duration
+	now   func() time.Time
+}
+
+// Backup is an earlier version of a File.
+type Backup struct {
+	// Path is where the backup is kept.
+	Path string
+	// Time is when the backup was replaced.
+	Time time.Time
+}
+
+// New returns the file at path, keeping the keep most recent backups,
+// taken at most once every interval so that frequent saves do not push
+// out every backup older than a few minutes. A zero interval backs up
+// every save.
+func New(path string, keep int, every time.Duration) *File {
+	return &File{path: path, keep: keep, every: every, now: time.Now}
+}
+
+// Path returns the file's path.
+func (f *File) Path() string {
+	return f.path
+}
+
+// BackupDir returns the directory backups are kept in, path with
+// ".backups" appended.
+func (f *File) BackupDir() string {
+	return f.path + ".backups"
+}
+
+// Save replaces the file's contents with data. The old contents, if any,
+// are first kept as a backup unless they match the latest backup or it was
+// taken less than the interval ago, and the oldest backups are then
+// removed so no more than the limit remain. If Save fails, the file is
+// left as it was.
+func (f *File) Save(data []byte) error {
+	return f.save(data, f.every)
+}
+
+func (f *File) save(data []byte, every time.Duration) error {
+	if err := f.backUp(every); err != nil {
+		return err
+	}
+	if err := WriteFile(f.path, data, 0o600); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// backUp copies the file's contents to a new backup, unless the latest
+// backup was taken less than every ago.
+func (f *File) backUp(every time.Duration) error {
+	current, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to back up %s: %w", f.path, err)
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 {
+		if every > 0 && f.now().Sub(backups[0].Time) < every {
+			return nil
+		}
+		latest, err := os.ReadFile(backups[0].Path)
+		if err == nil && bytes.Equal(latest, current) {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(f.BackupDir(), 0o700); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", f.path, err)
+	}
+	name := f.now().UTC().Format(backupTimeFormat) + filepath.Ext(f.path)
+	if err := WriteFile(filepath.Join(f.BackupDir(), name), current, 0o600); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// prune removes all but the newest backups.
+func (f *File) prune() error {
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups[min(f.keep, len(backups)):] {
+		if err := os.Remove(b.Path); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// Backups returns the file's backups, newest first.
+func (f *File) Backups() ([]Backup, error) {
+	entries, err := os.ReadDir(f.BackupDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []Backup
+	for _, entry := range entries {
+		stamp := strings.TrimSuffix(entry.Name(), filepath.Ext(f.path))
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil || !entry.Type().IsRegular() {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(f.BackupDir(), entry.Name()), Time: t})
+	}
+	slices.SortFunc(backups, func(a, b Backup) int {
+		return b.Time.Compare(a.Time)
+	})
+	return backups, nil
+}
+
+// Restore replaces the file's contents with those of backup. The contents
+// it replaces are backed up first, however recent the latest backup, so a
+// restore can itself be undone.
+func (f *File) Restore(backup Backup) error {
+	data, err := os.ReadFile(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	return f.save(data, 0)
+}
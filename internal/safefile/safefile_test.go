@@ -0,0 +1,298 @@
+package safefile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failingFile wraps a temporary file, failing the operation named by
+// failAt. A failing write writes half the data first, as a full disk
+// would.
+type failingFile struct {
+	*os.File
+	failAt string
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.failAt == "write" {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errInjected
+	}
+	return f.File.Write(p)
+}
+
+func (f *failingFile) Chmod(mode os.FileMode) error {
+	if f.failAt == "chmod" {
+		return errInjected
+	}
+	return f.File.Chmod(mode)
+}
+
+func (f *failingFile) Sync() error {
+	if f.failAt == "sync" {
+		return errInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *failingFile) Close() error {
+	err := f.File.Close()
+	if f.failAt == "close" {
+		return errInjected
+	}
+	return err
+}
+
+// injectFailure makes WriteFile fail at the named step until the test
+// ends.
+func injectFailure(t *testing.T, failAt string) {
+	savedCreateTemp, savedRename := createTemp, rename
+	t.Cleanup(func() {
+		createTemp, rename = savedCreateTemp, savedRename
+	})
+	createTemp = func(dir, pattern string) (tempFile, error) {
+		if failAt == "create" {
+			return nil, errInjected
+		}
+		f, err := os.CreateTemp(dir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return &failingFile{File: f, failAt: failAt}, nil
+	}
+	rename = func(oldpath, newpath string) error {
+		if failAt == "rename" {
+			return errInjected
+		}
+		return os.Rename(oldpath, newpath)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// dirNames returns the names of the files in dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "list.yaml")
+	for _, contents := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("WriteFile(%q) error: %s", contents, err)
+		}
+		if got := readFile(t, path); got != contents {
+			t.Errorf("contents = %q, want %q", got, contents)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o600 {
+		t.Errorf("permissions = %v, want %v", got, os.FileMode(0o600))
+	}
+	if diff := cmp.Diff([]string{"list.yaml"}, dirNames(t, dir)); diff != "" {
+		t.Errorf("files mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteFileFailureKeepsOriginal(t *testing.T) {
+	for _, failAt := range []string{"create", "write", "chmod", "sync", "close", "rename"} {
+		t.Run(failAt, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "list.yaml")
+			if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			injectFailure(t, failAt)
+
+			if err := WriteFile(path, []byte("replacement"), 0o600); !errors.Is(err, errInjected) {
+				t.Errorf("WriteFile() error = %v, want the injected failure", err)
+			}
+			if got := readFile(t, path); got != "original" {
+				t.Errorf("contents after failure = %q, want %q", got, "original")
+			}
+			if diff := cmp.Diff([]string{"list.yaml"}, dirNames(t, dir)); diff != "" {
+				t.Errorf("files after failure mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// newTestFile returns a File in a temporary directory, backing up every
+// save, whose clock advances a second every time it is read.
+func newTestFile(t *testing.T, keep int) *File {
+	f := New(filepath.Join(t.TempDir(), "list.yaml"), keep, 0)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return f
+}
+
+// backupContents returns the contents of f's backups, newest first.
+func backupContents(t *testing.T, f *File) []string {
+	t.Helper()
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error: %s", err)
+	}
+	var contents []string
+	for _, b := range backups {
+		contents = append(contents, readFile(t, b.Path))
+	}
+	return contents
+}
+
+func TestSaveKeepsBackups(t *testing.T) {
+	f := newTestFile(t, 3)
+	for _, contents := range []string{"v1", "v2", "v3", "v4", "v5", "v6"} {
+		if err := f.Save([]byte(contents)); err != nil {
+			t.Fatalf("Save(%q) error: %s", contents, err)
+		}
+	}
+	if got := readFile(t, f.Path()); got != "v6" {
+		t.Errorf("contents = %q, want %q", got, "v6")
+	}
+	if diff := cmp.Diff([]string{"v5", "v4", "v3"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups mismatch (-want, +got):\n%s", diff)
+	}
+
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 6, 1, 12, 0, 5, 0, time.UTC)
+	if !backups[0].Time.Equal(want) {
+		t.Errorf("newest backup time = %s, want %s", backups[0].Time, want)
+	}
+}
+
+func TestSaveSkipsDuplicateBackups(t *testing.T) {
+	f := newTestFile(t, 3)
+	for _, contents := range []string{"v1", "v2", "v2", "v2"} {
+		if err := f.Save([]byte(contents)); err != nil {
+			t.Fatalf("Save(%q) error: %s", contents, err)
+		}
+	}
+	if diff := cmp.Diff([]string{"v2", "v1"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSaveBacksUpOncePerInterval(t *testing.T) {
+	f := newTestFile(t, 3)
+	f.every = 5 * time.Second
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	for _, contents := range []string{"v1", "v2", "v3", "v4", "v5", "v6"} {
+		if err := f.Save([]byte(contents)); err != nil {
+			t.Fatalf("Save(%q) error: %s", contents, err)
+		}
+		now = now.Add(2 * time.Second)
+	}
+	if diff := cmp.Diff([]string{"v4", "v1"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A restore backs up what it replaces regardless.
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Restore(backups[1]); err != nil {
+		t.Fatalf("Restore() error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"v6", "v4", "v1"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups after Restore() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSaveFailureKeepsOriginal(t *testing.T) {
+	// WriteFile writes the backup and then the file; failing at any step
+	// of either leaves the file as it was.
+	for _, failAt := range []string{"create", "write", "sync", "rename"} {
+		t.Run(failAt, func(t *testing.T) {
+			f := newTestFile(t, 3)
+			if err := f.Save([]byte("original")); err != nil {
+				t.Fatal(err)
+			}
+			injectFailure(t, failAt)
+
+			if err := f.Save([]byte("replacement")); !errors.Is(err, errInjected) {
+				t.Errorf("Save() error = %v, want the injected failure", err)
+			}
+			if got := readFile(t, f.Path()); got != "original" {
+				t.Errorf("contents after failure = %q, want %q", got, "original")
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	f := newTestFile(t, 5)
+	for _, contents := range []string{"v1", "v2", "v3"} {
+		if err := f.Save([]byte(contents)); err != nil {
+			t.Fatalf("Save(%q) error: %s", contents, err)
+		}
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Restore(backups[1]); err != nil {
+		t.Fatalf("Restore() error: %s", err)
+	}
+	if got := readFile(t, f.Path()); got != "v1" {
+		t.Errorf("contents after Restore() = %q, want %q", got, "v1")
+	}
+	// The restored-over contents are kept, so the restore can be undone.
+	if diff := cmp.Diff([]string{"v3", "v2", "v1"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups after Restore() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBackupsIgnoresOtherFiles(t *testing.T) {
+	f := newTestFile(t, 3)
+	if err := f.Save([]byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if backups, err := f.Backups(); err != nil || len(backups) != 0 {
+		t.Errorf("Backups() before any backup = %v, %v, want none", backups, err)
+	}
+	if err := f.Save([]byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(f.BackupDir(), "notes.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"v1"}, backupContents(t, f)); diff != "" {
+		t.Errorf("backups mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltTimeout is how long to wait for another process to close the
+// database.
+const boltTimeout = 10 * time.Second
+
+// The buckets of a Bolt database are named after the fields of the
+// serialized list. An object field, such as Items, is kept in an
+// "object:" bucket with a key for each of its members; an array field,
+// such as Log, in an "array:" bucket keyed by index; and any other field
+// in the fields bucket under its own name.
+const (
+	objectPrefix = "object:"
+	arrayPrefix  = "array:"
+	fieldsBucket = "fields"
+)
+
+// Bolt keeps a list in a bbolt database, each item, replica and logged
+// operation under its own key, so that a save writes only what changed.
+// Each save is a single transaction, flushed to disk before it returns.
+//
+// The database is open only while loading or saving, so other processes
+// can use it in between.
+type Bolt struct {
+	path string
+	watcher
+}
+
+// NewBolt returns a store keeping a list in the database at path.
+func NewBolt(path string) *Bolt {
+	return &Bolt{path: path}
+}
+
+// Path returns the path of the database.
+func (b *Bolt) Path() string {
+	return b.path
+}
+
+func (b *Bolt) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(b.path, 0o600, &bolt.Options{Timeout: boltTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", b.path, err)
+	}
+	return db, nil
+}
+
+func (b *Bolt) Load() (replicatedtodo.ItemList, error) {
+	var list replicatedtodo.ItemList
+	if _, err := os.Stat(b.path); err != nil {
+		return list, fmt.Errorf("failed to open %s: %w", b.path, err)
+	}
+	db, err := b.open(true)
+	if err != nil {
+		return list, err
+	}
+	defer db.Close()
+
+	doc := make(map[string]json.RawMessage)
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			var err error
+			switch name := string(name); {
+			case name == fieldsBucket:
+				_ = bucket.ForEach(func(k, v []byte) error {
+					doc[string(k)] = bytes.Clone(v)
+					return nil
+				})
+			case strings.HasPrefix(name, objectPrefix):
+				members := make(map[string]json.RawMessage)
+				_ = bucket.ForEach(func(k, v []byte) error {
+					members[string(k)] = bytes.Clone(v)
+					return nil
+				})
+				doc[strings.TrimPrefix(name, objectPrefix)], err = json.Marshal(members)
+			case strings.HasPrefix(name, arrayPrefix):
+				// Keys are big endian indexes, so they iterate in order.
+				elements := []json.RawMessage{}
+				_ = bucket.ForEach(func(_, v []byte) error {
+					elements = append(elements, bytes.Clone(v))
+					return nil
+				})
+				doc[strings.TrimPrefix(name, arrayPrefix)], err = json.Marshal(elements)
+			default:
+				err = fmt.Errorf("unexpected bucket %q", name)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return list, fmt.Errorf("failed to read %s: %w", b.path, err)
+	}
+	if len(doc) == 0 {
+		return list, fmt.Errorf("%s holds no list: %w", b.path, fs.ErrNotExist)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return list, err
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return list, fmt.Errorf("failed to unmarshal %s: %w", b.path, err)
+	}
+	return list, nil
+}
+
+// buckets splits a serialized list into the contents of its buckets.
+func buckets(list *replicatedtodo.ItemList) (map[string]map[string][]byte, error) {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal model: %w", err)
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	result := map[string]map[string][]byte{fieldsBucket: {}}
+	for field, value := range doc {
+		var members map[string]json.RawMessage
+		var elements []json.RawMessage
+		switch {
+		case bytes.HasPrefix(value, []byte("{")) && json.Unmarshal(value, &members) == nil:
+			contents := make(map[string][]byte, len(members))
+			for k, v := range members {
+				contents[k] = v
+			}
+			result[objectPrefix+field] = contents
+		case bytes.HasPrefix(value, []byte("[")) && json.Unmarshal(value, &elements) == nil:
+			contents := make(map[string][]byte, len(elements))
+			for i, v := range elements {
+				contents[string(binary.BigEndian.AppendUint64(nil, uint64(i)))] = v
+			}
+			result[arrayPrefix+field] = contents
+		default:
+			result[fieldsBucket][field] = value
+		}
+	}
+	return result, nil
+}
+
+func (b *Bolt) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	want, err := buckets(list)
+	if err != nil {
+		return err
+	}
+	db, err := b.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		var stale [][]byte
+		_ = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if _, ok := want[string(name)]; !ok {
+				stale = append(stale, bytes.Clone(name))
+			}
+			return nil
+		})
+		for _, name := range stale {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		for name, contents := range want {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := update(bucket, contents); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save model to %s: %w", b.path, err)
+	}
+	return nil
+}
+
+// update makes bucket hold contents, writing only the keys that differ.
+func update(bucket *bolt.Bucket, contents map[string][]byte) error {
+	var stale [][]byte
+	_ = bucket.ForEach(func(k, _ []byte) error {
+		if _, ok := contents[string(k)]; !ok {
+			stale = append(stale, bytes.Clone(k))
+		}
+		return nil
+	})
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	for k, v := range contents {
+		if bytes.Equal(bucket.Get([]byte(k)), v) {
+			continue
+		}
+		if err := bucket.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Watch(changed func()) error {
+	return b.watch(b.path, changed)
+}
+
+func (b *Bolt) Close() error {
+	b.close()
+	return nil
+}
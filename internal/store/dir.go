@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/dirsync"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Dir keeps a list in a directory shared between machines by a file sync
+// tool, as a file for each replica; see package dirsync.
+type Dir struct {
+	dir     string
+	replica uuid.UUID
+	watcher
+}
+
+// NewDir returns a store keeping the list of replica in the shared
+// directory dir.
+func NewDir(dir string, replica uuid.UUID) *Dir {
+	return &Dir{dir: dir, replica: replica}
+}
+
+// Joined reports whether the replica has saved its own file to the
+// directory yet.
+func (d *Dir) Joined() bool {
+	_, err := os.Stat(dirsync.ReplicaFile(d.dir, d.replica))
+	return err == nil
+}
+
+func (d *Dir) Load() (replicatedtodo.ItemList, error) {
+	list, err := dirsync.Load(d.dir, d.replica)
+	if err != nil {
+		return list, fmt.Errorf("failed to load sync directory: %w", err)
+	}
+	return list, nil
+}
+
+func (d *Dir) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	return dirsync.Save(d.dir, d.replica, list)
+}
+
+func (d *Dir) Watch(changed func()) error {
+	return d.watch(filepath.Join(d.dir, "*.yaml"), changed)
+}
+
+func (d *Dir) Close() error {
+	d.close()
+	return nil
+}
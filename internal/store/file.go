@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/safefile"
+	"github.com/matta/sift/internal/store/listfile"
+)
+
+// Format is how a File encodes its list.
+type Format = listfile.Format
+
+var (
+	// YAML is the format sift has always saved its data file in.
+	YAML = listfile.YAML
+	// JSON is quicker to read and write than YAML.
+	JSON = listfile.JSON
+)
+
+// File keeps a list in a single file, replaced atomically on every save
+// and backed up first.
+type File struct {
+	file   *safefile.File
+	format Format
+	watcher
+}
+
+// NewFile returns a store keeping a list in the file at path, in format,
+// with the given number of backups of its earlier versions taken at most
+// once every interval.
+func NewFile(path string, format Format, backups int, every time.Duration) *File {
+	return &File{file: safefile.New(path, backups, every), format: format}
+}
+
+// Path returns the path of the file.
+func (f *File) Path() string {
+	return f.file.Path()
+}
+
+// Read reads a list in the store's format from path, such as one of its
+// backups.
+func (f *File) Read(path string) (replicatedtodo.ItemList, error) {
+	return listfile.Read(path, f.format)
+}
+
+func (f *File) Load() (replicatedtodo.ItemList, error) {
+	return f.Read(f.Path())
+}
+
+func (f *File) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	data, err := f.format.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal model: %w", err)
+	}
+	if err = f.file.Save(data); err != nil {
+		return fmt.Errorf("failed to save model: %w", err)
+	}
+	return nil
+}
+
+func (f *File) Watch(changed func()) error {
+	return f.watch(f.Path(), changed)
+}
+
+func (f *File) Close() error {
+	f.close()
+	return nil
+}
+
+// Backups returns the backups of the file, newest first.
+func (f *File) Backups() ([]safefile.Backup, error) {
+	return f.file.Backups()
+}
+
+// Restore replaces the file with one of its backups, backing up what it
+// replaces.
+func (f *File) Restore(backup safefile.Backup) error {
+	return f.file.Restore(backup)
+}
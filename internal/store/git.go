@@ -0,0 +1,42 @@
+package store
+
+import (
+	"github.com/matta/sift/internal/gitstore"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Git keeps a list in a git repository, committing every save; see package
+// gitstore.
+type Git struct {
+	repo    *gitstore.Repo
+	message string
+	watcher
+}
+
+// NewGit returns a store keeping a list in repo, committing saves with
+// message.
+func NewGit(repo *gitstore.Repo, message string) *Git {
+	return &Git{repo: repo, message: message}
+}
+
+// Repo returns the repository the list is kept in.
+func (g *Git) Repo() *gitstore.Repo {
+	return g.repo
+}
+
+func (g *Git) Load() (replicatedtodo.ItemList, error) {
+	return g.repo.Load()
+}
+
+func (g *Git) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	return g.repo.Save(list, g.message)
+}
+
+func (g *Git) Watch(changed func()) error {
+	return g.watch(g.repo.Path(), changed)
+}
+
+func (g *Git) Close() error {
+	g.close()
+	return nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/matta/sift/internal/journal"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Journal keeps a list as a snapshot and a journal of the changes saved
+// since, appending only what changed on each save; see package journal.
+type Journal struct {
+	journal *journal.Journal
+	watcher
+}
+
+// OpenJournal opens the journal in dir, creating it if necessary and
+// discarding a torn final record.
+func OpenJournal(dir string) (*Journal, error) {
+	j, _, err := journal.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{journal: j}, nil
+}
+
+// Dir returns the directory the journal is kept in.
+func (j *Journal) Dir() string {
+	return j.journal.Dir()
+}
+
+func (j *Journal) Load() (replicatedtodo.ItemList, error) {
+	empty, err := j.empty()
+	if err != nil {
+		return replicatedtodo.ItemList{}, fmt.Errorf("failed to check journal: %w", err)
+	}
+	if empty {
+		return replicatedtodo.ItemList{}, fmt.Errorf("journal in %s is empty: %w", j.Dir(), fs.ErrNotExist)
+	}
+	return journal.Load(j.Dir())
+}
+
+// empty reports whether the journal has neither a snapshot nor records.
+func (j *Journal) empty() (bool, error) {
+	for _, name := range []string{journal.SnapshotFile, journal.JournalFile} {
+		info, err := os.Stat(filepath.Join(j.Dir(), name))
+		switch {
+		case err == nil && info.Size() > 0:
+			return false, nil
+		case err != nil && !errors.Is(err, fs.ErrNotExist):
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (j *Journal) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	return j.journal.Save(list, since)
+}
+
+func (j *Journal) Watch(changed func()) error {
+	return j.watch(filepath.Join(j.Dir(), "*"), changed)
+}
+
+func (j *Journal) Close() error {
+	j.close()
+	return j.journal.Close()
+}
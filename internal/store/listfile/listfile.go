@@ -0,0 +1,67 @@
+// Package listfile reads and writes an item list kept in a single file. It
+// sits below the store package so that the packages stores are built on,
+// which the store package imports, read and write list files the same way.
+package listfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/safefile"
+)
+
+// Format is how a file encodes its list.
+type Format struct {
+	Name      string
+	Marshal   func(v any) ([]byte, error)
+	Unmarshal func(data []byte, v any) error
+}
+
+var (
+	// YAML is the format sift has always saved its data file in.
+	YAML = Format{
+		Name:    "yaml",
+		Marshal: yaml.Marshal,
+		Unmarshal: func(data []byte, v any) error {
+			return yaml.Unmarshal(data, v)
+		},
+	}
+	// JSON is quicker to read and write than YAML.
+	JSON = Format{
+		Name: "json",
+		Marshal: func(v any) ([]byte, error) {
+			return json.MarshalIndent(v, "", "  ")
+		},
+		Unmarshal: json.Unmarshal,
+	}
+)
+
+// Read reads a list in format from the file at path. If there is no file,
+// the error wraps fs.ErrNotExist.
+func Read(path string, format Format) (replicatedtodo.ItemList, error) {
+	var list replicatedtodo.ItemList
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return list, fmt.Errorf("failed to read model file: %w", err)
+	}
+	if err = format.Unmarshal(data, &list); err != nil {
+		return list, fmt.Errorf("failed to unmarshal model file %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// Write replaces the file at path with list in format, atomically so that
+// no reader sees it partially written.
+func Write(path string, list *replicatedtodo.ItemList, format Format) error {
+	data, err := format.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal model: %w", err)
+	}
+	if err = safefile.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save model: %w", err)
+	}
+	return nil
+}
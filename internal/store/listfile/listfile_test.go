@@ -0,0 +1,38 @@
+package listfile
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+func TestWriteRead(t *testing.T) {
+	for _, format := range []Format{YAML, JSON} {
+		t.Run(format.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "list."+format.Name)
+			if _, err := Read(path, format); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Read() of a missing file error = %v, want fs.ErrNotExist", err)
+			}
+
+			var list replicatedtodo.ItemList
+			if _, err := list.NewTodo("todo", uuid.UUID{}); err != nil {
+				t.Fatalf("NewTodo() error: %s", err)
+			}
+			if err := Write(path, &list, format); err != nil {
+				t.Fatalf("Write() error: %s", err)
+			}
+			got, err := Read(path, format)
+			if err != nil {
+				t.Fatalf("Read() error: %s", err)
+			}
+			if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+				t.Errorf("Items() after reading mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
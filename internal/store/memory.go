@@ -0,0 +1,67 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Memory keeps a list in memory, for tests. Loading returns a copy of what
+// was saved, so later changes to the saved list do not show through.
+type Memory struct {
+	mu       sync.Mutex
+	data     []byte
+	watchers []func()
+}
+
+// NewMemory returns an empty memory store.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Load() (replicatedtodo.ItemList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list replicatedtodo.ItemList
+	if m.data == nil {
+		return list, fmt.Errorf("memory store is empty: %w", fs.ErrNotExist)
+	}
+	if err := json.Unmarshal(m.data, &list); err != nil {
+		return list, fmt.Errorf("failed to unmarshal model: %w", err)
+	}
+	return list, nil
+}
+
+func (m *Memory) Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to marshal model: %w", err)
+	}
+	m.mu.Lock()
+	m.data = data
+	watchers := m.watchers
+	m.mu.Unlock()
+	for _, changed := range watchers {
+		go changed()
+	}
+	return nil
+}
+
+// Watch calls changed after every save. Unlike other stores, a memory
+// store may be watched more than once.
+func (m *Memory) Watch(changed func()) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers = append(m.watchers, changed)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers = nil
+	return nil
+}
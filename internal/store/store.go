@@ -0,0 +1,66 @@
+// Package store defines where sift keeps an item list and provides the
+// places it can keep one: a YAML or JSON file, a bbolt database, a journal,
+// a directory shared by a file sync tool, a git repository, or memory.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/matta/sift/internal/filewatch"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+// Store keeps an item list.
+//
+// Stores do not lock against other processes. Processes sharing a store
+// take turns to open, load and save it, as sift does by holding its lock
+// file.
+type Store interface {
+	// Load returns the list as it is stored now. If the store holds no
+	// list yet, the error wraps fs.ErrNotExist.
+	Load() (replicatedtodo.ItemList, error)
+
+	// Save stores list. since is the version vector of the list as it was
+	// last loaded or saved, which stores that save only what changed use
+	// to find it.
+	Save(list *replicatedtodo.ItemList, since replicatedtodo.VersionVector) error
+
+	// Watch calls changed from another goroutine whenever the stored list
+	// may have changed, including by this store's own saves, until the
+	// store is closed. It may be called once.
+	Watch(changed func()) error
+
+	// Close releases what the store holds open and stops watching it.
+	Close() error
+}
+
+// watchInterval is how often stores backed by files check them for
+// changes where the system cannot report changes to them.
+const watchInterval = time.Second
+
+// watcher watches the files a store keeps its list in.
+type watcher struct {
+	w *filewatch.Watcher
+}
+
+// watch calls changed when the files matching pattern change.
+func (w *watcher) watch(pattern string, changed func()) error {
+	if w.w != nil {
+		return errors.New("store is already watched")
+	}
+	fw, err := filewatch.Watch(pattern, watchInterval, changed)
+	if err != nil {
+		return err
+	}
+	w.w = fw
+	return nil
+}
+
+// close stops watching.
+func (w *watcher) close() {
+	if w.w != nil {
+		w.w.Close()
+		w.w = nil
+	}
+}
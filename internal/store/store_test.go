@@ -0,0 +1,221 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/gitstore"
+	"github.com/matta/sift/internal/replicatedtodo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// opener opens a store in a place shared by every store it opens, as two
+// sift processes would.
+type opener func(t *testing.T) Store
+
+// openers returns an opener for each kind of store, each with a place of
+// its own.
+func openers(t *testing.T) map[string]opener {
+	dir := t.TempDir()
+	memory := NewMemory()
+	replica := uuid.New()
+	openers := map[string]opener{
+		"yaml": func(t *testing.T) Store {
+			return NewFile(filepath.Join(dir, "list.yaml"), YAML, 3, 0)
+		},
+		"json": func(t *testing.T) Store {
+			return NewFile(filepath.Join(dir, "list.json"), JSON, 3, 0)
+		},
+		"bolt": func(t *testing.T) Store {
+			return NewBolt(filepath.Join(dir, "list.db"))
+		},
+		"journal": func(t *testing.T) Store {
+			j, err := OpenJournal(filepath.Join(dir, "journal"))
+			if err != nil {
+				t.Fatalf("OpenJournal() error: %s", err)
+			}
+			return j
+		},
+		"dir": func(t *testing.T) Store {
+			return NewDir(filepath.Join(dir, "shared"), replica)
+		},
+		"memory": func(t *testing.T) Store {
+			return memory
+		},
+	}
+	if _, err := exec.LookPath("git"); err == nil {
+		openers["git"] = func(t *testing.T) Store {
+			repo, err := gitstore.Open(filepath.Join(dir, "repo"), "true")
+			if err != nil {
+				t.Fatalf("gitstore.Open() error: %s", err)
+			}
+			return NewGit(repo, "test")
+		}
+	}
+	return openers
+}
+
+// forEachStore runs test with each kind of store.
+func forEachStore(t *testing.T, test func(t *testing.T, open opener)) {
+	for name, open := range openers(t) {
+		t.Run(name, func(t *testing.T) {
+			test(t, func(t *testing.T) Store {
+				s := open(t)
+				t.Cleanup(func() { _ = s.Close() })
+				return s
+			})
+		})
+	}
+}
+
+func newList(t *testing.T, titles ...string) *replicatedtodo.ItemList {
+	t.Helper()
+	list := &replicatedtodo.ItemList{}
+	list.SetReplica(uuid.New(), "test")
+	if _, err := list.NewTodos(titles, uuid.UUID{}); err != nil {
+		t.Fatalf("NewTodos() error: %s", err)
+	}
+	return list
+}
+
+func load(t *testing.T, s Store) replicatedtodo.ItemList {
+	t.Helper()
+	list, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %s", err)
+	}
+	return list
+}
+
+func TestLoadEmpty(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open opener) {
+		if _, err := open(t).Load(); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Load() of an empty store error = %v, want fs.ErrNotExist", err)
+		}
+	})
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open opener) {
+		s := open(t)
+		list := newList(t, "one", "two", "three")
+		if err := s.Save(list, nil); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		got := load(t, open(t))
+		if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+			t.Errorf("Load() mismatch (-want, +got):\n%s", diff)
+		}
+
+		// Change, add and delete items, and save only what changed.
+		saved := list.VersionVector()
+		items := list.Items()
+		list.SetTitle(items[0].ID, "edited")
+		list.ToggleDone(items[1].ID)
+		list.Delete(items[2].ID)
+		if _, err := list.NewTodo("four", items[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Save(list, saved); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		got = load(t, open(t))
+		if diff := cmp.Diff(list.Items(), got.Items()); diff != "" {
+			t.Errorf("Load() after changes mismatch (-want, +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(list.DeletedItems(), got.DeletedItems()); diff != "" {
+			t.Errorf("DeletedItems() after changes mismatch (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, open opener) {
+		watched := open(t)
+		changes := make(chan struct{}, 10)
+		if err := watched.Watch(func() { changes <- struct{}{} }); err != nil {
+			t.Fatalf("Watch() error: %s", err)
+		}
+		if err := open(t).Save(newList(t, "one"), nil); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		select {
+		case <-changes:
+		case <-time.After(10 * watchInterval):
+			t.Fatal("no change reported after another store saved")
+		}
+	})
+}
+
+func TestFileBackups(t *testing.T) {
+	s := NewFile(filepath.Join(t.TempDir(), "list.json"), JSON, 3, 0)
+	first := newList(t, "one")
+	if err := s.Save(first, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(newList(t, "two"), nil); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := s.Backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Backups() = %v, %v, want one backup", backups, err)
+	}
+	backup, err := s.Read(backups[0].Path)
+	if err != nil {
+		t.Fatalf("Read() of backup error: %s", err)
+	}
+	if diff := cmp.Diff(first.Items(), backup.Items()); diff != "" {
+		t.Errorf("backup mismatch (-want, +got):\n%s", diff)
+	}
+	if err := s.Restore(backups[0]); err != nil {
+		t.Fatalf("Restore() error: %s", err)
+	}
+	got := load(t, s)
+	if diff := cmp.Diff(first.Items(), got.Items()); diff != "" {
+		t.Errorf("Load() after Restore() mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestBoltKeepsItemsUnderOwnKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.db")
+	list := newList(t, "one", "two")
+	if err := NewBolt(path).Save(list, nil); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var keys []string
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(objectPrefix + "Items")).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, item := range list.Items() {
+		want = append(want, item.ID.String())
+	}
+	if diff := cmp.Diff(want, keys, cmpSorted); diff != "" {
+		t.Errorf("keys of the items bucket mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+var cmpSorted = cmp.Transformer("sorted", func(s []string) []string {
+	sorted := append([]string(nil), s...)
+	slices.Sort(sorted)
+	return sorted
+})
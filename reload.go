@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// reloadEvent tells the event loop the saved list has changed.
+type reloadEvent struct {
+	tcell.EventTime
+}
+
+// MergeStored merges the list as it is saved now into m, picking up
+// changes other processes saved since m was loaded. The merged changes
+// count as saved; m's own unsaved changes stay unsaved, even if the stored
+// list holds later writes by m's replica, as when a process from before
+// claimReplica saved as it.
+func (m *listModel) MergeStored() error {
+	stored, err := m.store.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	storedVV := stored.VersionVector()
+	if !includesVector(m.items.VersionVector(), storedVV) {
+		m.items.MergeStored(&stored)
+	}
+	delete(storedVV, m.identity.ID)
+	storedVV.Merge(m.saved)
+	m.saved = storedVV
+	return nil
+}
+
+// watchStored posts a reloadEvent to the screen whenever the saved list
+// changes.
+func (m *listModel) watchStored(s tcell.Screen) error {
+	return m.store.Watch(func() {
+		ev := &reloadEvent{}
+		ev.SetEventNow()
+		_ = s.PostEvent(ev)
+	})
+}
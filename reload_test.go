@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/store"
+)
+
+// openTestModel returns a list model writing as identity to st, loaded
+// from it the way LoadModel does.
+func openTestModel(t *testing.T, st store.Store, identity replicaIdentity) *listModel {
+	t.Helper()
+	m := NewModel()
+	m.identity = identity
+	m.store = st
+	if items, err := st.Load(); err == nil {
+		m.items = items
+		m.markSaved()
+	}
+	m.items.SetReplica(identity.ID, identity.Name)
+	return &m
+}
+
+func TestMergeStored(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	laptop := replicaIdentity{ID: uuid.New(), Name: "laptop"}
+	st := store.NewMemory()
+	first := openTestModel(t, st, laptop)
+	first.newTodo("todo")
+	if err := first.Save(); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	id := first.items.Items()[0].ID
+
+	t.Run("another replica's changes count as saved", func(t *testing.T) {
+		other := openTestModel(t, st, replicaIdentity{ID: uuid.New(), Name: "other"})
+		other.items.SetTitle(id, "from the other replica")
+		if err := other.Save(); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		if err := first.MergeStored(); err != nil {
+			t.Fatalf("MergeStored() error: %s", err)
+		}
+		if got := first.items.Items()[0].Title; got != "from the other replica" {
+			t.Errorf("title after MergeStored() = %q, want the other replica's", got)
+		}
+		if first.Dirty() {
+			t.Errorf("Dirty() after merging saved changes = true, want false")
+		}
+	})
+
+	t.Run("own unsaved changes stay unsaved", func(t *testing.T) {
+		// A second process writing as the same replica edits after first
+		// does, and saves first.
+		second := openTestModel(t, st, laptop)
+		first.items.SetNotes(id, "unsaved notes")
+		second.items.SetTitle(id, "from the second process")
+		if err := second.Save(); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		if err := first.MergeStored(); err != nil {
+			t.Fatalf("MergeStored() error: %s", err)
+		}
+		if !first.Dirty() {
+			t.Fatalf("Dirty() after merging a later save of the same replica = false, want true")
+		}
+		if err := first.Save(); err != nil {
+			t.Fatalf("Save() error: %s", err)
+		}
+		saved, err := st.Load()
+		if err != nil {
+			t.Fatalf("Load() error: %s", err)
+		}
+		if got := saved.Items()[0].Notes; got != "unsaved notes" {
+			t.Errorf("saved notes = %q, want %q", got, "unsaved notes")
+		}
+	})
+}
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/ghodss/yaml"
 	"github.com/google/uuid"
+	"github.com/matta/sift/internal/gitstore"
 	"github.com/matta/sift/internal/loghelp"
 	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/safefile"
+	"github.com/matta/sift/internal/store"
+	"github.com/matta/sift/internal/store/listfile"
 )
 
 type position struct {
@@ -55,6 +67,21 @@ type listModel struct {
 	items    replicatedtodo.ItemList
 	cursor   *uuid.UUID
 	quit     bool
+	// collapsed holds the items whose children are hidden.
+	collapsed map[uuid.UUID]bool
+
+	identity replicaIdentity
+	// replicaLock is held while the list writes as identity, so no other
+	// process writes as the same replica.
+	replicaLock *safefile.Lock
+	// store is where the list is loaded from and saved to.
+	store store.Store
+
+	// saved is the version vector of the list when it was last saved or
+	// loaded, for telling whether it has changed since.
+	saved replicatedtodo.VersionVector
+	// saveErr is why the last save failed, or nil if it succeeded.
+	saveErr error
 }
 
 func (m *listModel) addSampleItems() {
@@ -71,57 +98,476 @@ func (m *listModel) Update(screen tcell.Screen, event tcell.Event) model {
 			(event.Key() == tcell.KeyRune && event.Rune() == 'q'):
 			m.quit = true
 		case event.Key() == tcell.KeyRune && event.Rune() == 'k':
-			// if m.persisted.Cursor > 0 {
-			// 	m.persisted.Cursor--
-			// }
-			panic("write me")
+			m.moveCursor(-1)
 		case event.Key() == tcell.KeyRune && event.Rune() == 'j':
-			// if m.persisted.Cursor < len(m.persisted.Items)-1 {
-			// 	m.persisted.Cursor++
-			// }
-			panic("write me")
+			m.moveCursor(1)
+		case event.Key() == tcell.KeyRune && event.Rune() == 'K':
+			m.moveSelected(func(i, _ int) int { return i - 1 })
+		case event.Key() == tcell.KeyRune && event.Rune() == 'J':
+			m.moveSelected(func(i, _ int) int { return i + 1 })
+		case event.Key() == tcell.KeyRune && event.Rune() == 'T':
+			m.moveSelected(func(_, _ int) int { return 0 })
+		case event.Key() == tcell.KeyRune && event.Rune() == 'B':
+			m.moveSelected(func(_, n int) int { return n - 1 })
+		case event.Key() == tcell.KeyTab || (event.Key() == tcell.KeyRune && event.Rune() == '>'):
+			m.indentSelected(m.items.Indent)
+		case event.Key() == tcell.KeyBacktab || (event.Key() == tcell.KeyRune && event.Rune() == '<'):
+			m.indentSelected(m.items.Outdent)
+		case event.Key() == tcell.KeyRune && event.Rune() == 'h':
+			m.collapseSelected()
+		case event.Key() == tcell.KeyRune && event.Rune() == 'l':
+			m.expandSelected()
 		case event.Key() == tcell.KeyRune && event.Rune() == 'x':
-			panic("write me")
-			// m.persisted.Items[m.persisted.Cursor].Done = !m.persisted.Items[m.persisted.Cursor].Done
+			if m.cursor != nil {
+				m.items.ToggleDone(*m.cursor)
+			}
+		case event.Key() == tcell.KeyRune && event.Rune() == 's':
+			if m.cursor != nil {
+				m.items.CycleState(*m.cursor)
+			}
 		case event.Key() == tcell.KeyRune && event.Rune() == 'a':
 			return &addModel{
 				list: m,
 			}
+		case event.Key() == tcell.KeyRune && event.Rune() == 'd':
+			m.deleteSelected()
+		case event.Key() == tcell.KeyRune && event.Rune() == 't':
+			return &trashModel{
+				list: m,
+			}
+		case event.Key() == tcell.KeyEnter:
+			if m.cursor != nil {
+				return &detailModel{
+					list: m,
+					id:   *m.cursor,
+				}
+			}
 		}
 	}
 	return m
 }
 
+// cursorIndex returns the index of the item under the cursor, or -1.
+func cursorIndex(items []replicatedtodo.TreeItem, cursor *uuid.UUID) int {
+	if cursor == nil {
+		return -1
+	}
+	return slices.IndexFunc(items, func(item replicatedtodo.TreeItem) bool {
+		return item.ID == *cursor
+	})
+}
+
+// visible returns the items shown in the list: the whole tree except the
+// descendants of collapsed items.
+func (m *listModel) visible() []replicatedtodo.TreeItem {
+	var items []replicatedtodo.TreeItem
+	hideBelow := -1
+	for _, item := range m.items.Tree() {
+		if hideBelow >= 0 {
+			if item.Depth > hideBelow {
+				continue
+			}
+			hideBelow = -1
+		}
+		items = append(items, item)
+		if item.HasChildren && m.collapsed[item.ID] {
+			hideBelow = item.Depth
+		}
+	}
+	return items
+}
+
+// moveCursor moves the cursor delta items down the list, stopping at either
+// end.
+func (m *listModel) moveCursor(delta int) {
+	items := m.visible()
+	if len(items) == 0 {
+		return
+	}
+	i := max(0, min(len(items)-1, cursorIndex(items, m.cursor)+delta))
+	m.cursor = &items[i].ID
+}
+
+// moveSelected moves the item under the cursor to the index among its
+// siblings returned by target, which is given the item's current index and
+// the number of siblings.
+func (m *listModel) moveSelected(target func(i, n int) int) {
+	items := m.items.Tree()
+	i := cursorIndex(items, m.cursor)
+	if i < 0 {
+		return
+	}
+	parent := items[i].Parent
+	siblings := slices.DeleteFunc(slices.Clone(items), func(item replicatedtodo.TreeItem) bool {
+		return item.Parent != parent
+	})
+	i = cursorIndex(siblings, m.cursor)
+	to := max(0, min(len(siblings)-1, target(i, len(siblings))))
+	if to == i {
+		return
+	}
+	rest := slices.Delete(siblings, i, i+1)
+	var previous uuid.UUID
+	if to > 0 {
+		previous = rest[to-1].ID
+	}
+	if err := m.items.MoveUnder(*m.cursor, parent, previous); err != nil {
+		slog.Error("Error moving item", slog.Any("error", err))
+	}
+}
+
+// indentSelected changes the level of the item under the cursor with
+// indent, which is ItemList.Indent or ItemList.Outdent.
+func (m *listModel) indentSelected(indent func(id uuid.UUID) error) {
+	if m.cursor == nil {
+		return
+	}
+	if err := indent(*m.cursor); err != nil {
+		slog.Error("Error indenting item", slog.Any("error", err))
+		return
+	}
+	// Keep the item in view under its new parent.
+	for _, item := range m.items.Tree() {
+		if item.ID == *m.cursor {
+			delete(m.collapsed, item.Parent)
+		}
+	}
+}
+
+// collapseSelected hides the children of the item under the cursor, or
+// moves the cursor to the item's parent if there are none to hide.
+func (m *listModel) collapseSelected() {
+	items := m.visible()
+	i := cursorIndex(items, m.cursor)
+	if i < 0 {
+		return
+	}
+	if items[i].HasChildren && !m.collapsed[items[i].ID] {
+		if m.collapsed == nil {
+			m.collapsed = make(map[uuid.UUID]bool)
+		}
+		m.collapsed[items[i].ID] = true
+		return
+	}
+	if parent := items[i].Parent; parent != (uuid.UUID{}) {
+		m.cursor = &parent
+	}
+}
+
+// expandSelected shows the children of the item under the cursor.
+func (m *listModel) expandSelected() {
+	if m.cursor != nil {
+		delete(m.collapsed, *m.cursor)
+	}
+}
+
+// deleteSelected moves the item under the cursor to the trash and selects
+// its neighbor.
+func (m *listModel) deleteSelected() {
+	if m.cursor == nil {
+		return
+	}
+	id := *m.cursor
+	m.moveCursor(1)
+	if *m.cursor == id {
+		m.moveCursor(-1)
+	}
+	m.items.Delete(id)
+	if *m.cursor == id {
+		m.cursor = nil
+	}
+}
+
+// stateMarkers is what the list shows between brackets for each state.
+var stateMarkers = map[replicatedtodo.State]string{
+	replicatedtodo.StateTodo:       " ",
+	replicatedtodo.StateInProgress: "~",
+	replicatedtodo.StateBlocked:    "!",
+	replicatedtodo.StateDone:       "x",
+	replicatedtodo.StateCancelled:  "-",
+}
+
 func (m *listModel) Draw(s tcell.Screen) {
 	style := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset)
 	screenExtent := ScreenExtent(s)
 
-	// If the cursor isn't valid take a random item from the item set.
-	if m.cursor == nil {
-		for _, item := range m.items.Items() {
-			m.cursor = &item.ID
+	items := m.visible()
+
+	// If the cursor isn't valid take the first item.
+	if cursorIndex(items, m.cursor) < 0 {
+		m.cursor = nil
+		if len(items) > 0 {
+			m.cursor = &items[0].ID
 		}
 	}
 
 	row := 0
-	for _, item := range m.items.Items() {
+	for _, item := range items {
 		cursor := " "
 		if item.ID == *m.cursor {
 			cursor = ">"
 		}
 
-		done := " "
-		// TODO: use a constant for this state value
-		if item.State == "completed" {
-			done = "x"
+		done := stateMarkers[item.State]
+
+		fold := " "
+		if item.HasChildren {
+			fold = "-"
+			if m.collapsed[item.ID] {
+				fold = "+"
+			}
+		}
+
+		line := fmt.Sprintf("%s %s%s [%s] %s", cursor, strings.Repeat("  ", item.Depth), fold, done, item.Title)
+		drawText(s, bounds{position{col: 0, row: row}, extent{width: screenExtent.width, height: 1}}, style, line)
+		row += 1
+	}
+
+	if m.cursor != nil {
+		replica, at := m.items.LastEdit(*m.cursor)
+		line := fmt.Sprintf("edited on %s %s", replica, ago(time.Since(at)))
+		drawText(s, bounds{position{col: 0, row: screenExtent.height - 1}, extent{width: screenExtent.width, height: 1}},
+			style, line)
+	}
+}
+
+// ago describes a duration in the past in the coarse terms people use.
+func ago(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	}
+}
+
+type trashModel struct {
+	list   *listModel
+	cursor int
+}
+
+func (m *trashModel) Update(screen tcell.Screen, event tcell.Event) model {
+	switch event := event.(type) {
+	case *tcell.EventKey:
+		items := m.list.items.DeletedItems()
+		switch {
+		case event.Key() == tcell.KeyEscape ||
+			event.Key() == tcell.KeyCtrlC ||
+			(event.Key() == tcell.KeyRune && (event.Rune() == 'q' || event.Rune() == 't')):
+			return m.list
+		case event.Key() == tcell.KeyRune && event.Rune() == 'k':
+			m.cursor = max(0, m.cursor-1)
+		case event.Key() == tcell.KeyRune && event.Rune() == 'j':
+			m.cursor = max(0, min(len(items)-1, m.cursor+1))
+		case event.Key() == tcell.KeyRune && event.Rune() == 'r':
+			if m.cursor < len(items) {
+				m.list.items.Restore(items[m.cursor].ID)
+				m.cursor = max(0, min(len(items)-2, m.cursor))
+			}
 		}
+	}
+	return m
+}
+
+func (m *trashModel) Draw(s tcell.Screen) {
+	style := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset)
+	screenExtent := ScreenExtent(s)
 
-		line := fmt.Sprintf("%s [%s] %s", cursor, done, item.Title)
+	drawText(s, bounds{position{col: 0, row: 0}, extent{width: screenExtent.width, height: 1}},
+		style, "Trash (r: restore, esc: back)")
+	row := 1
+	for i, item := range m.list.items.DeletedItems() {
+		cursor := " "
+		if i == m.cursor {
+			cursor = ">"
+		}
+		line := fmt.Sprintf("%s %s", cursor, item.Title)
 		drawText(s, bounds{position{col: 0, row: row}, extent{width: screenExtent.width, height: 1}}, style, line)
 		row += 1
 	}
 }
 
+// stateKeys maps the keys that set an item's state in the detail view to
+// the state they set.
+var stateKeys = map[rune]replicatedtodo.State{
+	'o': replicatedtodo.StateTodo,
+	'i': replicatedtodo.StateInProgress,
+	'b': replicatedtodo.StateBlocked,
+	'd': replicatedtodo.StateDone,
+	'c': replicatedtodo.StateCancelled,
+}
+
+// detailModel shows every field of one item and edits them.
+type detailModel struct {
+	list *listModel
+	id   uuid.UUID
+	// err is why the last edit failed, shown until the next key.
+	err error
+}
+
+// item returns the item being shown, if it is still in the list.
+func (m *detailModel) item() (replicatedtodo.Item, bool) {
+	items := m.list.items.Items()
+	i := slices.IndexFunc(items, func(item replicatedtodo.Item) bool {
+		return item.ID == m.id
+	})
+	if i < 0 {
+		return replicatedtodo.Item{}, false
+	}
+	return items[i], true
+}
+
+func (m *detailModel) Update(screen tcell.Screen, event tcell.Event) model {
+	item, ok := m.item()
+	if !ok {
+		return m.list
+	}
+	items := &m.list.items
+	switch event := event.(type) {
+	case *tcell.EventKey:
+		m.err = nil
+		switch {
+		case event.Key() == tcell.KeyEscape ||
+			event.Key() == tcell.KeyCtrlC ||
+			(event.Key() == tcell.KeyRune && event.Rune() == 'q'):
+			return m.list
+		case event.Key() == tcell.KeyRune && event.Rune() == 's':
+			items.CycleState(m.id)
+		case event.Key() == tcell.KeyRune && stateKeys[event.Rune()] != "":
+			if err := items.SetState(m.id, stateKeys[event.Rune()]); err != nil {
+				m.err = fmt.Errorf("could not set the state: %w", err)
+			}
+		case event.Key() == tcell.KeyRune && event.Rune() == '+':
+			items.SetPriority(m.id, item.Priority+1)
+		case event.Key() == tcell.KeyRune && event.Rune() == '-':
+			items.SetPriority(m.id, item.Priority-1)
+		case event.Key() == tcell.KeyRune && event.Rune() == 't':
+			return &promptModel{back: m, label: "Title", text: item.Title, done: func(title string) error {
+				items.SetTitle(m.id, title)
+				return nil
+			}}
+		case event.Key() == tcell.KeyRune && event.Rune() == 'n':
+			return &promptModel{back: m, label: "Notes", text: item.Notes, done: func(notes string) error {
+				items.SetNotes(m.id, notes)
+				return nil
+			}}
+		case event.Key() == tcell.KeyRune && event.Rune() == 'u':
+			var text string
+			if !item.Due.IsZero() {
+				text = item.Due.Format(time.DateOnly)
+			}
+			return &promptModel{back: m, label: "Due (YYYY-MM-DD, empty for none)", text: text, done: func(text string) error {
+				var due time.Time
+				if text != "" {
+					var err error
+					if due, err = time.ParseInLocation(time.DateOnly, text, time.Local); err != nil {
+						return fmt.Errorf("%q is not a date of the form YYYY-MM-DD", text)
+					}
+				}
+				items.SetDue(m.id, due)
+				return nil
+			}}
+		case event.Key() == tcell.KeyRune && event.Rune() == 'g':
+			return &promptModel{back: m, label: "Add tag", done: func(tag string) error {
+				if tag != "" {
+					items.AddTag(m.id, tag)
+				}
+				return nil
+			}}
+		case event.Key() == tcell.KeyRune && event.Rune() == 'G':
+			return &promptModel{back: m, label: "Remove tag", done: func(tag string) error {
+				items.RemoveTag(m.id, tag)
+				return nil
+			}}
+		}
+	}
+	return m
+}
+
+func (m *detailModel) Draw(s tcell.Screen) {
+	item, ok := m.item()
+	if !ok {
+		return
+	}
+	style := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset)
+	screenExtent := ScreenExtent(s)
+
+	due := "none"
+	if !item.Due.IsZero() {
+		due = item.Due.Format(time.DateOnly)
+	}
+	lines := []string{
+		item.Title,
+		"",
+		"State:    " + string(item.State),
+		"Due:      " + due,
+		fmt.Sprintf("Priority: %d", item.Priority),
+		"Tags:     " + strings.Join(item.Tags, ", "),
+		"",
+	}
+	lines = append(lines, strings.Split(item.Notes, "\n")...)
+	for row, line := range lines {
+		drawText(s, bounds{position{col: 0, row: row}, extent{width: screenExtent.width, height: 1}}, style, line)
+	}
+	if m.err != nil {
+		drawText(s, bounds{position{col: 0, row: screenExtent.height - 2}, extent{width: screenExtent.width, height: 1}},
+			style.Foreground(tcell.ColorRed), "Error: "+m.err.Error())
+	}
+	drawText(s, bounds{position{col: 0, row: screenExtent.height - 1}, extent{width: screenExtent.width, height: 1}},
+		style, "s: cycle state, o/i/b/d/c: todo/in progress/blocked/done/cancelled, t: title, n: notes, u: due, +/-: priority, g/G: add/remove tag, esc: back")
+}
+
+// promptModel reads a line of text and passes it to done, then returns to
+// back. If done returns an error, such as for text that does not parse,
+// the prompt stays open and shows it. Escape returns without calling done.
+type promptModel struct {
+	back  model
+	label string
+	text  string
+	done  func(text string) error
+	// err is the error done last returned, shown until the text changes.
+	err error
+}
+
+func (m *promptModel) Update(screen tcell.Screen, event tcell.Event) model {
+	switch event := event.(type) {
+	case *tcell.EventKey:
+		switch {
+		case event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyCtrlC:
+			return m.back
+		case event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2:
+			if len(m.text) > 0 {
+				_, size := utf8.DecodeLastRuneInString(m.text)
+				m.text = m.text[:len(m.text)-size]
+			}
+			m.err = nil
+		case event.Key() == tcell.KeyRune:
+			m.text += string(event.Rune())
+			m.err = nil
+		case event.Key() == tcell.KeyEnter:
+			if m.err = m.done(m.text); m.err != nil {
+				return m
+			}
+			return m.back
+		}
+	}
+	return m
+}
+
+func (m *promptModel) Draw(s tcell.Screen) {
+	screenExtent := ScreenExtent(s)
+	p := drawText(s, bounds{position{0, 0}, screenExtent}, tcell.StyleDefault, m.label+": "+m.text)
+	if m.err != nil {
+		drawText(s, bounds{position{col: 0, row: p.row + 1}, extent{width: screenExtent.width, height: 1}},
+			tcell.StyleDefault.Foreground(tcell.ColorRed), "Error: "+m.err.Error())
+	}
+	s.ShowCursor(p.col, p.row)
+}
+
 func (m *listModel) newTodo(title string) {
 	var previous uuid.UUID
 	if m.cursor != nil {
@@ -130,27 +576,91 @@ func (m *listModel) newTodo(title string) {
 	m.items.NewTodo(title, previous)
 }
 
-func (m *listModel) Save() error {
-	bytes, err := yaml.Marshal(&m.items)
+// addTodos adds an item after the cursor for each non-blank line of text,
+// in order, and moves the cursor to the last of them.
+func (m *listModel) addTodos(text string) {
+	var titles []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			titles = append(titles, line)
+		}
+	}
+	var previous uuid.UUID
+	if m.cursor != nil {
+		previous = *m.cursor
+	}
+	var added []*replicatedtodo.Item
+	var err error
+	switch len(titles) {
+	case 0:
+		return
+	case 1:
+		var item *replicatedtodo.Item
+		item, err = m.items.NewTodo(titles[0], previous)
+		added = []*replicatedtodo.Item{item}
+	default:
+		// Adding many items one after another would give them ever longer
+		// orders, so they are added together.
+		added, err = m.items.NewTodos(titles, previous)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal model: %w", err)
+		slog.Error("Error adding items", slog.Any("error", err))
+		return
 	}
-	err = os.WriteFile(UserDataFile(), bytes, os.FileMode(0600))
+	m.cursor = &added[len(added)-1].ID
+}
+
+// Save saves the list to wherever it was loaded from, and records whether
+// that succeeded for the status bar. Other sift processes on this machine
+// wait while it saves, and changes saved since the list was loaded, by
+// them or by a sync tool, are merged in first rather than overwritten.
+func (m *listModel) Save() error {
+	m.saveErr = m.lockedSave()
+	return m.saveErr
+}
+
+func (m *listModel) lockedSave() error {
+	lock, err := safefile.LockFile(UserLockFile())
 	if err != nil {
-		return fmt.Errorf("failed to save model: %w", err)
+		return err
 	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			slog.Error("Error unlocking", slog.Any("error", err))
+		}
+	}()
 
+	if err := m.MergeStored(); err != nil {
+		return err
+	}
+	m.items.Compact()
+	saving := m.items.VersionVector()
+	if err := m.save(); err != nil {
+		return err
+	}
+	m.saved = saving
 	return nil
 }
 
+func (m *listModel) save() error {
+	return m.store.Save(&m.items, m.saved)
+}
+
+// addModel reads the title of a new item, added after the cursor. Lines
+// pasted at once each add an item.
 type addModel struct {
 	list   *listModel
 	title  string
 	events []tcell.Event
+	// pasting is set between the start and end of a bracketed paste, when
+	// Enter starts a new line rather than adding the items.
+	pasting bool
 }
 
 func (m *addModel) Update(screen tcell.Screen, event tcell.Event) model {
 	switch event := event.(type) {
+	case *tcell.EventPaste:
+		m.pasting = event.Start()
 	case *tcell.EventKey:
 		m.events = append(m.events, event)
 		// If m.events has more than 5 elements remove the first one.
@@ -164,14 +674,16 @@ func (m *addModel) Update(screen tcell.Screen, event tcell.Event) model {
 		case event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2:
 			// Remove the last rune from m.title
 			if len(m.title) > 0 {
-				m.title = m.title[:len(m.title)-1]
+				_, size := utf8.DecodeLastRuneInString(m.title)
+				m.title = m.title[:len(m.title)-size]
 			}
 		case event.Key() == tcell.KeyRune:
 			m.title += string(event.Rune())
+		case event.Key() == tcell.KeyEnter && m.pasting:
+			m.title += "\n"
 		case event.Key() == tcell.KeyEnter:
-			panic("write me")
-			// m.list.persisted.Items = append(m.list.persisted.Items, todo{Title: m.title, Done: false})
-			// return m.list
+			m.list.addTodos(m.title)
+			return m.list
 		}
 	}
 	return m
@@ -184,8 +696,20 @@ func ScreenExtent(s tcell.Screen) extent {
 
 func (m *addModel) Draw(s tcell.Screen) {
 	screenSize := ScreenExtent(s)
-	line := fmt.Sprintf("Add new todo with title: %s", m.title)
-	p := drawText(s, bounds{position{0, 0}, screenSize}, tcell.StyleDefault, line)
+	lines := strings.Split(m.title, "\n")
+	var p position
+	for i, line := range lines {
+		if i == 0 {
+			line = "Add new todo with title: " + line
+		}
+		if p.col != 0 {
+			p.col = 0
+			p.row++
+		}
+		extent := screenSize
+		extent.height -= p.row
+		p = drawText(s, bounds{p, extent}, tcell.StyleDefault, line)
+	}
 	s.ShowCursor(p.col, p.row)
 
 	for _, e := range m.events {
@@ -213,6 +737,113 @@ func UserDataFile() string {
 	return filepath.Join(UserHomeDir(), ".sift.yaml")
 }
 
+// UserReplicaFile is where this installation's replica identity is kept. It
+// is separate from the data file so that copying the data file to another
+// machine does not copy the identity with it.
+func UserReplicaFile() string {
+	return filepath.Join(UserHomeDir(), ".sift-replica.yaml")
+}
+
+// UserLockFile is locked while the list is saved, so that sift processes
+// on this machine take turns, wherever the list is kept.
+func UserLockFile() string {
+	return filepath.Join(UserHomeDir(), ".sift.lock")
+}
+
+// UserReplicaLockFile is locked for as long as a sift process writes as
+// the nth replica of this installation. See claimReplica.
+func UserReplicaLockFile(n int) string {
+	return filepath.Join(UserHomeDir(), fmt.Sprintf(".sift-replica-%d.lock", n))
+}
+
+// replicaIdentity identifies this installation among the replicas of the
+// data file.
+type replicaIdentity struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// LoadReplicaIdentity reads this installation's replica identity, creating
+// and saving a new one on first use.
+func LoadReplicaIdentity() (replicaIdentity, error) {
+	var identity replicaIdentity
+	bytes, err := os.ReadFile(UserReplicaFile())
+	if err == nil {
+		if err = yaml.Unmarshal(bytes, &identity); err != nil {
+			return identity, fmt.Errorf("failed to unmarshal replica file: %w", err)
+		}
+		return identity, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return identity, fmt.Errorf("failed to read replica file: %w", err)
+	}
+
+	identity.ID = uuid.New()
+	identity.Name, err = os.Hostname()
+	if err != nil {
+		identity.Name = identity.ID.String()[:8]
+	}
+	bytes, err = yaml.Marshal(&identity)
+	if err != nil {
+		return identity, fmt.Errorf("failed to marshal replica file: %w", err)
+	}
+	if err = safefile.WriteFile(UserReplicaFile(), bytes, os.FileMode(0600)); err != nil {
+		return identity, fmt.Errorf("failed to save replica file: %w", err)
+	}
+	return identity, nil
+}
+
+// claimReplica returns the identity for this process to write as, and the
+// lock it holds on that identity until it exits.
+//
+// Version vectors, and with them autosave, reloading, the journal and
+// peer sync, rely on a replica's timestamps only growing, which two
+// processes writing as the same replica cannot ensure. So each sift
+// process running at once writes as a replica of its own: the first takes
+// the installation's identity, and the others take ones derived from it
+// and numbered by the first free lock file. Numbering reuses identities
+// rather than adding one to the replica table for every process.
+func claimReplica(installation replicaIdentity) (replicaIdentity, *safefile.Lock, error) {
+	for n := 0; ; n++ {
+		lock, err := safefile.TryLockFile(UserReplicaLockFile(n))
+		if errors.Is(err, safefile.ErrLocked) {
+			continue
+		}
+		if err != nil {
+			return installation, nil, err
+		}
+		if n == 0 {
+			return installation, lock, nil
+		}
+		return replicaIdentity{
+			ID:   uuid.NewSHA1(installation.ID, []byte(strconv.Itoa(n))),
+			Name: fmt.Sprintf("%s (%d)", installation.Name, n+1),
+		}, lock, nil
+	}
+}
+
+// otherProcessRunning reports whether another sift process is running,
+// holding one of the locks claimReplica takes.
+func otherProcessRunning() (bool, error) {
+	paths, err := filepath.Glob(filepath.Join(UserHomeDir(), ".sift-replica-*.lock"))
+	if err != nil {
+		return false, err
+	}
+	for _, path := range paths {
+		lock, err := safefile.TryLockFile(path)
+		if errors.Is(err, safefile.ErrLocked) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := lock.Unlock(); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
 func NewModel() listModel {
 	return listModel{
 		cursor:   nil,
@@ -222,26 +853,104 @@ func NewModel() listModel {
 	}
 }
 
-func LoadModel() listModel {
-	bytes, err := os.ReadFile(UserDataFile())
+// UserSyncDir returns the shared directory to keep the list in, or "" if
+// the list is kept in UserDataFile. It is set with the SIFT_SYNC_DIR
+// environment variable.
+func UserSyncDir() string {
+	return os.Getenv("SIFT_SYNC_DIR")
+}
+
+// UserGitDir returns the git working tree to keep the list in, or "" if
+// the list is not kept in git. It is set with the SIFT_GIT_DIR environment
+// variable.
+func UserGitDir() string {
+	return os.Getenv("SIFT_GIT_DIR")
+}
+
+// openGitRepo opens the repository at dir with this executable as its
+// merge driver.
+func openGitRepo(dir string) (*gitstore.Repo, error) {
+	exe, err := os.Executable()
 	if err != nil {
-		log.Printf("Failed to read model file: %v", err)
-		model := NewModel()
-		model.addSampleItems()
-		return model
+		return nil, err
 	}
+	return gitstore.Open(dir, gitstore.DriverCommand(exe))
+}
 
-	var items replicatedtodo.ItemList
-	if err = yaml.Unmarshal(bytes, &items); err != nil {
-		log.Printf("Failed to unmarshal model file: %v", err)
-		model := NewModel()
-		model.addSampleItems()
-		return model
+// UserJournalDir returns the directory to keep the list in as a journal of
+// changes, or "" if the list is not kept in a journal. It is set with the
+// SIFT_JOURNAL_DIR environment variable.
+func UserJournalDir() string {
+	return os.Getenv("SIFT_JOURNAL_DIR")
+}
+
+// Close releases what the list's store holds open, and the list's replica.
+func (m *listModel) Close() error {
+	err := m.store.Close()
+	if m.replicaLock != nil {
+		err = errors.Join(err, m.replicaLock.Unlock())
 	}
+	return err
+}
 
+// LoadModel loads the user's list from the store UserStore names. Until
+// this replica has saved to the store, the list it kept in UserDataFile is
+// brought along, and a new list of sample items is started only if there
+// is no list anywhere yet. A list that cannot be read, such as one saved by
+// a newer version of sift, is an error rather than being replaced, so that
+// saving cannot overwrite it.
+func LoadModel(identity replicaIdentity) (listModel, error) {
 	model := NewModel()
-	model.items = items
-	return model
+	model.identity = identity
+
+	// Opening a journal discards a torn final record, which must not race
+	// with another process appending one.
+	lock, err := safefile.LockFile(UserLockFile())
+	if err != nil {
+		return model, err
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			slog.Error("Error unlocking", slog.Any("error", err))
+		}
+	}()
+
+	st, err := openStore(UserStore(), identity)
+	if err != nil {
+		return model, err
+	}
+	items, err := st.Load()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		_ = st.Close()
+		return model, err
+	default:
+		model.items = items
+		model.markSaved()
+	}
+	model.store = st
+
+	fresh := errors.Is(err, fs.ErrNotExist)
+	if dir, ok := st.(*store.Dir); ok {
+		fresh = !dir.Joined()
+	}
+	if fresh && !isDataFile(st) {
+		legacy, err := listfile.Read(UserDataFile(), listfile.YAML)
+		switch {
+		case err == nil:
+			model.items.MergeStored(&legacy)
+		case !errors.Is(err, fs.ErrNotExist):
+			_ = st.Close()
+			return model, err
+		}
+	}
+
+	model.items.SetReplica(identity.ID, identity.Name)
+	if fresh && len(model.items.Items()) == 0 && len(model.items.DeletedItems()) == 0 {
+		model.addSampleItems()
+	}
+	return model, nil
 }
 
 func setUpLogging() *os.File {
@@ -270,7 +979,32 @@ func main() {
 	}()
 	slog.Info("program started")
 
-	listModel := LoadModel()
+	flags := flag.NewFlagSet("sift", flag.ContinueOnError)
+	flags.StringVar(&storeFlag, "store", "", storeUsage)
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	if flags.NArg() > 0 {
+		if err := runCommand(flags.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "sift: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	listModel, err := loadListModel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sift: %s\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := listModel.Close(); err != nil {
+			slog.Error("Error closing", slog.Any("error", err))
+		}
+	}()
 	slog.Info("Loaded model", slog.Any("model", listModel))
 	var model model = &listModel
 
@@ -282,6 +1016,9 @@ func main() {
 		log.Fatal(err)
 	}
 	defer s.Fini()
+	// Pasted lines arrive as bracketed paste, so the add prompt can tell
+	// them from lines typed one at a time.
+	s.EnablePaste()
 
 	// Set default text style
 	defStyle := tcell.StyleDefault.
@@ -291,11 +1028,33 @@ func main() {
 
 	s.Clear()
 
+	run(s, model, &listModel)
+
+	slog.Debug("program exiting")
+}
+
+// run draws model and handles events until the list model quits, saving
+// the list as it changes and once more on the way out.
+func run(s tcell.Screen, model model, listModel *listModel) {
+	autosave := newAutosaver(s, listModel.saveDelay())
+	defer autosave.stop()
+	stopNotify := notifyStop(s)
+	defer stopNotify()
+	if err := listModel.watchStored(s); err != nil {
+		slog.Error("Error watching for changes", slog.Any("error", err))
+	}
+	if listModel.Dirty() {
+		// Save a new list, such as the sample items, without waiting for an
+		// edit.
+		autosave.changed()
+	}
+
 	wasResize := false
 	for !listModel.quit {
 		// Update screen
 		s.Clear()
-		model.Draw(s)
+		s.HideCursor()
+		drawWithSaveStatus(s, model, listModel)
 		if wasResize {
 			s.Sync()
 			wasResize = false
@@ -307,15 +1066,37 @@ func main() {
 		ev := s.PollEvent()
 
 		// Process event
-		switch ev.(type) {
+		switch ev := ev.(type) {
+		case nil:
+			// The screen has been shut down.
+			listModel.quit = true
+			continue
 		case *tcell.EventResize:
 			wasResize = true
+		case *saveEvent:
+			if listModel.Dirty() {
+				if err := listModel.Save(); err != nil {
+					slog.Error("Error autosaving", slog.Any("error", err))
+				}
+			}
+			continue
+		case *reloadEvent:
+			if err := listModel.MergeStored(); err != nil {
+				slog.Error("Error reloading", slog.Any("error", err))
+			}
+			continue
+		case *stopEvent:
+			slog.Info("Stopping on signal", slog.String("signal", ev.signal.String()))
+			listModel.quit = true
+			continue
 		}
+		before := listModel.items.VersionVector()
 		model = model.Update(s, ev)
+		if !includesVector(before, listModel.items.VersionVector()) {
+			autosave.changed()
+		}
 	}
 	if err := listModel.Save(); err != nil {
 		slog.Error("Error saving", slog.Any("error", err))
 	}
-
-	slog.Debug("program exiting")
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/replicatedtodo"
+	"github.com/matta/sift/internal/store"
+)
+
+// typeKeys sends events to m, returning the model that handles the events
+// after them.
+func typeKeys(m model, events ...tcell.Event) model {
+	for _, event := range events {
+		m = m.Update(nil, event)
+	}
+	return m
+}
+
+// typeText returns key events typing text, with Enter for each newline.
+func typeText(text string) []tcell.Event {
+	var events []tcell.Event
+	for _, r := range text {
+		if r == '\n' {
+			events = append(events, tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+		} else {
+			events = append(events, tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	return events
+}
+
+func listTitles(items []replicatedtodo.Item) []string {
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
+
+func TestAddPrompt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m := openTestModel(t, store.NewMemory(), replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	m.newTodo("second")
+	m.newTodo("first")
+	m.cursor = &m.items.Items()[0].ID
+
+	key := tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone)
+	got := typeKeys(m, append([]tcell.Event{key}, typeText("new\n")...)...)
+	if got != m {
+		t.Errorf("model after Enter = %T, want the list", got)
+	}
+	want := []string{"first", "new", "second"}
+	if diff := cmp.Diff(want, listTitles(m.items.Items())); diff != "" {
+		t.Errorf("items after adding mismatch (-want, +got):\n%s", diff)
+	}
+	if added := m.items.Items()[1].ID; *m.cursor != added {
+		t.Errorf("cursor after adding = %s, want the new item %s", *m.cursor, added)
+	}
+
+	// Pasted lines each add an item, once Enter is pressed after the
+	// paste.
+	events := []tcell.Event{key, tcell.NewEventPaste(true)}
+	events = append(events, typeText("pasted 1\n\npasted 2\npasted 3")...)
+	events = append(events, tcell.NewEventPaste(false))
+	got = typeKeys(m, events...)
+	if got == m {
+		t.Fatalf("model after pasting is the list, want the prompt until Enter")
+	}
+	typeKeys(got, typeText("\n")...)
+	want = []string{"first", "new", "pasted 1", "pasted 2", "pasted 3", "second"}
+	if diff := cmp.Diff(want, listTitles(m.items.Items())); diff != "" {
+		t.Errorf("items after pasting mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Escape and blank titles add nothing.
+	typeKeys(m, key, tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone), tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	typeKeys(m, append([]tcell.Event{key}, typeText("  \n")...)...)
+	if diff := cmp.Diff(want, listTitles(m.items.Items())); diff != "" {
+		t.Errorf("items after cancelling mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// screenText returns what is drawn on s, one line per row.
+func screenText(s tcell.SimulationScreen) string {
+	s.Show()
+	cells, width, _ := s.GetContents()
+	var b strings.Builder
+	for i, cell := range cells {
+		if i > 0 && i%width == 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(string(cell.Runes))
+	}
+	return b.String()
+}
+
+func TestDuePromptShowsInvalidDate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	m := openTestModel(t, store.NewMemory(), replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	m.newTodo("todo")
+	m.cursor = &m.items.Items()[0].ID
+	s := newTestScreen(t)
+
+	enter := tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)
+	detail := typeKeys(m, enter)
+	prompt := typeKeys(detail, append([]tcell.Event{tcell.NewEventKey(tcell.KeyRune, 'u', tcell.ModNone)}, typeText("next week\n")...)...)
+	if _, ok := prompt.(*promptModel); !ok {
+		t.Fatalf("model after an invalid date = %T, want the prompt to stay open", prompt)
+	}
+	if due := m.items.Items()[0].Due; !due.IsZero() {
+		t.Errorf("due date after an invalid date = %s, want none", due)
+	}
+	s.Clear()
+	prompt.Draw(s)
+	if text := screenText(s); !strings.Contains(text, `"next week" is not a date`) {
+		t.Errorf("prompt after an invalid date shows\n%s\nwant the error", text)
+	}
+
+	var events []tcell.Event
+	for range len("next week") {
+		events = append(events, tcell.NewEventKey(tcell.KeyBackspace, 0, tcell.ModNone))
+	}
+	events = append(events, typeText("2024-07-01\n")...)
+	if got := typeKeys(prompt, events...); got != detail {
+		t.Errorf("model after a valid date = %T, want the detail view", got)
+	}
+	if due := m.items.Items()[0].Due.Format(time.DateOnly); due != "2024-07-01" {
+		t.Errorf("due date = %s, want 2024-07-01", due)
+	}
+}
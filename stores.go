@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matta/sift/internal/store"
+)
+
+// storeUsage describes the value of the --store flag.
+const storeUsage = "where to keep the list: yaml, json, bolt, journal, sync, git or memory, optionally followed by :path"
+
+// dataFileBackups is how many earlier versions of a file store's file are
+// kept, one taken at most every dataFileBackupInterval, so that they go
+// back a day or more rather than the last few autosaves.
+const (
+	dataFileBackups        = 24
+	dataFileBackupInterval = time.Hour
+)
+
+// storeFlag is the value of the --store flag.
+var storeFlag string
+
+// UserStore returns where to keep the list, as a kind of store optionally
+// followed by a colon and a path. It is set with the --store flag or the
+// SIFT_STORE environment variable; without either, SIFT_GIT_DIR,
+// SIFT_SYNC_DIR and SIFT_JOURNAL_DIR are checked in turn, and the list is
+// kept in UserDataFile if none of them is set.
+func UserStore() string {
+	if storeFlag != "" {
+		return storeFlag
+	}
+	if spec := os.Getenv("SIFT_STORE"); spec != "" {
+		return spec
+	}
+	switch {
+	case UserGitDir() != "":
+		return "git"
+	case UserSyncDir() != "":
+		return "sync"
+	case UserJournalDir() != "":
+		return "journal"
+	default:
+		return "yaml"
+	}
+}
+
+// openStore opens the store spec names, as returned by UserStore, for the
+// replica identity.
+func openStore(spec string, identity replicaIdentity) (store.Store, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+	inHome := func(name string) string {
+		return cmp.Or(path, filepath.Join(UserHomeDir(), name))
+	}
+	switch kind {
+	case "yaml":
+		return store.NewFile(cmp.Or(path, UserDataFile()), store.YAML, dataFileBackups, dataFileBackupInterval), nil
+	case "json":
+		return store.NewFile(inHome(".sift.json"), store.JSON, dataFileBackups, dataFileBackupInterval), nil
+	case "bolt":
+		return store.NewBolt(inHome(".sift.db")), nil
+	case "journal":
+		return store.OpenJournal(cmp.Or(path, UserJournalDir(), filepath.Join(UserHomeDir(), ".sift.journal")))
+	case "sync":
+		shared := cmp.Or(path, UserSyncDir())
+		if shared == "" {
+			return nil, errors.New("the sync store needs a directory: set SIFT_SYNC_DIR or use --store sync:dir")
+		}
+		// Replica files go in a subdirectory, so the shared directory can
+		// be one a sync tool already syncs, such as a whole Dropbox.
+		return store.NewDir(filepath.Join(shared, "sift"), identity.ID), nil
+	case "git":
+		dir := cmp.Or(path, UserGitDir())
+		if dir == "" {
+			return nil, errors.New("the git store needs a working tree: set SIFT_GIT_DIR or use --store git:dir")
+		}
+		repo, err := openGitRepo(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open git repository %s: %w", dir, err)
+		}
+		return store.NewGit(repo, "Update from "+identity.Name), nil
+	case "memory":
+		return store.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q; use yaml, json, bolt, journal, sync, git or memory", kind)
+	}
+}
+
+// isDataFile reports whether s keeps the list in UserDataFile.
+func isDataFile(s store.Store) bool {
+	file, ok := s.(*store.File)
+	return ok && file.Path() == UserDataFile()
+}
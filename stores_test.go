@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/matta/sift/internal/dirsync"
+	"github.com/matta/sift/internal/replicatedtodo"
+)
+
+func TestOpenStoreGitError(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// A file where the working tree should be cannot be opened as one.
+	path := filepath.Join(t.TempDir(), "not a directory")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	st, err := openStore("git:"+path, replicaIdentity{ID: uuid.New(), Name: "laptop"})
+	if err == nil {
+		_ = st.Close()
+		t.Fatalf("openStore(%q) = %T, want an error rather than another store", "git:"+path, st)
+	}
+}
+
+func TestOpenStoreSyncDir(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	identity := replicaIdentity{ID: uuid.New(), Name: "laptop"}
+	for _, test := range []struct {
+		name string
+		spec func(shared string) string
+	}{
+		{"flag", func(shared string) string { return "sync:" + shared }},
+		{"environment", func(shared string) string {
+			t.Setenv("SIFT_SYNC_DIR", shared)
+			return "sync"
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			shared := t.TempDir()
+			st, err := openStore(test.spec(shared), identity)
+			if err != nil {
+				t.Fatalf("openStore() error: %s", err)
+			}
+			defer st.Close()
+			var list replicatedtodo.ItemList
+			if err := st.Save(&list, nil); err != nil {
+				t.Fatalf("Save() error: %s", err)
+			}
+			// Both name the shared directory, with the replica files in
+			// its "sift" subdirectory.
+			if _, err := os.Stat(dirsync.ReplicaFile(filepath.Join(shared, "sift"), identity.ID)); err != nil {
+				t.Errorf("replica file not in the sift subdirectory: %s", err)
+			}
+		})
+	}
+}